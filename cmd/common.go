@@ -1,31 +1,83 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"net/url"
+	"runtime"
+	"runtime/debug"
 	"strings"
+	"sync"
 
+	"github.com/deitch/ocidist/pkg/formatutil"
+	"github.com/deitch/ocidist/pkg/traceutil"
 	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/spf13/cobra"
 )
 
 const (
 	digestTag           = "digest-without-tag"
-	FormatV1Tarball     = "v1-tarball"
-	FormatLegacyTarball = "legacy-tarball"
-	FormatV1Layout      = "v1-layout"
+	FormatV1Tarball     = formatutil.V1Tarball
+	FormatLegacyTarball = formatutil.LegacyTarball
+	FormatV1Layout      = formatutil.V1Layout
+	FormatOCIArchive    = formatutil.OCIArchive
 )
 
+// formats all of the formats in which we can read and write images locally
+var formats = []string{FormatV1Tarball, FormatLegacyTarball, FormatV1Layout, FormatOCIArchive}
+
 var showInfo, formatManifest bool
 
+// validateFormat check that format is one of the known formats
+func validateFormat(format string) error {
+	for _, f := range formats {
+		if f == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown format %q, must be one of: %s", format, strings.Join(formats, ", "))
+}
+
+// completeFormat shell completion for flags that take a format
+func completeFormat(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return formats, cobra.ShellCompDirectiveNoFileComp
+}
+
+// parsePlatform parse a platform per readPlatform, exiting if it is invalid
+func parsePlatform(s string) v1.Platform {
+	p, err := readPlatform(s)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return p
+}
+
+// readPlatform parse a platform in the format 'os/arch[/variant]', defaulting to linux on the
+// local architecture if it is empty
+func readPlatform(s string) (v1.Platform, error) {
+	if s == "" {
+		return v1.Platform{OS: "linux", Architecture: runtime.GOARCH}, nil
+	}
+	p, err := v1.ParsePlatform(s)
+	if err != nil {
+		return v1.Platform{}, fmt.Errorf("invalid platform %s: %v", s, err)
+	}
+	return *p, nil
+}
+
 func apiOptions() (bool, string, []remote.Option) {
 	var (
 		options = []remote.Option{}
 		msg     []string
 	)
 
-	if password == "" && username == "" && proxyUrl == "" && !httpClient && !anonymous && pullWriteFormat == FormatV1Tarball {
+	if password == "" && username == "" && proxyUrl == "" && !httpClient && !anonymous && !trace && traceFile == "" && pullWriteFormat == FormatV1Tarball {
 		return true, "simple API", nil
 	}
 
@@ -38,23 +90,92 @@ func apiOptions() (bool, string, []remote.Option) {
 	case username != "" || password != "":
 		msg = append(msg, "username password auth")
 		options = append(options, remote.WithAuth(authn.FromConfig(authn.AuthConfig{Username: username, Password: password})))
-	case !anonymous:
+	default:
 		msg = append(msg, "default keychain auth")
 		options = append(options, remote.WithAuthFromKeychain(authn.DefaultKeychain))
-	case httpClient:
-		msg = append(msg, "custom http.Client")
-		tr := &http.Transport{}
-		options = append(options, remote.WithTransport(tr))
+	}
+	tr, trMsg := apiBaseTransport()
+	msg = append(msg, trMsg...)
+	options = append(options, remote.WithTransport(tr))
+	return false, strings.Join(msg, " "), options
+}
+
+// apiBaseTransport the http.RoundTripper on which requests to registries are made, proxied or with a custom
+// http.Client, and traced, per the flags, with a description of each of those it does
+func apiBaseTransport() (http.RoundTripper, []string) {
+	var (
+		msg  []string
+		base = remote.DefaultTransport
+	)
+	switch {
 	case proxyUrl != "":
 		msg = append(msg, "custom http.Client with proxy")
 		proxy, err := url.Parse(proxyUrl)
 		if err != nil {
 			log.Fatalf("invalid proxy URL %s: %v", proxyUrl, err)
 		}
-		tr := &http.Transport{
-			Proxy: http.ProxyURL(proxy),
+		base = &http.Transport{Proxy: http.ProxyURL(proxy)}
+	case httpClient:
+		msg = append(msg, "custom http.Client")
+		base = &http.Transport{}
+	}
+	var traceOpts []traceutil.Option
+	if trace {
+		msg = append(msg, "tracing to stderr")
+		traceOpts = append(traceOpts, traceutil.WithLogger(log.Default()))
+	}
+	if traceFile != "" {
+		msg = append(msg, "tracing to HAR file "+traceFile)
+		traceOpts = append(traceOpts, traceutil.WithHAR(traceHAR()))
+	}
+	if len(traceOpts) > 0 {
+		base = traceutil.NewTransport(base, traceOpts...)
+	}
+	return base, msg
+}
+
+var (
+	traceHAROnce sync.Once
+	traceHARFile *traceutil.HAR
+)
+
+// traceHAR the HAR that --trace-file is written to, the same one however many transports are traced
+func traceHAR() *traceutil.HAR {
+	traceHAROnce.Do(func() {
+		var version string
+		if info, ok := debug.ReadBuildInfo(); ok {
+			version = info.Main.Version
+		}
+		var err error
+		if traceHARFile, err = traceutil.NewHAR(traceFile, "ocidist", version); err != nil {
+			log.Fatalf("unable to write trace to %s: %v", traceFile, err)
+		}
+	})
+	return traceHARFile
+}
+
+// apiTransport an http.RoundTripper for making requests directly to the registry of repo, beyond what the
+// go-containerregistry API offers, authenticated, proxied and traced per the same flags as apiOptions.
+func apiTransport(repo name.Repository) http.RoundTripper {
+	var (
+		auth authn.Authenticator
+		err  error
+	)
+	switch {
+	case anonymous:
+		auth = authn.Anonymous
+	case username != "" || password != "":
+		auth = authn.FromConfig(authn.AuthConfig{Username: username, Password: password})
+	default:
+		auth, err = authn.DefaultKeychain.Resolve(repo)
+		if err != nil {
+			log.Fatalf("unable to get credentials for %s: %v", repo, err)
 		}
-		options = append(options, remote.WithTransport(tr))
 	}
-	return false, strings.Join(msg, " "), options
+	base, _ := apiBaseTransport()
+	tr, err := transport.NewWithContext(context.Background(), repo.Registry, auth, base, []string{repo.Scope(transport.PullScope)})
+	if err != nil {
+		log.Fatalf("unable to connect to %s: %v", repo.Registry, err)
+	}
+	return tr
 }
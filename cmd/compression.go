@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/deitch/ocidist/pkg/imageutil"
+	"github.com/google/go-containerregistry/pkg/compression"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/spf13/cobra"
+)
+
+// compressions the layer compressions we can recompress to
+var compressions = []string{string(compression.GZip), string(compression.ZStd), string(compression.None)}
+
+// compressionFlags the flags for recompressing layers, shared by every command that writes images
+type compressionFlags struct {
+	compression      string
+	level            int
+	force            bool
+	estargz          bool
+	prioritizedFiles string
+}
+
+// addCompressionFlags register the recompression flags on cmd, saving them to c
+func addCompressionFlags(cmd *cobra.Command, c *compressionFlags) {
+	cmd.Flags().StringVar(&c.compression, "compression", "", fmt.Sprintf("recompress layers, can be one of %s; blank leaves layers as they are", strings.Join(compressions, ", ")))
+	cmd.RegisterFlagCompletionFunc("compression", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return compressions, cobra.ShellCompDirectiveNoFileComp
+	})
+	cmd.Flags().IntVar(&c.level, "compression-level", 0, "compression level to use with --compression, 0 uses the default for the algorithm")
+	cmd.Flags().BoolVar(&c.force, "force-recompress", false, "with --compression, recompress even those layers already in the target compression")
+}
+
+// addEstargzFlags register the flags for converting layers to eStargz on cmd, saving them to c. They are used along
+// with those from addCompressionFlags.
+func addEstargzFlags(cmd *cobra.Command, c *compressionFlags) {
+	cmd.Flags().BoolVar(&c.estargz, "estargz", false, "convert layers to eStargz for lazy pulling, adding the TOC digest annotations; implies --compression gzip")
+	cmd.Flags().StringVar(&c.prioritizedFiles, "estargz-prioritized-files", "", "with --estargz, path to a file listing the files to prefetch, one per line, in the order they are used, e.g. as recorded by 'ctr-remote optimize'")
+}
+
+// enabled whether any recompression was requested
+func (c compressionFlags) enabled() bool {
+	return c.compression != "" || c.estargz
+}
+
+// options the recompression options per the flags
+func (c compressionFlags) options() imageutil.CompressionOptions {
+	comp := c.compression
+	if comp == "" && c.estargz {
+		comp = string(compression.GZip)
+	}
+	opts := imageutil.CompressionOptions{Level: c.level, Force: c.force, Estargz: c.estargz}
+	if c.prioritizedFiles != "" {
+		if !c.estargz {
+			log.Fatal("--estargz-prioritized-files requires --estargz")
+		}
+		b, err := os.ReadFile(c.prioritizedFiles)
+		if err != nil {
+			log.Fatalf("unable to read prioritized files list %s: %v", c.prioritizedFiles, err)
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				opts.PrioritizedFiles = append(opts.PrioritizedFiles, line)
+			}
+		}
+	}
+	opts.Compression = parseCompression(comp)
+	if c.estargz && opts.Compression != compression.GZip {
+		log.Fatalf("--estargz requires gzip compression, not %s", comp)
+	}
+	return opts
+}
+
+// parseCompression comp as one of compressions, exiting if it is not
+func parseCompression(comp string) compression.Compression {
+	for _, known := range compressions {
+		if known == comp {
+			return compression.Compression(comp)
+		}
+	}
+	log.Fatalf("unknown compression %q, must be one of: %s", comp, strings.Join(compressions, ", "))
+	return ""
+}
+
+// recompress recompress the layers of add, which must be an image or an index, per the flags. If no
+// recompression was requested, add is returned as is.
+func (c compressionFlags) recompress(add mutate.Appendable) (mutate.Appendable, error) {
+	if !c.enabled() {
+		return add, nil
+	}
+	switch a := add.(type) {
+	case v1.ImageIndex:
+		return imageutil.RecompressIndex(a, c.options())
+	case v1.Image:
+		return imageutil.RecompressImage(a, c.options())
+	}
+	return nil, fmt.Errorf("neither an image nor an index")
+}
@@ -1,202 +1,311 @@
 package cmd
 
 import (
-	"archive/tar"
-	"encoding/json"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"log"
 	"os"
-	"path/filepath"
+	"strings"
 
+	"github.com/deitch/ocidist/pkg/formatutil"
+	"github.com/deitch/ocidist/pkg/imageutil"
+	"github.com/deitch/ocidist/pkg/layoututil"
 	legacytarball "github.com/google/go-containerregistry/pkg/legacy/tarball"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	v1tarball "github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	ocispecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/spf13/cobra"
 )
 
 var (
-	convertFromPath, convertToPath, convertToFormat, convertFromHash, convertTag string
+	convertFromPath, convertToPath, convertToFormat, convertFromHash, convertTag, convertPlatform string
+	convertAll                                                                                    bool
+	convertCompression                                                                            compressionFlags
 )
 
+// convertItem an image or an index to convert, with the tags under which to write it
+type convertItem struct {
+	tags []string
+	add  mutate.Appendable
+}
+
 var convertCmd = &cobra.Command{
 	Use:   "convert",
 	Short: "Convert a downloaded image from one format locally to another locally",
-	Long:  `Convert a downloaded image from one format locally to another locally`,
+	Long: `Convert a downloaded image from one format locally to another locally. Any of the formats can be converted to any other.
+The format of the input is detected from its content.
+If the input holds more than one image, as from 'docker save a b c', select one with --tag, or convert all of them with --all.
+Indexes are kept whole, with all of their platforms, when writing to a v1-layout or oci-archive. Tarballs cannot hold an index,
+so when writing to one, the image for --platform is taken from each index.
+With --compression, the layers are recompressed, and the manifests rewritten to match. With --estargz, the layers are converted
+to eStargz for lazy pulling, with the files listed in --estargz-prioritized-files first.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		var (
-			img v1.Image
-		)
-		inputFormat, err := guessFormat(convertFromPath)
+		if err := validateFormat(convertToFormat); err != nil {
+			log.Fatal(err)
+		}
+		if convertCompression.enabled() {
+			convertCompression.options()
+		}
+		input, err := formatutil.Detect(convertFromPath)
 		if err != nil {
 			log.Fatalf("unable to determine format of input file: %v", err)
 		}
-		switch inputFormat {
-		case FormatV1Layout:
-			p, err := layout.FromPath(convertFromPath)
-			if err != nil {
-				log.Fatalf("unable to get image from OCI layout on disk input: %v", err)
-			}
-			hash, err := v1.NewHash(convertFromHash)
-			if err != nil {
-				log.Fatalf("invalid hash %s: %v", convertFromHash, err)
-			}
-			img, err = p.Image(hash)
-			if err != nil {
-				log.Fatalf("unable to get image with hash %s from path %s: %v", hash.String(), convertFromPath, err)
-			}
-			if convertTag == "" {
-				log.Fatal("must provide a tag when converting from an OCI layout on disk")
-			}
+
+		var items []convertItem
+		switch input.Format {
+		case FormatV1Layout, FormatOCIArchive:
+			p, cleanup := openLayout(convertFromPath)
+			defer cleanup()
+			items = layoutItems(p)
 		case FormatV1Tarball:
-			img, err = v1tarball.ImageFromPath(convertFromPath, nil)
+			images, err := formatutil.TarballImages(formatutil.Opener(convertFromPath))
 			if err != nil {
-				log.Fatalf("unable to get image from tarball input: %v", err)
+				log.Fatalf("unable to get images from tarball input: %v", err)
 			}
-			// get the tag
-			if convertTag == "" {
-				tags, err := getTagsFromV1Tar(convertFromPath)
-				if err != nil {
-					log.Fatalf("unable to read tags from v1 tar at %s: %v", convertFromPath, err)
-				}
-				if len(tags) < 1 {
-					log.Fatalf("no tags in tar file at %s and none provided on command line", convertFromPath)
-				}
-				convertTag = tags[0]
+			items = selectTarballImages(images)
+		case FormatLegacyTarball:
+			images, err := formatutil.LegacyImages(formatutil.Opener(convertFromPath))
+			if err != nil {
+				log.Fatalf("unable to get images from legacy tarball input: %v", err)
 			}
+			items = selectTarballImages(images)
+		default:
+			log.Fatalf("reading input in format %s is not supported", input.Format)
 		}
 
-		// taken straight from pkg/crane.Save, but they don't have the options there
-		ref, err := name.ParseReference(convertTag)
-		if err != nil {
-			log.Fatalf("parsing reference %q: %v", convertTag, err)
-		}
-		tag, ok := ref.(name.Tag)
-		if !ok {
-			d, ok := ref.(name.Digest)
-			if !ok {
-				log.Fatalf("ref wasn't a tag or digest")
+		for i := range items {
+			if items[i].add, err = convertCompression.recompress(items[i].add); err != nil {
+				log.Fatalf("unable to recompress %s: %v", strings.Join(items[i].tags, ", "), err)
 			}
-			tag = d.Repository.Tag(digestTag)
 		}
 
-		// now write it to the output
-		switch convertToFormat {
-		case FormatV1Tarball:
-			err = v1tarball.WriteToFile(convertToPath, tag, img)
-		case FormatLegacyTarball:
-			var w *os.File
-			w, err = os.Create(convertToPath)
-			if err != nil {
-				log.Fatalf("unable to open %s to write legacy tar file: %v", convertToPath, err)
-			}
-			defer w.Close()
-			err = legacytarball.Write(tag, img, w)
-		default:
-			err = fmt.Errorf("unknown format: %s", convertToFormat)
-		}
+		err = writeLocal(convertToPath, convertToFormat, convertPlatform, items)
 		if err != nil {
 			log.Fatalf("failure to write to %s in format %s: %v", convertToPath, convertToFormat, err)
 		}
 
-		log.Printf("saved to %s as format %s", convertToPath, convertToFormat)
+		log.Printf("saved %d items to %s as format %s", len(items), convertToPath, convertToFormat)
 
 	},
 }
 
 func convertInit() {
 	// convertFromPath, convertToPath, convertToFormat
-	convertCmd.Flags().StringVar(&convertToPath, "to", "", "path to output save the converted image as a tar file")
+	convertCmd.Flags().StringVar(&convertToPath, "to", "", "path to output save the converted image, a tar file or layout directory")
 	convertCmd.MarkFlagRequired("to")
 	convertCmd.Flags().StringVar(&convertFromPath, "from", "", "path to input to convert, must be a tar file or layout directory")
 	convertCmd.MarkFlagRequired("from")
-	convertCmd.Flags().StringVar(&convertToFormat, "format", "v1", "format to save the image, can be one of 'v1' or 'legacy'")
-	convertCmd.Flags().StringVar(&convertFromHash, "hash", "", "when reading from an on-disk OCI layout, the hash of the image to extract, in 'sha256:<hash>' format")
-	convertCmd.Flags().StringVar(&convertTag, "tag", "", "when reading from an on-disk OCI layout, the tag of the image as to be saved")
+	convertCmd.Flags().StringVar(&convertToFormat, "format", FormatV1Tarball, "format to save the image, can be one of 'v1-tarball', 'legacy-tarball', 'v1-layout' or 'oci-archive'")
+	convertCmd.RegisterFlagCompletionFunc("format", completeFormat)
+	convertCmd.Flags().StringVar(&convertFromHash, "hash", "", "when reading from an on-disk OCI layout or oci-archive, the hash of the image or index to extract, in 'sha256:<hash>' format")
+	convertCmd.Flags().StringVar(&convertTag, "tag", "", "when reading from an on-disk OCI layout or oci-archive, the tag of the image as to be saved; when reading from a tarball, the tag of the image to select")
+	convertCmd.Flags().BoolVar(&convertAll, "all", false, "convert every image in the input, rather than just one, keeping all of their tags")
+	convertCmd.Flags().StringVar(&convertPlatform, "platform", "", "when writing an index to a tarball, the platform to take from it, in format 'os/arch[/variant]', defaults to linux on the local architecture")
+	addCompressionFlags(convertCmd, &convertCompression)
+	addEstargzFlags(convertCmd, &convertCompression)
 }
 
-func guessFormat(p string) (string, error) {
-	var (
-		fi  os.FileInfo
-		err error
-	)
-
-	// check our input file or directory exists
-	if fi, err = os.Stat(convertFromPath); os.IsNotExist(err) {
-		log.Fatalf("input path %s does not exist", convertFromPath)
+// writeLocal write items to the file or directory p in format, which must be one of the local formats. A tarball
+// cannot hold an index, so each index is resolved to its image for platform.
+func writeLocal(p, format, platform string, items []convertItem) error {
+	switch format {
+	case FormatV1Tarball:
+		return v1tarball.MultiRefWriteToFile(p, refsToImages(items, platform))
+	case FormatLegacyTarball:
+		w, err := os.Create(p)
+		if err != nil {
+			return fmt.Errorf("unable to open %s to write legacy tar file: %v", p, err)
+		}
+		defer w.Close()
+		return legacytarball.MultiWrite(refsToImages(items, platform), w)
+	case FormatV1Layout:
+		lp, err := layoututil.GetCache(p)
+		if err != nil {
+			return fmt.Errorf("unable to open %s to write layout: %v", p, err)
+		}
+		for _, item := range items {
+			for _, tag := range item.tags {
+				if err := appendToLayout(lp, tag, item.add); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	case FormatOCIArchive:
+		var adds []mutate.IndexAddendum
+		for _, item := range items {
+			for _, tag := range item.tags {
+				adds = append(adds, refAddendum(tag, item.add))
+			}
+		}
+		return writeOCIArchive(p, adds...)
 	}
+	return fmt.Errorf("writing output in format %s is not supported", format)
+}
 
-	if fi.IsDir() {
-		return FormatV1Layout, nil
+// appendToLayout add add, which must be an image or an index, to the layout at p, with the ref name tag
+func appendToLayout(p layout.Path, tag string, add mutate.Appendable) error {
+	annotations := layoututil.WithAnnotations(map[string]string{ocispecv1.AnnotationRefName: tag})
+	switch a := add.(type) {
+	case v1.ImageIndex:
+		return layoututil.AppendIndex(p, a, annotations)
+	case v1.Image:
+		return layoututil.AppendImage(p, a, annotations)
 	}
-
-	return FormatV1Tarball, nil
+	return fmt.Errorf("%s is neither an image nor an index", tag)
 }
 
-func getTagsFromV1Tar(tarfile string) ([]string, error) {
-	// open the tar file for reading
-	var (
-		f     *os.File
-		err   error
-		repob []byte
-	)
-	type tags map[string]string
-	type apps map[string]tags
-
-	// open the existing file
-	if f, err = os.Open(tarfile); err != nil {
-		return nil, err
+// layoutItems get the images and indexes to convert from a layout. With --all, that is every image or
+// index in its index.json with a ref name; otherwise, it is just the one given by --hash, tagged with --tag.
+func layoutItems(p layout.Path) []convertItem {
+	ii, err := p.ImageIndex()
+	if err != nil {
+		log.Fatalf("unable to read index of %s: %v", convertFromPath, err)
+	}
+	index, err := ii.IndexManifest()
+	if err != nil {
+		log.Fatalf("unable to read index of %s: %v", convertFromPath, err)
 	}
-	defer f.Close()
-
-	tr := tar.NewReader(f)
-	// cycle through until we find the "repositories" file
-tarloop:
-	for {
-		header, err := tr.Next()
-
-		switch {
-		// if no more files are found
-		case err == io.EOF:
-			break tarloop
-		case err != nil:
-			return nil, fmt.Errorf("error reading tar entry: %v", err)
-		case header == nil:
+
+	if !convertAll {
+		hash, err := v1.NewHash(convertFromHash)
+		if err != nil {
+			log.Fatalf("invalid hash %s: %v", convertFromHash, err)
+		}
+		if convertTag == "" {
+			log.Fatal("must provide a tag when converting from an OCI layout or oci-archive on disk")
+		}
+		// an index must be at the root; an image can be anywhere
+		var add mutate.Appendable
+		for _, desc := range index.Manifests {
+			if desc.Digest == hash && (desc.MediaType == types.OCIImageIndex || desc.MediaType == types.DockerManifestList) {
+				if add, err = ii.ImageIndex(hash); err != nil {
+					log.Fatalf("unable to get index with hash %s from path %s: %v", hash.String(), convertFromPath, err)
+				}
+			}
+		}
+		if add == nil {
+			if add, err = p.Image(hash); err != nil {
+				log.Fatalf("unable to get image with hash %s from path %s: %v", hash.String(), convertFromPath, err)
+			}
+		}
+		return []convertItem{{tags: []string{convertTag}, add: add}}
+	}
+
+	var items []convertItem
+	for _, desc := range index.Manifests {
+		refName := desc.Annotations[ocispecv1.AnnotationRefName]
+		if refName == "" {
+			log.Printf("skipping %s, which has no ref name", desc.Digest)
+			continue
+		}
+		var add mutate.Appendable
+		switch desc.MediaType {
+		case types.OCIImageIndex, types.DockerManifestList:
+			add, err = ii.ImageIndex(desc.Digest)
+		case types.OCIManifestSchema1, types.DockerManifestSchema2:
+			add, err = ii.Image(desc.Digest)
+		default:
+			log.Printf("skipping %s, which is neither an image nor an index", refName)
 			continue
-		// we only care about a regular file named "repositories"
-		case header.Typeflag == tar.TypeReg:
-			clean := filepath.Clean(header.Name)
-			// we only are looking at the repositories file
-			if clean != "repositories" {
+		}
+		if err != nil {
+			log.Fatalf("unable to get %s from path %s: %v", refName, convertFromPath, err)
+		}
+		items = append(items, convertItem{tags: []string{refName}, add: add})
+	}
+	return items
+}
+
+// selectTarballImages select which of the images from a tarball to convert. With --all, that is all of them
+// that have a tag. Otherwise, it is the one with the tag given by --tag, or the only one if there is just one.
+func selectTarballImages(images []formatutil.TaggedImage) []convertItem {
+	if convertAll {
+		var selected []convertItem
+		for i, ti := range images {
+			if len(ti.Tags) == 0 {
+				log.Printf("skipping image %d, which has no tags", i)
 				continue
 			}
-			repob, err = ioutil.ReadAll(tr)
-			if err != nil {
-				return nil, fmt.Errorf("error reading repositories file: %v", err)
+			selected = append(selected, convertItem{tags: ti.Tags, add: ti.Image})
+		}
+		return selected
+	}
+
+	if convertTag != "" {
+		want, err := name.NewTag(convertTag)
+		if err != nil {
+			log.Fatalf("invalid tag %s: %v", convertTag, err)
+		}
+		for _, ti := range images {
+			for _, tag := range ti.Tags {
+				if t, err := name.NewTag(tag); err == nil && t.Name() == want.Name() {
+					return []convertItem{{tags: []string{convertTag}, add: ti.Image}}
+				}
 			}
-			// we already saved the bytes, so break; we are done with the file
-			break tarloop
 		}
+		// with only one image, --tag is the tag under which to save it
+		if len(images) == 1 {
+			return []convertItem{{tags: []string{convertTag}, add: images[0].Image}}
+		}
+		log.Fatalf("no image with tag %s in %s", convertTag, convertFromPath)
 	}
 
-	// did we load anything?
-	if len(repob) == 0 {
-		return nil, nil
+	switch {
+	case len(images) == 0:
+		log.Fatalf("no images in tar file at %s", convertFromPath)
+	case len(images) > 1:
+		var tags []string
+		for _, ti := range images {
+			tags = append(tags, ti.Tags...)
+		}
+		log.Fatalf("%d images in tar file at %s, select one with --tag or use --all; tags: %s", len(images), convertFromPath, strings.Join(tags, ", "))
+	case len(images[0].Tags) == 0:
+		log.Fatalf("no tags in tar file at %s and none provided on command line", convertFromPath)
 	}
-	// load the json content of the "repositories" file into an apps struct
-	var repos apps
-	if err := json.Unmarshal(repob, &repos); err != nil {
-		return nil, fmt.Errorf("error unmarshaling repositories file")
+	return []convertItem{{tags: images[0].Tags, add: images[0].Image}}
+}
+
+// refsToImages get a map of every tag to its image, for writing to tarballs. As tarballs cannot hold
+// an index, each index is resolved to its image for platform.
+func refsToImages(items []convertItem, platform string) map[name.Reference]v1.Image {
+	refs := map[name.Reference]v1.Image{}
+	for _, item := range items {
+		var img v1.Image
+		switch a := item.add.(type) {
+		case v1.ImageIndex:
+			var err error
+			img, err = imageutil.ImageForPlatform(a, parsePlatform(platform))
+			if err != nil {
+				log.Fatalf("unable to get image for %s: %v", strings.Join(item.tags, ", "), err)
+			}
+		case v1.Image:
+			img = a
+		}
+		for _, t := range item.tags {
+			refs[toTag(t)] = img
+		}
 	}
+	return refs
+}
 
-	tagList := make([]string, 0)
-	for reponame, v := range repos {
-		for repotag := range v {
-			tagList = append(tagList, fmt.Sprintf("%s:%s", reponame, repotag))
+// toTag convert an image reference to a tag, as tarballs can only hold tags
+func toTag(image string) name.Tag {
+	// taken straight from pkg/crane.Save, but they don't have the options there
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		log.Fatalf("parsing reference %q: %v", image, err)
+	}
+	tag, ok := ref.(name.Tag)
+	if !ok {
+		d, ok := ref.(name.Digest)
+		if !ok {
+			log.Fatalf("ref wasn't a tag or digest")
 		}
+		tag = d.Repository.Tag(digestTag)
 	}
-	return tagList, nil
+	return tag
 }
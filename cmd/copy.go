@@ -2,13 +2,21 @@ package cmd
 
 import (
 	"crypto/sha256"
+	"fmt"
 	"log"
 
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/spf13/cobra"
 )
 
+var (
+	copyCompression compressionFlags
+	copyPolicy      string
+)
+
 var copyCmd = &cobra.Command{
 	Use:   "copy <from:tag> <to-tag>",
 	Short: "copy a tag on a registry from one to another, creating the new one",
@@ -16,6 +24,11 @@ var copyCmd = &cobra.Command{
 just be a tag, not a full name. For example:
 
 copy docker.io/foo/bar:sometag othertag
+
+With --compression or --estargz, the layers are recompressed and pushed, along with the rewritten manifests, rather than just tagging the
+same root manifest.
+
+With --policy, the image is first checked against the rules in the policy file, and not copied if it breaks any of them.
 `,
 	Args: cobra.MinimumNArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
@@ -28,6 +41,9 @@ copy docker.io/foo/bar:sometag othertag
 		)
 
 		image, to := args[0], args[1]
+		if copyCompression.enabled() {
+			copyCompression.options()
+		}
 		ref, err = name.ParseReference(image)
 		if err != nil {
 			log.Fatalf("parsing from reference %q: %v", image, err)
@@ -37,6 +53,10 @@ copy docker.io/foo/bar:sometag othertag
 		_, msg, options := apiOptions()
 
 		log.Println(msg)
+		// copy just what was checked, even if the tag has moved since
+		if digest := enforcePolicy(copyPolicy, ref, options); digest != (v1.Hash{}) {
+			ref = ref.Context().Digest(digest.String())
+		}
 		desc, err = remote.Get(ref, options...)
 		if err != nil {
 			log.Fatalf("error getting manifest: %v", err)
@@ -51,6 +71,14 @@ copy docker.io/foo/bar:sometag othertag
 
 		log.Printf("totag: %#v", totag)
 
+		if copyCompression.enabled() {
+			if err := copyRecompressed(desc, totag, options); err != nil {
+				log.Fatalf("error pushing up recompressed %s: %v", to, err)
+			}
+			log.Printf("done, copied %s to %s with recompressed layers", image, to)
+			return
+		}
+
 		if err := remote.Tag(totag, desc, options...); err != nil {
 			log.Fatalf("error pushing up new tag %s: %v", to, err)
 		}
@@ -59,4 +87,41 @@ copy docker.io/foo/bar:sometag othertag
 }
 
 func copyInit() {
+	addCompressionFlags(copyCmd, &copyCompression)
+	addEstargzFlags(copyCmd, &copyCompression)
+	addPolicyFlag(copyCmd, &copyPolicy)
+}
+
+// copyRecompressed recompress the image or index at desc, and push it to tag
+func copyRecompressed(desc *remote.Descriptor, tag name.Tag, options []remote.Option) error {
+	var add mutate.Appendable
+	if desc.MediaType.IsIndex() {
+		ii, err := desc.ImageIndex()
+		if err != nil {
+			return err
+		}
+		add = ii
+	} else {
+		img, err := desc.Image()
+		if err != nil {
+			return err
+		}
+		add = img
+	}
+	add, err := copyCompression.recompress(add)
+	if err != nil {
+		return err
+	}
+	return pushAppendable(tag, add, options)
+}
+
+// pushAppendable push add, which must be an image or an index, to the registry as ref
+func pushAppendable(ref name.Reference, add mutate.Appendable, options []remote.Option) error {
+	switch a := add.(type) {
+	case v1.ImageIndex:
+		return remote.WriteIndex(ref, a, options...)
+	case v1.Image:
+		return remote.Write(ref, a, options...)
+	}
+	return fmt.Errorf("%s is neither an image nor an index", ref)
 }
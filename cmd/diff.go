@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/deitch/ocidist/pkg/imageutil"
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffSource imageSourcePair
+	diffOutput string
+	diffLayers bool
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <refA> <refB>",
+	Short: "Show what changed in the filesystem between two images",
+	Long: `Compare the filesystems of two images, each in a registry or, with --path-a or --path-b, in a local layout, oci-archive or tarball,
+with all of its layers applied, including whiteouts. Reports every path added, removed or modified going from <refA> to <refB>, and for a modified path
+whether its type, content, mode, owner or link target changed. Modification times are not compared.
+With --layers, compares just the layer digests instead, showing which layers are shared, and which are in only one of the images.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		validateOutput(diffOutput)
+		imgA, imgB, cleanup := diffSource.images(args[0], args[1])
+		defer cleanup()
+
+		if diffLayers {
+			changes := imageutil.DiffLayers(layerDigests(imgA, args[0]), layerDigests(imgB, args[1]))
+			if diffOutput == outputJSON {
+				writeJSON(changes)
+				return
+			}
+			for _, c := range changes {
+				switch {
+				case c.Shared():
+					fmt.Printf("= %s a:%d b:%d\n", c.Digest, c.A, c.B)
+				case c.A >= 0:
+					fmt.Printf("- %s a:%d\n", c.Digest, c.A)
+				default:
+					fmt.Printf("+ %s b:%d\n", c.Digest, c.B)
+				}
+			}
+			return
+		}
+
+		changes, err := imageutil.Diff(imageIndex(imgA, args[0]), imageIndex(imgB, args[1]))
+		if err != nil {
+			log.Fatalf("unable to compare %s and %s: %v", args[0], args[1], err)
+		}
+		if diffOutput == outputJSON {
+			if changes == nil {
+				changes = []imageutil.FileChange{}
+			}
+			writeJSON(changes)
+			return
+		}
+		for _, c := range changes {
+			switch c.Change {
+			case imageutil.ChangeAdded:
+				fmt.Printf("+ /%s\n", c.Path)
+			case imageutil.ChangeRemoved:
+				fmt.Printf("- /%s\n", c.Path)
+			default:
+				fmt.Printf("M /%s (%s)\n", c.Path, strings.Join(c.Fields, ", "))
+			}
+		}
+	},
+}
+
+func diffInit() {
+	diffSource.addFlags(diffCmd)
+	addOutputFlag(diffCmd, &diffOutput)
+	diffCmd.Flags().BoolVar(&diffLayers, "layers", false, "compare the layer digests of the images, rather than their filesystems")
+}
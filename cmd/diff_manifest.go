@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/deitch/ocidist/pkg/imageutil"
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffManifestSource imageSourcePair
+	diffManifestOutput string
+)
+
+var diffManifestCmd = &cobra.Command{
+	Use:   "diff-manifest <refA> <refB>",
+	Short: "Show what changed in the manifest and config between two images",
+	Long: `Compare the manifests and configs of two images, each in a registry or, with --path-a or --path-b, in a local layout, oci-archive
+or tarball, resolving an index to the image for --platform. Reports changes going from <refA> to <refB> to the layers, by digest, the
+annotations, env, labels, entrypoint, cmd, working dir, user, exposed ports, platform, created time and media types.
+As diff(1) does, exits with status 1 if there are any changes, so it can gate CI, and with status 2 on any error, including a bad
+argument or flag.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if err := cobra.ExactArgs(2)(cmd, args); err != nil {
+			diffManifestFatal(err)
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := checkOutput(diffManifestOutput); err != nil {
+			diffManifestFatal(err)
+		}
+		imgA, imgB, cleanup, err := diffManifestSource.load(args[0], args[1])
+		if err != nil {
+			diffManifestFatal(err)
+		}
+		defer cleanup()
+
+		changes, err := imageutil.DiffMetadata(imgA, imgB)
+		if err != nil {
+			cleanup()
+			diffManifestFatal(fmt.Errorf("unable to compare %s and %s: %v", args[0], args[1], err))
+		}
+		if diffManifestOutput == outputJSON {
+			if changes == nil {
+				changes = []imageutil.MetadataChange{}
+			}
+			writeJSON(changes)
+		} else {
+			for _, c := range changes {
+				fmt.Println(metadataChangeLine(c))
+			}
+		}
+		if len(changes) > 0 {
+			// os.Exit skips the deferred cleanups
+			cleanup()
+			os.Exit(1)
+		}
+	},
+}
+
+func diffManifestInit() {
+	diffManifestSource.addFlags(diffManifestCmd)
+	addOutputFlag(diffManifestCmd, &diffManifestOutput)
+	diffManifestCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		diffManifestFatal(err)
+		return nil
+	})
+}
+
+// diffManifestFatal log err and exit with status 2, as diff(1) does on trouble, so that it is not taken for the
+// status 1 of a difference. Like os.Exit, it skips any deferred cleanups.
+func diffManifestFatal(err error) {
+	log.Print(err)
+	os.Exit(2)
+}
+
+// metadataChangeLine c as a line of text: "+ field key=b", "- field key=a" or "M field key: a -> b", leaving out
+// the key for fields that have just one value
+func metadataChangeLine(c imageutil.MetadataChange) string {
+	field := c.Field
+	if c.Key != "" {
+		field += " " + c.Key
+	}
+	if c.Field == imageutil.MetadataLayer {
+		switch c.Change {
+		case imageutil.ChangeAdded:
+			return fmt.Sprintf("+ %s b:%s", field, c.B)
+		case imageutil.ChangeRemoved:
+			return fmt.Sprintf("- %s a:%s", field, c.A)
+		default:
+			return fmt.Sprintf("M %s a:%s b:%s", field, c.A, c.B)
+		}
+	}
+	switch c.Change {
+	case imageutil.ChangeAdded:
+		return fmt.Sprintf("+ %s=%s", field, c.B)
+	case imageutil.ChangeRemoved:
+		return fmt.Sprintf("- %s=%s", field, c.A)
+	default:
+		return fmt.Sprintf("M %s: %s -> %s", field, c.A, c.B)
+	}
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/deitch/ocidist/pkg/imageutil"
+	"github.com/spf13/cobra"
+)
+
+var fsSource imageSource
+
+var fsCmd = &cobra.Command{
+	Use:   "fs",
+	Short: "Browse the filesystem of an image without extracting it",
+	Long: `List, read and search the filesystem of an image, in a registry or, with --path, in a local layout, oci-archive or tarball, as it
+is with all of its layers applied, including whiteouts. Only the headers of each layer are kept, and layers are read again only to get the
+content of a file. Each result shows the layer that contributed it, by position, lowest first, and digest.`,
+}
+
+func fsInit() {
+	fsCmd.AddCommand(fsLsCmd)
+	fsLsInit()
+	fsCmd.AddCommand(fsCatCmd)
+	fsCatInit()
+	fsCmd.AddCommand(fsFindCmd)
+	fsFindInit()
+}
+
+// fsImage index the filesystem of the image ref, per fsSource, and get a label for each of its layers.
+// The returned cleanup func removes any temporary files.
+func fsImage(ref string) (*imageutil.Index, []string, func()) {
+	img, cleanup := fsSource.image(ref)
+	var labels []string
+	for i, digest := range layerDigests(img, ref) {
+		labels = append(labels, fmt.Sprintf("%d:%s", i, digest.Hex[:12]))
+	}
+	return imageIndex(img, ref), labels, cleanup
+}
+
+// fsLayer the label of the layer that contributed name in idx
+func fsLayer(idx *imageutil.Index, labels []string, name string) string {
+	layer, ok := idx.Layer(name)
+	if !ok || layer < 0 {
+		return "-"
+	}
+	return labels[layer]
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"io"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var fsCatCmd = &cobra.Command{
+	Use:   "cat <ref> <path>",
+	Short: "Write a file in the filesystem of an image to stdout",
+	Long: `Write the content of the regular file path in the filesystem of the image to stdout. Symlinks are followed within the image.
+Only the layer that contributed the file is read again, and only as far as the file. Which layer that is goes to stderr.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ref, p := args[0], args[1]
+		idx, labels, cleanup := fsImage(ref)
+		defer cleanup()
+
+		resolved, err := idx.Resolve(p)
+		if err != nil {
+			log.Fatalf("unable to find %s: %v", p, err)
+		}
+		rc, err := idx.Open(resolved)
+		if err != nil {
+			log.Fatalf("unable to open %s: %v", p, err)
+		}
+		defer rc.Close()
+		log.Printf("/%s from layer %s", resolved, fsLayer(idx, labels, resolved))
+		if _, err := io.Copy(os.Stdout, rc); err != nil {
+			log.Fatalf("unable to read %s: %v", p, err)
+		}
+	},
+}
+
+func fsCatInit() {
+	fsSource.addFlags(fsCatCmd)
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"archive/tar"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	fsFindName, fsFindRegex string
+	fsFindLong              bool
+)
+
+var fsFindCmd = &cobra.Command{
+	Use:   "find <ref> [path]",
+	Short: "Find files in the filesystem of an image",
+	Long: `Find everything under path, by default the root, in the filesystem of the image, whose name matches the shell pattern --name, and
+whose full path matches the regular expression --regex. Each result is followed by the layer that contributed it.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ref, p := args[0], "/"
+		if len(args) > 1 {
+			p = args[1]
+		}
+		if _, err := path.Match(fsFindName, ""); err != nil {
+			log.Fatalf("invalid --name pattern %q: %v", fsFindName, err)
+		}
+		re, err := regexp.Compile(fsFindRegex)
+		if err != nil {
+			log.Fatalf("invalid --regex %q: %v", fsFindRegex, err)
+		}
+		idx, labels, cleanup := fsImage(ref)
+		defer cleanup()
+
+		resolved, err := idx.Resolve(p)
+		if err != nil {
+			log.Fatalf("unable to find %s: %v", p, err)
+		}
+		err = idx.Walk(func(hdr *tar.Header) error {
+			if resolved != "" && hdr.Name != resolved && !strings.HasPrefix(hdr.Name, resolved+"/") {
+				return nil
+			}
+			if fsFindName != "" {
+				if ok, _ := path.Match(fsFindName, path.Base(hdr.Name)); !ok {
+					return nil
+				}
+			}
+			if !re.MatchString("/" + hdr.Name) {
+				return nil
+			}
+			layer := fsLayer(idx, labels, hdr.Name)
+			if fsFindLong {
+				fmt.Println(fsLine(hdr, layer))
+			} else {
+				fmt.Printf("/%s\t%s\n", hdr.Name, layer)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("unable to search %s: %v", ref, err)
+		}
+	},
+}
+
+func fsFindInit() {
+	fsSource.addFlags(fsFindCmd)
+	fsFindCmd.Flags().StringVar(&fsFindName, "name", "", "shell pattern, as for find -name, that the name of each result must match")
+	fsFindCmd.Flags().StringVar(&fsFindRegex, "regex", "", "regular expression that the full path of each result, starting with /, must match")
+	fsFindCmd.Flags().BoolVarP(&fsFindLong, "long", "l", false, "show the mode, owner, size and modification time of each result, as for fs ls")
+}
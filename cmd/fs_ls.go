@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"archive/tar"
+	"fmt"
+	"log"
+
+	"github.com/deitch/ocidist/pkg/imageutil"
+	"github.com/spf13/cobra"
+)
+
+var fsLsCmd = &cobra.Command{
+	Use:   "ls <ref> [path]",
+	Short: "List a directory in the filesystem of an image",
+	Long: `List what is in the directory path, by default the root, of the filesystem of the image, with the mode, owner, size and modification
+time of each, and the layer that contributed it. Symlinks in path are followed within the image. If path is not a directory, lists just it.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ref, p := args[0], "/"
+		if len(args) > 1 {
+			p = args[1]
+		}
+		idx, labels, cleanup := fsImage(ref)
+		defer cleanup()
+
+		resolved, err := idx.Resolve(p)
+		if err != nil {
+			log.Fatalf("unable to find %s: %v", p, err)
+		}
+		hdrs, err := idx.ReadDir(resolved)
+		if err != nil {
+			hdr, ok := idx.Lookup(resolved)
+			if !ok {
+				log.Fatalf("unable to list %s: %v", p, err)
+			}
+			hdrs = []*tar.Header{hdr}
+		}
+		for _, hdr := range hdrs {
+			fmt.Println(fsLine(hdr, fsLayer(idx, labels, hdr.Name)))
+		}
+	},
+}
+
+func fsLsInit() {
+	fsSource.addFlags(fsLsCmd)
+}
+
+// fsLine a line describing hdr, in the style of ls -l, including the layer that contributed it
+func fsLine(hdr *tar.Header, layer string) string {
+	name := "/" + imageutil.CleanPath(hdr.Name)
+	switch hdr.Typeflag {
+	case tar.TypeSymlink:
+		name += " -> " + hdr.Linkname
+	case tar.TypeLink:
+		name += " link to /" + hdr.Linkname
+	}
+	return fmt.Sprintf("%s %5d %5d %10d %s %-15s %s", hdr.FileInfo().Mode(), hdr.Uid, hdr.Gid, hdr.Size, hdr.ModTime.UTC().Format("2006-01-02 15:04"), layer, name)
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/deitch/ocidist/pkg/imageutil"
+	"github.com/spf13/cobra"
+)
+
+var (
+	historySource  imageSource
+	historyOutput  string
+	historyNoTrunc bool
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history <ref>",
+	Short: "Show the history of an image, with each entry aligned with the layer it created",
+	Long: `Show the history of an image, in a registry or, with --path, in a local layout, oci-archive or tarball, newest first, like docker history.
+Each history entry that created a layer is shown with that layer's position, digest and size; entries marked as empty layers, such as ENV
+or CMD, have none. If the number of entries that created a layer is not the number of layers, a warning is printed, as the alignment
+is then a guess.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ref := args[0]
+		validateOutput(historyOutput)
+		img, cleanup := historySource.image(ref)
+		defer cleanup()
+		h, err := imageutil.History(img)
+		if err != nil {
+			log.Fatalf("unable to get history of %s: %v", ref, err)
+		}
+		if h.Mismatched() {
+			log.Printf("warning: %s has %d layers, but %d history entries that created a layer", ref, h.Layers, h.NonEmpty)
+		}
+		if historyOutput == outputJSON {
+			writeJSON(h)
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "LAYER\tDIGEST\tSIZE\tCREATED\tCREATED BY\tCOMMENT")
+		for i := len(h.Entries) - 1; i >= 0; i-- {
+			e := h.Entries[i]
+			layer, digest, size := "-", "-", "0 B"
+			if e.Layer != nil {
+				layer, digest, size = fmt.Sprint(e.Index), e.Layer.Digest.String(), humanSize(e.Layer.Size)
+			}
+			created := "-"
+			if !e.Created.IsZero() {
+				created = e.Created.UTC().Format("2006-01-02T15:04:05Z")
+			}
+			createdBy := strings.ReplaceAll(e.CreatedBy, "\n", "; ")
+			if !historyNoTrunc {
+				if e.Layer != nil {
+					digest = digest[:len("sha256:")+12]
+				}
+				createdBy = truncate(createdBy, 60)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", layer, digest, size, created, createdBy, e.Comment)
+		}
+		w.Flush()
+	},
+}
+
+func historyInit() {
+	historySource.addFlags(historyCmd)
+	addOutputFlag(historyCmd, &historyOutput)
+	historyCmd.Flags().BoolVar(&historyNoTrunc, "no-trunc", false, "do not truncate digests and commands")
+}
+
+// truncate s to at most n runes, marking any truncation with "..."
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-3]) + "..."
+}
@@ -1,15 +1,13 @@
 package cmd
 
 import (
-	"io"
 	"log"
 	"os"
 	"runtime"
 
+	"github.com/deitch/ocidist/pkg/imageutil"
 	"github.com/deitch/ocidist/pkg/layoututil"
 
-	"github.com/google/go-containerregistry/pkg/v1/mutate"
-
 	"github.com/spf13/cobra"
 )
 
@@ -18,22 +16,36 @@ var (
 	rootDir      string
 	targetPath   string
 	architecture string
+	outputDir    string
+	unpackOpts   imageutil.UnpackOptions
 )
 
 var mergeImageCmd = &cobra.Command{
 	Use:   "merge <ref>",
-	Short: "merge the layers of an image in a local layout into a single tar file, applying all layers",
-	Long: `For an image located locally in a v1/layout, merge all of the layers of the the image to get a single tar file representing the image filesystem
-If the provided image is an index, will use the provided architecture, defaulting to the local machine architecture.`,
+	Short: "merge the layers of an image in a local layout into a single tar file or directory, applying all layers",
+	Long: `For an image located locally in a v1/layout or oci-archive, merge all of the layers of the the image to get a single tar file representing the image filesystem
+If the provided image is an index, will use the provided architecture, defaulting to the local machine architecture.
+With --output-dir, unpacks the merged filesystem into a directory instead, for use as a rootfs. Nothing in the image
+can write outside of that directory, whether by .. in paths or by symlinks, including absolute ones.`,
 	Args: cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		imageName := args[0]
-
-		// get the cache
-		p, err := layoututil.GetCache(layoutPath)
-		if err != nil {
-			log.Fatalf("unable to get v1 layout at %s: %v", layoutPath, err)
+		if (targetPath == "") == (outputDir == "") {
+			log.Fatal("exactly one of --target or --output-dir is required")
+		}
+		if unpackOpts.Owners && outputDir == "" {
+			log.Fatal("--preserve-ownership requires --output-dir")
 		}
+		if unpackOpts.Xattrs && outputDir == "" {
+			log.Fatal("--xattrs requires --output-dir")
+		}
+		if unpackOpts.Owners && os.Geteuid() != 0 {
+			log.Fatal("--preserve-ownership requires running as root")
+		}
+
+		// get the layout, which may be a directory or an oci-archive
+		p, cleanup := openLayout(layoutPath)
+		defer cleanup()
 
 		// get a reference to the image
 		image, err := layoututil.FindImageFromRoot(p, imageName, architecture)
@@ -41,22 +53,43 @@ If the provided image is an index, will use the provided architecture, defaultin
 			log.Fatalf("unable to get root image for %s at %s: %v", imageName, layoutPath, err)
 		}
 
+		layers, err := imageutil.LayerOpeners(image)
+		if err != nil {
+			log.Fatalf("unable to get layers for %s: %v", imageName, err)
+		}
+		if outputDir != "" {
+			idx, err := imageutil.NewIndex(layers)
+			if err != nil {
+				log.Fatalf("could not merge layers: %v", err)
+			}
+			if err := idx.Unpack(outputDir, unpackOpts); err != nil {
+				log.Fatalf("could not unpack to %s: %v", outputDir, err)
+			}
+			log.Printf("Done! Image unpacked at %s", outputDir)
+			return
+		}
+
 		outfile, err := os.Create(targetPath)
 		if err != nil {
 			log.Fatalf("unable to open target file %s: %v", targetPath, err)
 		}
 		defer outfile.Close()
-		rc := mutate.Extract(image)
-		n, err := io.Copy(outfile, rc)
-		if err != nil {
+		if err := imageutil.ApplyLayers(outfile, layers); err != nil {
 			log.Fatalf("could not merge layers: %v", err)
 		}
-		log.Printf("Done! Image of size %d expanded at %s", n, targetPath)
+		fi, err := outfile.Stat()
+		if err != nil {
+			log.Fatalf("unable to stat target file %s: %v", targetPath, err)
+		}
+		log.Printf("Done! Image of size %d expanded at %s", fi.Size(), targetPath)
 	},
 }
 
 func mergeImageInit() {
-	mergeImageCmd.Flags().StringVar(&layoutPath, "path", "", "path to the local v1 layout")
+	mergeImageCmd.Flags().StringVar(&layoutPath, "path", "", "path to the local v1 layout or oci-archive")
 	mergeImageCmd.Flags().StringVar(&targetPath, "target", "", "where to write the output tar file")
+	mergeImageCmd.Flags().StringVar(&outputDir, "output-dir", "", "directory into which to unpack the image filesystem, instead of writing a tar file")
+	mergeImageCmd.Flags().BoolVar(&unpackOpts.Owners, "preserve-ownership", false, "with --output-dir, keep the owners of files as in the image, as well as setuid and setgid bits and device files; requires root")
+	mergeImageCmd.Flags().BoolVar(&unpackOpts.Xattrs, "xattrs", false, "with --output-dir, set extended attributes as in the image, where the filesystem and permissions allow")
 	mergeImageCmd.Flags().StringVar(&architecture, "arch", runtime.GOARCH, "architecture for which to build an image")
 }
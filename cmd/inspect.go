@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/deitch/ocidist/pkg/imageutil"
+	"github.com/spf13/cobra"
+)
+
+var (
+	inspectSource       imageSource
+	inspectOutput       string
+	inspectUncompressed bool
+)
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect <ref>",
+	Short: "Show a summary of an image or index, across all of its platforms",
+	Long: `Summarize an image or index, in a registry or, with --path, in a local layout, oci-archive or tarball. For an index, shows the tree
+of its platforms and manifest digests, and for each image in it, its layers with their sizes, when it was created, its entrypoint, cmd, env,
+labels, exposed ports and history. With --uncompressed-size, also shows the uncompressed size of each layer, which means reading all of it.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ref := args[0]
+		validateOutput(inspectOutput)
+		add, cleanup := inspectSource.appendable(ref)
+		defer cleanup()
+		inspection, err := imageutil.Inspect(add, inspectUncompressed)
+		if err != nil {
+			log.Fatalf("unable to inspect %s: %v", ref, err)
+		}
+		if inspectOutput == outputJSON {
+			writeJSON(inspection)
+			return
+		}
+		printInspection(inspection, "")
+	},
+}
+
+func inspectInit() {
+	inspectCmd.Flags().StringVar(&inspectSource.path, "path", "", "read the image from this local v1 layout, oci-archive or tarball, rather than from a registry; the ref then is the ref name annotation, tag or digest of the image in it")
+	addOutputFlag(inspectCmd, &inspectOutput)
+	inspectCmd.Flags().BoolVar(&inspectUncompressed, "uncompressed-size", false, "read the layers to get their uncompressed sizes as well")
+}
+
+// printInspection print in as text, each line starting with indent
+func printInspection(in *imageutil.Inspection, indent string) {
+	kind := "artifact"
+	switch {
+	case in.MediaType.IsIndex():
+		kind = "index"
+	case in.Image != nil:
+		kind = "image"
+	}
+	platform := ""
+	if in.Platform != nil {
+		platform = in.Platform.String() + " "
+	}
+	fmt.Printf("%s%s%s %s (%s, %s)\n", indent, platform, kind, in.Digest, in.MediaType, humanSize(in.Size))
+	indent += "  "
+	printMap(indent, "annotations", in.Annotations)
+	for i := range in.Manifests {
+		printInspection(&in.Manifests[i], indent)
+	}
+	if in.Image == nil {
+		return
+	}
+
+	img := in.Image
+	if img.OS != "" {
+		platform := img.OS + "/" + img.Architecture
+		if img.Variant != "" {
+			platform += "/" + img.Variant
+		}
+		fmt.Printf("%splatform: %s\n", indent, platform)
+	}
+	if !img.Created.IsZero() {
+		fmt.Printf("%screated: %s\n", indent, img.Created.UTC().Format("2006-01-02T15:04:05Z"))
+	}
+	if img.Entrypoint != nil {
+		fmt.Printf("%sentrypoint: %q\n", indent, img.Entrypoint)
+	}
+	if img.Cmd != nil {
+		fmt.Printf("%scmd: %q\n", indent, img.Cmd)
+	}
+	if img.WorkingDir != "" {
+		fmt.Printf("%sworkdir: %s\n", indent, img.WorkingDir)
+	}
+	if img.User != "" {
+		fmt.Printf("%suser: %s\n", indent, img.User)
+	}
+	if len(img.Env) > 0 {
+		fmt.Printf("%senv:\n", indent)
+		for _, e := range img.Env {
+			fmt.Printf("%s  %s\n", indent, e)
+		}
+	}
+	printMap(indent, "labels", img.Labels)
+	if len(img.ExposedPorts) > 0 {
+		fmt.Printf("%sexposed ports: %s\n", indent, strings.Join(img.ExposedPorts, ", "))
+	}
+
+	sizes := humanSize(img.Size)
+	if img.UncompressedSize > 0 {
+		sizes += ", " + humanSize(img.UncompressedSize) + " uncompressed"
+	}
+	fmt.Printf("%slayers: %d, %s\n", indent, len(img.Layers), sizes)
+	for i, l := range img.Layers {
+		sizes := humanSize(l.Size)
+		if l.UncompressedSize > 0 {
+			sizes += " / " + humanSize(l.UncompressedSize)
+		}
+		fmt.Printf("%s  %d: %s %s (%s)\n", indent, i, l.Digest, sizes, l.MediaType)
+	}
+	if len(img.History) > 0 {
+		fmt.Printf("%shistory:\n", indent)
+		for _, h := range img.History {
+			created := ""
+			if !h.Created.IsZero() {
+				created = h.Created.UTC().Format("2006-01-02T15:04:05Z") + " "
+			}
+			empty := ""
+			if h.EmptyLayer {
+				empty = " (empty layer)"
+			}
+			fmt.Printf("%s  %s%s%s\n", indent, created, strings.ReplaceAll(h.CreatedBy, "\n", "; "), empty)
+		}
+	}
+}
+
+// printMap print m, under the heading title, sorted by key
+func printMap(indent, title string, m map[string]string) {
+	if len(m) == 0 {
+		return
+	}
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fmt.Printf("%s%s:\n", indent, title)
+	for _, k := range keys {
+		fmt.Printf("%s  %s=%s\n", indent, k, m[k])
+	}
+}
+
+// humanSize size in bytes, in the largest binary unit in which it is at least 1
+func humanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,16 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var layerCmd = &cobra.Command{
+	Use:   "layer",
+	Short: "Work with individual layers",
+	Long:  `Build layers locally, to push on their own or add to images.`,
+}
+
+func layerInit() {
+	layerCmd.AddCommand(layerCreateCmd)
+	layerCreateInit()
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deitch/ocidist/pkg/imageutil"
+	"github.com/google/go-containerregistry/pkg/compression"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/spf13/cobra"
+)
+
+var (
+	layerOut, layerPush, layerCompression string
+	layerLevel                            int
+	layerEpoch                            int64
+	layerKeepOwners                       bool
+	layerWhiteoutsFile                    string
+	layerOpts                             imageutil.TarOptions
+)
+
+var layerCreateCmd = &cobra.Command{
+	Use:   "create <dir>",
+	Short: "Build a reproducible layer from the content of a local directory",
+	Long: `Tar up the content of <dir> as a layer, writing it to a file with --out, pushing it as a blob to a repository with --push, or both.
+The same content always gives the same layer: entries are sorted, every modification time is set to --source-date-epoch, which defaults
+to the SOURCE_DATE_EPOCH environment variable, or else 0, and every owner to --uid and --gid, unless --keep-owners is given.
+Each --whiteout, or line of --whiteouts-file, is a path to delete from the layers below, and becomes a whiteout entry in the layer.
+Files a tar cannot hold, such as sockets, are left out, with a warning.
+Prints the digest and diffID of the layer.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dir := args[0]
+		if layerOut == "" && layerPush == "" {
+			log.Fatal("must provide at least one of --out or --push")
+		}
+		if !cmd.Flags().Changed("source-date-epoch") {
+			if env := os.Getenv("SOURCE_DATE_EPOCH"); env != "" {
+				epoch, err := strconv.ParseInt(env, 10, 64)
+				if err != nil {
+					log.Fatalf("invalid SOURCE_DATE_EPOCH %q: %v", env, err)
+				}
+				layerEpoch = epoch
+			}
+		}
+		// time.Unix(0, 0) is not the zero time, so this is always fixed
+		layerOpts.ModTime = time.Unix(layerEpoch, 0)
+		layerOpts.NormalizeOwners = !layerKeepOwners
+		if layerWhiteoutsFile != "" {
+			b, err := os.ReadFile(layerWhiteoutsFile)
+			if err != nil {
+				log.Fatalf("unable to read whiteouts list %s: %v", layerWhiteoutsFile, err)
+			}
+			for _, line := range strings.Split(string(b), "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					layerOpts.Whiteouts = append(layerOpts.Whiteouts, line)
+				}
+			}
+		}
+		comp := parseCompression(layerCompression)
+
+		layerOpts.Skipped = func(p string, err error) {
+			log.Printf("warning: leaving out %s: %v", p, err)
+		}
+		layer, cleanup, err := imageutil.LayerFromDir(dir, layerOpts, comp, layerLevel)
+		if err != nil {
+			log.Fatalf("unable to create layer from %s: %v", dir, err)
+		}
+		defer cleanup()
+		digest, err := layer.Digest()
+		if err != nil {
+			log.Fatalf("unable to create layer from %s: %v", dir, err)
+		}
+		diffID, err := layer.DiffID()
+		if err != nil {
+			log.Fatalf("unable to create layer from %s: %v", dir, err)
+		}
+
+		if layerOut != "" {
+			if err := writeLayer(layerOut, layer.Compressed); err != nil {
+				log.Fatalf("unable to write layer to %s: %v", layerOut, err)
+			}
+			log.Printf("wrote layer to %s", layerOut)
+		}
+		if layerPush != "" {
+			repo, err := name.NewRepository(layerPush)
+			if err != nil {
+				log.Fatalf("parsing repository %q: %v", layerPush, err)
+			}
+			_, msg, options := apiOptions()
+			if verbose {
+				log.Println(msg)
+			}
+			if err := remote.WriteLayer(repo, layer, options...); err != nil {
+				log.Fatalf("error writing blob: %v", err)
+			}
+			log.Printf("pushed layer to %s@%s", repo, digest)
+		}
+		fmt.Printf("digest: %s\n", digest)
+		fmt.Printf("diffID: %s\n", diffID)
+	},
+}
+
+func layerCreateInit() {
+	layerCreateCmd.Flags().StringVar(&layerOut, "out", "", "file to write the layer to")
+	layerCreateCmd.Flags().StringVar(&layerPush, "push", "", "repository to push the layer to as a blob")
+	layerCreateCmd.Flags().StringVar(&layerCompression, "compression", string(compression.GZip), fmt.Sprintf("compression of the layer, can be one of %s", strings.Join(compressions, ", ")))
+	layerCreateCmd.RegisterFlagCompletionFunc("compression", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return compressions, cobra.ShellCompDirectiveNoFileComp
+	})
+	layerCreateCmd.Flags().IntVar(&layerLevel, "compression-level", 0, "compression level, 0 uses the default for the algorithm")
+	layerCreateCmd.Flags().StringVar(&layerOpts.Prefix, "prefix", "", "path in the layer under which to put the content of the directory")
+	layerCreateCmd.Flags().Int64Var(&layerEpoch, "source-date-epoch", 0, "modification time of every entry, in seconds since the epoch; defaults to the SOURCE_DATE_EPOCH environment variable, or else 0")
+	layerCreateCmd.Flags().IntVar(&layerOpts.UID, "uid", 0, "owner of every entry")
+	layerCreateCmd.Flags().IntVar(&layerOpts.GID, "gid", 0, "group of every entry")
+	layerCreateCmd.Flags().BoolVar(&layerKeepOwners, "keep-owners", false, "keep the owners of the files on disk, rather than setting --uid and --gid")
+	layerCreateCmd.Flags().StringArrayVar(&layerOpts.Whiteouts, "whiteout", nil, "path to delete from the layers below, as a whiteout entry, may be repeated")
+	layerCreateCmd.Flags().StringVar(&layerWhiteoutsFile, "whiteouts-file", "", "path to a file listing paths to delete from the layers below, one per line")
+}
+
+// writeLayer write the stream from open to the file p, by way of a temporary file in the same directory, so that p
+// is never left holding part of a layer
+func writeLayer(p string, open func() (io.ReadCloser, error)) error {
+	rc, err := open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+"-")
+	if err != nil {
+		return err
+	}
+	// after a successful rename, this is a no-op
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := io.Copy(tmp, rc); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/deitch/ocidist/pkg/formatutil"
+	"github.com/deitch/ocidist/pkg/layoututil"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	ocispecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/cobra"
+)
+
+var layoutCmd = &cobra.Command{
+	Use:   "layout",
+	Short: "Work with local OCI layouts",
+	Long:  `Combine, split and package local OCI layouts, without going to a registry.`,
+}
+
+func layoutInit() {
+	layoutCmd.AddCommand(layoutMergeCmd)
+	layoutMergeInit()
+	layoutCmd.AddCommand(layoutExportCmd)
+	layoutExportInit()
+}
+
+// openLayout open the layout at p, per readLayout, exiting on any error
+func openLayout(p string) (layout.Path, func()) {
+	lp, cleanup, err := readLayout(p)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return lp, cleanup
+}
+
+// readLayout read the layout at p, which may be a layout directory or an oci-archive tar file, optionally gzip-compressed. An archive
+// is extracted to a temporary directory, which is removed by the returned cleanup func.
+func readLayout(p string) (layout.Path, func(), error) {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to read %s: %v", p, err)
+	}
+	if fi.IsDir() {
+		lp, err := layout.FromPath(p)
+		if err != nil {
+			return "", nil, fmt.Errorf("unable to read v1 layout at %s: %v", p, err)
+		}
+		return lp, func() {}, nil
+	}
+	dir, err := os.MkdirTemp("", "ocidist-archive")
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to create temporary directory: %v", err)
+	}
+	rc, err := formatutil.Open(p)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("unable to open oci-archive at %s: %v", p, err)
+	}
+	defer rc.Close()
+	lp, err := layoututil.ExtractArchive(rc, dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("unable to extract oci-archive at %s: %v", p, err)
+	}
+	return lp, func() { os.RemoveAll(dir) }, nil
+}
+
+// writeOCIArchive write a new oci-archive file at target, with each of adds as a descriptor in its index.json
+func writeOCIArchive(target string, adds ...mutate.IndexAddendum) error {
+	f, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	ii := mutate.AppendManifests(empty.Index, adds...)
+	if err := layoututil.WriteIndexArchive(f, ii); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// refAddendum get an addendum for an index for add, which must be an image or an index, annotated with refName
+func refAddendum(refName string, add mutate.Appendable) mutate.IndexAddendum {
+	return mutate.IndexAddendum{
+		Add: add,
+		Descriptor: v1.Descriptor{
+			Annotations: map[string]string{ocispecv1.AnnotationRefName: refName},
+		},
+	}
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/deitch/ocidist/pkg/layoututil"
+	"github.com/spf13/cobra"
+)
+
+var layoutExportRefs []string
+
+var layoutExportCmd = &cobra.Command{
+	Use:   "export <src> <dst>",
+	Short: "Export selected images from an OCI layout into a new one",
+	Long: `Create the <dst> layout, containing only the descriptors from the <src> layout or oci-archive selected by --ref, along with the blobs
+reachable from them. Each --ref is matched against the ref name annotation or the digest of the descriptors in the index.json of <src>.
+With --archive, <dst> is written as a single oci-archive tar file instead.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		src, dst := args[0], args[1]
+
+		srcPath, srcCleanup := openLayout(src)
+		defer srcCleanup()
+
+		dstPath, cleanup := layoutTarget(dst)
+		defer cleanup()
+
+		if err := layoututil.Export(dstPath, srcPath, layoutExportRefs); err != nil {
+			log.Fatalf("unable to export from %s: %v", src, err)
+		}
+		writeLayoutTarget(dst, dstPath)
+		log.Printf("exported %d refs from %s to %s", len(layoutExportRefs), src, dst)
+	},
+}
+
+func layoutExportInit() {
+	layoutExportCmd.Flags().StringSliceVar(&layoutExportRefs, "ref", nil, "ref name or digest of a descriptor to export, may be repeated")
+	layoutExportCmd.MarkFlagRequired("ref")
+	layoutExportCmd.Flags().BoolVar(&layoutArchive, "archive", false, "write the result as a single oci-archive tar file, rather than a layout directory")
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/deitch/ocidist/pkg/layoututil"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/spf13/cobra"
+)
+
+var layoutArchive bool
+
+var layoutMergeCmd = &cobra.Command{
+	Use:   "merge <dst> <src>...",
+	Short: "Merge multiple OCI layouts into a single one",
+	Long: `Copy every descriptor in the index.json of each <src> layout or oci-archive, along with all of the blobs they reference, into the
+<dst> layout, creating it if it does not exist. Blobs are deduplicated by digest. With --archive, <dst> is written as a single oci-archive tar file instead.`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		dst, srcs := args[0], args[1:]
+
+		var srcPaths []layout.Path
+		for _, src := range srcs {
+			p, cleanup := openLayout(src)
+			defer cleanup()
+			srcPaths = append(srcPaths, p)
+		}
+
+		dstPath, cleanup := layoutTarget(dst)
+		defer cleanup()
+
+		if err := layoututil.Merge(dstPath, srcPaths...); err != nil {
+			log.Fatalf("unable to merge layouts into %s: %v", dst, err)
+		}
+		writeLayoutTarget(dst, dstPath)
+		log.Printf("merged %d layouts into %s", len(srcs), dst)
+	},
+}
+
+func layoutMergeInit() {
+	layoutMergeCmd.Flags().BoolVar(&layoutArchive, "archive", false, "write the result as a single oci-archive tar file, rather than a layout directory")
+}
+
+// layoutTarget get the layout to which to write for dst. If we are writing an archive, it is a temporary
+// directory, which is removed by the returned cleanup func.
+func layoutTarget(dst string) (layout.Path, func()) {
+	if !layoutArchive {
+		p, err := layoututil.GetCache(dst)
+		if err != nil {
+			log.Fatalf("unable to get v1 layout at %s: %v", dst, err)
+		}
+		return p, func() {}
+	}
+	dir, err := os.MkdirTemp("", "ocidist-layout")
+	if err != nil {
+		log.Fatalf("unable to create temporary directory: %v", err)
+	}
+	p, err := layoututil.GetCache(dir)
+	if err != nil {
+		log.Fatalf("unable to initialize temporary v1 layout at %s: %v", dir, err)
+	}
+	return p, func() { os.RemoveAll(dir) }
+}
+
+// writeLayoutTarget if we are writing an archive, package up the layout at p into dst
+func writeLayoutTarget(dst string, p layout.Path) {
+	if !layoutArchive {
+		return
+	}
+	if err := layoututil.WriteArchiveFile(dst, p); err != nil {
+		log.Fatalf("unable to write archive %s: %v", dst, err)
+	}
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/deitch/ocidist/pkg/formatutil"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	ocispecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// loadLocal load a single image or index from the local file or directory at p, per readLocal, exiting on any error
+func loadLocal(p, tag, hash string) (mutate.Appendable, func()) {
+	add, cleanup, err := readLocal(p, tag, hash)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return add, cleanup
+}
+
+// readLocal read a single image or index from the local file or directory at p, in any of the local formats.
+// From a layout or oci-archive, it is the one in index.json whose ref name annotation is tag, or whose digest is
+// hash. From a tarball, it is the image with tag. If neither is given, the input must hold exactly one.
+// The returned cleanup func removes any temporary files.
+func readLocal(p, tag, hash string) (mutate.Appendable, func(), error) {
+	input, err := formatutil.Detect(p)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to determine format of %s: %v", p, err)
+	}
+
+	var images []formatutil.TaggedImage
+	switch input.Format {
+	case FormatV1Layout, FormatOCIArchive:
+		lp, cleanup, err := readLayout(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		add, err := layoutSelect(lp, p, tag, hash)
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		return add, cleanup, nil
+	case FormatV1Tarball:
+		images, err = formatutil.TarballImages(formatutil.Opener(p))
+	case FormatLegacyTarball:
+		images, err = formatutil.LegacyImages(formatutil.Opener(p))
+	default:
+		return nil, nil, fmt.Errorf("reading input in format %s is not supported", input.Format)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get images from %s: %v", p, err)
+	}
+
+	if tag == "" {
+		if len(images) != 1 {
+			return nil, nil, fmt.Errorf("%d images in %s, select one with --tag", len(images), p)
+		}
+		return images[0].Image, func() {}, nil
+	}
+	want, err := name.NewTag(tag)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid tag %s: %v", tag, err)
+	}
+	for _, ti := range images {
+		for _, t := range ti.Tags {
+			if nt, err := name.NewTag(t); err == nil && nt.Name() == want.Name() {
+				return ti.Image, func() {}, nil
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("no image with tag %s in %s", tag, p)
+}
+
+// layoutSelect select the image or index from the index.json of the layout lp, read from p, per readLocal
+func layoutSelect(lp layout.Path, p, tag, hash string) (mutate.Appendable, error) {
+	ii, err := lp.ImageIndex()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read index of %s: %v", p, err)
+	}
+	index, err := ii.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read index of %s: %v", p, err)
+	}
+
+	var matches []v1.Descriptor
+	for _, desc := range index.Manifests {
+		switch {
+		case tag == "" && hash == "":
+		case tag != "" && desc.Annotations[ocispecv1.AnnotationRefName] == tag:
+		case hash != "" && desc.Digest.String() == hash:
+		default:
+			continue
+		}
+		matches = append(matches, desc)
+	}
+	if len(matches) != 1 {
+		var refs []string
+		for _, desc := range index.Manifests {
+			refs = append(refs, desc.Digest.String()+" "+desc.Annotations[ocispecv1.AnnotationRefName])
+		}
+		return nil, fmt.Errorf("%d matching entries in %s, select exactly one with --tag or --hash; entries: %s", len(matches), p, strings.Join(refs, ", "))
+	}
+
+	desc := matches[0]
+	switch desc.MediaType {
+	case types.OCIImageIndex, types.DockerManifestList:
+		child, err := ii.ImageIndex(desc.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read index %s from %s: %v", desc.Digest, p, err)
+		}
+		return child, nil
+	case types.OCIManifestSchema1, types.DockerManifestSchema2:
+		img, err := ii.Image(desc.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read image %s from %s: %v", desc.Digest, p, err)
+		}
+		return img, nil
+	}
+	return nil, fmt.Errorf("%s in %s is neither an image nor an index", desc.Digest, p)
+}
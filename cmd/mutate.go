@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/deitch/ocidist/pkg/imageutil"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/spf13/cobra"
+)
+
+var (
+	mutateSource                    imageSource
+	mutateTarget                    imageTarget
+	mutateOpts                      imageutil.MutateOptions
+	mutateEntrypoint, mutateCommand string
+	mutateLabels, mutateAnnotations []string
+	mutateAllPlatforms              bool
+)
+
+var mutateCmd = &cobra.Command{
+	Use:   "mutate <src> <dst>",
+	Short: "Change an image by appending layers and setting config fields, labels and annotations",
+	Long: `Change the image <src>, in a registry or, with --path, in a local layout, oci-archive or tarball, and write the result to <dst>,
+in a registry or, with --format, locally.
+Each --append adds a layer on top, from a tar file, compressed or not, or from the content of a directory.
+--entrypoint and --cmd take either a JSON array, or a command line that is split on spaces; an empty one clears it.
+If <src> is an index, the image for --platform is changed and written on its own, unless --all-platforms is given, in which
+case every image in the index is changed, and the whole index written.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		src, dst := args[0], args[1]
+		if cmd.Flags().Changed("entrypoint") {
+			mutateOpts.Entrypoint = parseCommand(mutateEntrypoint)
+		}
+		if cmd.Flags().Changed("cmd") {
+			mutateOpts.Cmd = parseCommand(mutateCommand)
+		}
+		mutateOpts.Labels = parseKeyValues("label", mutateLabels)
+		mutateOpts.Annotations = parseKeyValues("annotation", mutateAnnotations)
+		for _, kv := range mutateOpts.Env {
+			if !strings.Contains(kv, "=") {
+				log.Fatalf("invalid --env %q, must be KEY=VALUE", kv)
+			}
+		}
+
+		var add mutate.Appendable
+		cleanup := func() {}
+		if mutateAllPlatforms {
+			add, cleanup = mutateSource.appendable(src)
+		} else {
+			add, cleanup = mutateSource.image(src)
+		}
+		defer cleanup()
+
+		var err error
+		switch a := add.(type) {
+		case v1.ImageIndex:
+			add, err = imageutil.MutateIndex(a, mutateOpts)
+		case v1.Image:
+			add, err = imageutil.MutateImage(a, mutateOpts)
+		}
+		if err != nil {
+			log.Fatalf("unable to mutate %s: %v", src, err)
+		}
+		mutateTarget.to = dst
+		mutateTarget.write(add, src, mutateSource.platform)
+		log.Printf("mutated %s, written to %s", src, dst)
+	},
+}
+
+func mutateInit() {
+	mutateSource.addFlags(mutateCmd)
+	mutateTarget.addFormatFlags(mutateCmd)
+	mutateCmd.Flags().StringArrayVar(&mutateOpts.Append, "append", nil, "tar file or directory to add as a layer on top of the image, may be repeated")
+	mutateCmd.Flags().StringArrayVar(&mutateOpts.Env, "env", nil, "environment variable to set, as KEY=VALUE, replacing any existing value, may be repeated")
+	mutateCmd.Flags().StringVar(&mutateEntrypoint, "entrypoint", "", "entrypoint to set, as a JSON array or a command line split on spaces; empty clears it")
+	mutateCmd.Flags().StringVar(&mutateCommand, "cmd", "", "command to set, as a JSON array or a command line split on spaces; empty clears it")
+	mutateCmd.Flags().StringVar(&mutateOpts.WorkingDir, "workdir", "", "working directory to set")
+	mutateCmd.Flags().StringVar(&mutateOpts.User, "user", "", "user to set, as user[:group], by name or id")
+	mutateCmd.Flags().StringArrayVar(&mutateLabels, "label", nil, "label to set in the config, as KEY=VALUE, may be repeated")
+	mutateCmd.Flags().StringArrayVar(&mutateAnnotations, "annotation", nil, "annotation to set on the manifest, as KEY=VALUE, may be repeated")
+	mutateCmd.Flags().BoolVar(&mutateAllPlatforms, "all-platforms", false, "if the source is an index, change every image in it and write the whole index, rather than just the image for --platform")
+}
+
+// parseCommand parse a command given as a JSON array, or as a command line to split on spaces
+func parseCommand(s string) []string {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "[") {
+		var command []string
+		if err := json.Unmarshal([]byte(s), &command); err != nil {
+			log.Fatalf("invalid JSON array %s: %v", s, err)
+		}
+		return command
+	}
+	return append([]string{}, strings.Fields(s)...)
+}
+
+// parseKeyValues parse each of values, given with the flag, as KEY=VALUE
+func parseKeyValues(flag string, values []string) map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+	m := map[string]string{}
+	for _, kv := range values {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			log.Fatalf("invalid --%s %q, must be KEY=VALUE", flag, kv)
+		}
+		m[parts[0]] = parts[1]
+	}
+	return m
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/deitch/ocidist/pkg/pinutil"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/spf13/cobra"
+)
+
+var (
+	outdatedOutput string
+	outdatedWrite  bool
+)
+
+// outdatedPin a pinned reference found in a file, and whether its tag has moved on
+type outdatedPin struct {
+	File string `json:"file"`
+	pinutil.Pin
+	// Latest the digest to pin to now: what the tag points to, or for a pin to one platform, the image for it
+	Latest v1.Hash `json:"latest"`
+	Stale  bool    `json:"stale"`
+	// Error why a stale pin cannot be updated
+	Error string `json:"error,omitempty"`
+}
+
+var outdatedCmd = &cobra.Command{
+	Use:   "outdated <file>...",
+	Short: "Check whether images pinned by digest are behind the tags they came from",
+	Long: `Scan each of the files, such as Dockerfiles, Kubernetes YAML or compose files, for image references with both a tag and a digest,
+as in name:tag@sha256:..., look up what each tag points to now in its registry, and report the pins that are stale.
+A pin to one of the images in the index the tag points to, such as for a single platform, is current.
+With --write, the digests of stale pins are replaced in place by the digest the tag points to, other than for a pin to the image for
+one platform, which is replaced by the image for the same platform in the index the tag points to, or if there is none, left as it is.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		validateOutput(outdatedOutput)
+		_, msg, options := apiOptions()
+		if verbose {
+			log.Println(msg)
+		}
+		// what each tag points to, with the digests of the images in it if it is an index, looked up just once
+		contents := map[string][]byte{}
+		var tags []string
+		seen := map[string]bool{}
+		for _, file := range args {
+			content, err := os.ReadFile(file)
+			if err != nil {
+				log.Fatalf("unable to read %s: %v", file, err)
+			}
+			contents[file] = content
+			for _, pin := range pinutil.FindPins(content) {
+				if !seen[pin.Tag] {
+					seen[pin.Tag] = true
+					tags = append(tags, pin.Tag)
+				}
+			}
+		}
+		lock, err := pinutil.Resolve(tags, 8, options...)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		pins := []outdatedPin{}
+		for _, file := range args {
+			content := contents[file]
+			updates := map[string]v1.Hash{}
+			for _, pin := range pinutil.FindPins(content) {
+				locked, _ := lock.Find(pin.Tag)
+				latest, stale, err := pinutil.Latest(pin, locked, options...)
+				p := outdatedPin{File: file, Pin: pin, Latest: latest, Stale: stale}
+				switch {
+				case err != nil:
+					p.Error = err.Error()
+				case stale:
+					updates[pin.Ref] = latest
+				}
+				pins = append(pins, p)
+			}
+			if !outdatedWrite || len(updates) == 0 {
+				continue
+			}
+			fi, err := os.Stat(file)
+			if err != nil {
+				log.Fatalf("unable to read %s: %v", file, err)
+			}
+			updated, changed := pinutil.UpdatePins(content, updates)
+			if err := os.WriteFile(file, updated, fi.Mode().Perm()); err != nil {
+				log.Fatalf("unable to write %s: %v", file, err)
+			}
+			log.Printf("updated %d pins in %s", changed, file)
+		}
+
+		if outdatedOutput == outputJSON {
+			writeJSON(pins)
+			return
+		}
+		for _, p := range pins {
+			if p.Error != "" {
+				fmt.Printf("%s:%d %s stale, left as it is: %s\n", p.File, p.Line, p.Ref, p.Error)
+			} else if p.Stale {
+				fmt.Printf("%s:%d %s stale, %s is now %s\n", p.File, p.Line, p.Ref, p.Tag, p.Latest)
+			} else if verbose {
+				fmt.Printf("%s:%d %s current\n", p.File, p.Line, p.Ref)
+			}
+		}
+	},
+}
+
+func outdatedInit() {
+	addOutputFlag(outdatedCmd, &outdatedOutput)
+	outdatedCmd.Flags().BoolVar(&outdatedWrite, "write", false, "replace the digests of stale pins in the files with the digests their tags point to now")
+}
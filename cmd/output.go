@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// addOutputFlag add the --output flag to choose between text and json output for cmd, stored in output
+func addOutputFlag(cmd *cobra.Command, output *string) {
+	cmd.Flags().StringVar(output, "output", outputText, "output format, one of 'text' or 'json'")
+	cmd.RegisterFlagCompletionFunc("output", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{outputText, outputJSON}, cobra.ShellCompDirectiveNoFileComp
+	})
+}
+
+// validateOutput check that output is one of the output formats, exiting if it is not
+func validateOutput(output string) {
+	if err := checkOutput(output); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// checkOutput check that output is one of the output formats
+func checkOutput(output string) error {
+	if output != outputText && output != outputJSON {
+		return fmt.Errorf("unknown output %q, must be one of: %s, %s", output, outputText, outputJSON)
+	}
+	return nil
+}
+
+// writeJSON write v to stdout as indented json
+func writeJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Fatalf("unable to write json: %v", err)
+	}
+}
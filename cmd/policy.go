@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/deitch/ocidist/pkg/policyutil"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/spf13/cobra"
+)
+
+// addPolicyFlag add the --policy flag to cmd, setting p
+func addPolicyFlag(cmd *cobra.Command, p *string) {
+	cmd.Flags().StringVar(p, "policy", "", `yaml file of rules the image must meet before anything is done with it; the keys are:
+allowedRegistries, allowedRepositories (path.Match patterns), requireDigest, maxSize (e.g. 500MB), requiredLabels (name: value,
+or "" for any), forbidRootUser, requiredPlatforms (os/arch[/variant]) and requireSignature (keys: public key files)`)
+}
+
+// enforcePolicy check ref against the policy in the file at p, if any, exiting with a report of the violations if
+// it does not pass. It returns the digest that was checked, for the caller to go on with in place of ref, which, if a
+// tag, may have moved since; with no policy, it returns the zero hash.
+func enforcePolicy(p string, ref name.Reference, options []remote.Option) v1.Hash {
+	if p == "" {
+		return v1.Hash{}
+	}
+	policy, err := policyutil.LoadPolicy(p)
+	if err != nil {
+		log.Fatalf("unable to load policy %s: %v", p, err)
+	}
+	// the simple API has no options, but uses the default keychain
+	if len(options) == 0 {
+		options = []remote.Option{remote.WithAuthFromKeychain(authn.DefaultKeychain)}
+	}
+	report, err := policy.Evaluate(ref, options...)
+	if err != nil {
+		log.Fatalf("unable to check %s against policy %s: %v", ref, p, err)
+	}
+	if report.Passed() {
+		log.Printf("%s meets policy %s", ref, p)
+		return report.Digest
+	}
+	for _, v := range report.Violations {
+		var of string
+		switch {
+		case v.Platform != nil:
+			of = fmt.Sprintf(" (%s %s)", v.Platform, v.Digest)
+		case v.Digest != nil:
+			of = fmt.Sprintf(" (%s)", v.Digest)
+		}
+		log.Printf("policy violation: %s%s: %s", v.Rule, of, v.Message)
+	}
+	log.Fatalf("%s does not meet policy %s: %d violations", ref, p, len(report.Violations))
+	return v1.Hash{}
+}
@@ -13,6 +13,8 @@ var pullCmd = &cobra.Command{
 func pullInit() {
 	pullCmd.AddCommand(pullImageCmd)
 	pullImageInit()
+	pullCmd.AddCommand(pullImagesCmd)
+	pullImagesInit()
 	pullCmd.AddCommand(pullBlobCmd)
 	pullBlobInit()
 	pullCmd.AddCommand(pullManifestCmd)
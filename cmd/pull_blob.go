@@ -3,19 +3,23 @@ package cmd
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
 	"strings"
 
+	"github.com/deitch/ocidist/pkg/imageutil"
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/spf13/cobra"
 )
 
 var (
-	blobSavePath string
-	isManifest   bool
+	blobSavePath    string
+	isManifest, toc bool
 )
 
 var pullBlobCmd = &cobra.Command{
@@ -23,7 +27,10 @@ var pullBlobCmd = &cobra.Command{
 	Short: "Pull a specific layer blob for a given repository and save it locally",
 	Long: `For a given complete image URL, pull one blob and save it locally in the target format. To get a specific blob,
 provide the <ref> with a hash, e.g. docker.io/library/alpine@abcdef5566. To get the manifest referenced by a tag, provide the <ref>
-in the usual format, e.g. docker.io/library/alpine:3.11`,
+in the usual format, e.g. docker.io/library/alpine:3.11
+
+With --toc, the blob must be an eStargz layer, and only its table of contents is fetched, using HTTP Range requests, and
+saved as json.`,
 	Args: cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		// this is the manifest referenced by the image. If it is an index, it returns the index.
@@ -46,7 +53,10 @@ in the usual format, e.g. docker.io/library/alpine:3.11`,
 
 		log.Println(msg)
 
-		if _, ok := ref.(name.Tag); ok || isManifest {
+		switch _, ok := ref.(name.Tag); {
+		case toc && (ok || isManifest):
+			log.Fatalf("--toc requires a blob hash, not a tag or manifest")
+		case ok || isManifest:
 			// we had a tag, so just get the root manifest/index
 			log.Printf("requested manifest or had tag without hash, so just pulling root for %s", image)
 			desc, err = remote.Get(ref, options...)
@@ -59,12 +69,17 @@ in the usual format, e.g. docker.io/library/alpine:3.11`,
 				log.Fatalf("unable to indent json: %v", err)
 			}
 			r = strings.NewReader(out.String())
-		} else {
+		default:
 			// we had a hash, so get the actual layer
 			d, ok := ref.(name.Digest)
 			if !ok {
 				log.Fatalf("ref wasn't a tag or digest")
 			}
+			if toc {
+				log.Printf("had hash, so pulling eStargz table of contents for %s", image)
+				r = pullTOC(d)
+				break
+			}
 			log.Printf("had hash, so pulling blob for %s", image)
 			layer, err := remote.Layer(d, options...)
 			if err != nil {
@@ -109,4 +124,86 @@ in the usual format, e.g. docker.io/library/alpine:3.11`,
 func pullBlobInit() {
 	pullBlobCmd.Flags().StringVar(&blobSavePath, "path", "", "path to save the blob, blank defaults to stdout")
 	pullBlobCmd.Flags().BoolVar(&isManifest, "manifest", false, "whether the requested item is a manifest/index or not; defaults to false if a hash is provided, true otherwise")
+	pullBlobCmd.Flags().BoolVar(&toc, "toc", false, "fetch just the table of contents of an eStargz layer blob, rather than the whole blob")
+}
+
+// pullTOC fetch the table of contents of the eStargz blob d, returning it as indented json
+func pullTOC(d name.Digest) io.Reader {
+	ra := &blobReaderAt{
+		client: &http.Client{Transport: apiTransport(d.Context())},
+		url: url.URL{
+			Scheme: d.Context().Registry.Scheme(),
+			Host:   d.Context().RegistryStr(),
+			Path:   fmt.Sprintf("/v2/%s/blobs/%s", d.Context().RepositoryStr(), d.DigestStr()),
+		},
+	}
+	size, err := ra.size()
+	if err != nil {
+		log.Fatalf("unable to get size of blob %s: %v", d, err)
+	}
+	jtoc, tocDigest, err := imageutil.ReadTOC(ra, size)
+	if err != nil {
+		log.Fatalf("unable to read table of contents of %s: %v", d, err)
+	}
+	log.Printf("table of contents digest %s, read %d of %d bytes in %d requests", tocDigest, ra.read, size, ra.requests)
+	b, err := json.MarshalIndent(jtoc, "", "\t")
+	if err != nil {
+		log.Fatalf("unable to format table of contents: %v", err)
+	}
+	return bytes.NewReader(append(b, '\n'))
+}
+
+// blobReaderAt an io.ReaderAt of a blob in a registry, reading each part with a Range request
+type blobReaderAt struct {
+	client         *http.Client
+	url            url.URL
+	requests, read int64
+}
+
+func (b *blobReaderAt) size() (int64, error) {
+	resp, err := b.client.Head(b.url.String())
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if resp.ContentLength < 0 {
+		return 0, fmt.Errorf("registry did not report blob size")
+	}
+	return resp.ContentLength, nil
+}
+
+func (b *blobReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	req, err := http.NewRequest(http.MethodGet, b.url.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1))
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	b.requests++
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// the registry ignored the range, so skip to the part we want
+		if _, err := io.CopyN(io.Discard, resp.Body, off); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	n, err := io.ReadFull(resp.Body, p)
+	b.read += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
 }
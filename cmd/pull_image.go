@@ -9,12 +9,12 @@ import (
 	"os"
 	"time"
 
+	"github.com/deitch/ocidist/pkg/layoututil"
 	"github.com/google/go-containerregistry/pkg/crane"
 	legacytarball "github.com/google/go-containerregistry/pkg/legacy/tarball"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
-	"github.com/google/go-containerregistry/pkg/v1/empty"
-	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	v1tarball "github.com/google/go-containerregistry/pkg/v1/tarball"
 	ocispecv1 "github.com/opencontainers/image-spec/specs-go/v1"
@@ -22,14 +22,15 @@ import (
 )
 
 var (
-	pullSavePath, pullWriteFormat string
+	pullSavePath, pullWriteFormat, pullPolicy string
 )
 
 var pullImageCmd = &cobra.Command{
 	Use:   "image <image>",
 	Short: "Pull the image for a given repository and save it locally in the target format",
-	Long:  `For a given complete image URL, pull it and save it locally in the target format`,
-	Args:  cobra.MinimumNArgs(1),
+	Long: `For a given complete image URL, pull it and save it locally in the target format.
+With --policy, the image is first checked against the rules in the policy file, and not pulled if it breaks any of them.`,
+	Args: cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		// this is the manifest referenced by the image. If it is an index, it returns the index.
 		var (
@@ -40,6 +41,9 @@ var pullImageCmd = &cobra.Command{
 			err error
 			ref name.Reference
 		)
+		if err := validateFormat(pullWriteFormat); err != nil {
+			log.Fatal(err)
+		}
 		image := args[0]
 		ref, err = name.ParseReference(image)
 		if err != nil {
@@ -49,15 +53,20 @@ var pullImageCmd = &cobra.Command{
 		simple, msg, options := apiOptions()
 
 		log.Println(msg)
+		// pull just what was checked, even if the tag has moved since, while still saving it under the name given
+		pull := ref
+		if digest := enforcePolicy(pullPolicy, ref, options); digest != (v1.Hash{}) {
+			pull = ref.Context().Digest(digest.String())
+		}
 
 		// first get the root manifest. This might be an index or a manifest
 		if simple {
-			manifest, err = crane.Manifest(image)
+			manifest, err = crane.Manifest(pull.String())
 			if err != nil {
 				log.Fatalf("error getting manifest: %v", err)
 			}
 		} else {
-			desc, err = remote.Get(ref, options...)
+			desc, err = remote.Get(pull, options...)
 			if err != nil {
 				log.Fatalf("error getting manifest: %v", err)
 			}
@@ -77,7 +86,7 @@ var pullImageCmd = &cobra.Command{
 		// an index), so it actually does resolve platform-specific
 		start := time.Now()
 		if simple {
-			img, err = crane.Pull(image)
+			img, err = crane.Pull(pull.String())
 		} else {
 			img, err = desc.Image()
 			//img, err = remote.Image(ref, options...)
@@ -127,12 +136,9 @@ var pullImageCmd = &cobra.Command{
 				defer w.Close()
 				err = legacytarball.Write(tag, img, w)
 			case FormatV1Layout:
-				p, err := layout.FromPath(pullSavePath)
+				p, err := layoututil.GetCache(pullSavePath)
 				if err != nil {
-					p, err = layout.Write(pullSavePath, empty.Index)
-					if err != nil {
-						log.Fatalf("could not write to path %s: %v", pullSavePath, err)
-					}
+					log.Fatalf("could not write to path %s: %v", pullSavePath, err)
 				}
 				annotations := map[string]string{
 					ocispecv1.AnnotationRefName: image,
@@ -141,7 +147,7 @@ var pullImageCmd = &cobra.Command{
 				// first attempt as an index
 				ii, err := desc.ImageIndex()
 				if err == nil {
-					err = p.AppendIndex(ii, layout.WithAnnotations(annotations))
+					err = layoututil.AppendIndex(p, ii, layoututil.WithAnnotations(annotations))
 				} else {
 					var im v1.Image
 					// try and image
@@ -149,9 +155,14 @@ var pullImageCmd = &cobra.Command{
 					if err != nil {
 						log.Fatalf("provided image is neither an image nor an index: %s", image)
 					}
-					err = p.AppendImage(im, layout.WithAnnotations(annotations))
+					err = layoututil.AppendImage(p, im, layoututil.WithAnnotations(annotations))
 				}
-
+			case FormatOCIArchive:
+				var add mutate.Appendable = img
+				if ii, ierr := desc.ImageIndex(); ierr == nil {
+					add = ii
+				}
+				err = writeOCIArchive(pullSavePath, refAddendum(image, add))
 			default:
 				err = fmt.Errorf("unknown format: %s", pullWriteFormat)
 			}
@@ -169,5 +180,7 @@ func pullImageInit() {
 	pullImageCmd.Flags().StringVar(&pullSavePath, "path", "", "path to save the image as a tar file, or directory for layout")
 	pullImageCmd.MarkFlagRequired("path")
 	pullImageCmd.Flags().BoolVar(&showInfo, "detail", false, "show additional detail for manifests and indexes, such as hash and size")
-	pullImageCmd.Flags().StringVar(&pullWriteFormat, "format", FormatV1Layout, "format to save the image, can be one of 'v1-layout', 'v1-tarball', 'legacy-tarball'")
+	pullImageCmd.Flags().StringVar(&pullWriteFormat, "format", FormatV1Layout, "format to save the image, can be one of 'v1-layout', 'v1-tarball', 'legacy-tarball', 'oci-archive'")
+	pullImageCmd.RegisterFlagCompletionFunc("format", completeFormat)
+	addPolicyFlag(pullImageCmd, &pullPolicy)
 }
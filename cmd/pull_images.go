@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/deitch/ocidist/pkg/pinutil"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pullImagesFrom, pullImagesPath, pullImagesFormat, pullImagesLock, pullImagesPlatform string
+	pullImagesJobs                                                                       int
+)
+
+var pullImagesCmd = &cobra.Command{
+	Use:   "images <ref>... | --from <file>",
+	Short: "Pull many images, saving them all locally in the target format",
+	Long: `Pull each of the references, from the arguments, or --from, a file listing them one per line, and save them all to --path in the target
+format, each under its reference. Indexes are kept whole in a v1-layout or oci-archive, and resolved to the image for --platform in a
+tarball.
+Each reference is resolved to a digest first, and pulled by that digest, so that a tag moving during the pull does not mix images.
+With --lock, a lockfile from 'ocidist resolve', the digests must match it: if any reference is not in it, or resolves to a different
+digest, nothing is pulled. Given no references, every one in the lockfile is pulled.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := validateFormat(pullImagesFormat); err != nil {
+			log.Fatal(err)
+		}
+		refs := readRefs(args, pullImagesFrom)
+		var lock *pinutil.Lockfile
+		if pullImagesLock != "" {
+			f, err := os.Open(pullImagesLock)
+			if err != nil {
+				log.Fatalf("unable to open lockfile %s: %v", pullImagesLock, err)
+			}
+			lock, err = pinutil.ReadLockfile(f)
+			f.Close()
+			if err != nil {
+				log.Fatalf("unable to read lockfile %s: %v", pullImagesLock, err)
+			}
+			if len(refs) == 0 {
+				for _, locked := range lock.Images {
+					refs = append(refs, locked.Ref)
+				}
+			}
+		}
+		if len(refs) == 0 {
+			log.Fatal("must provide references to pull, as arguments, with --from, or with --lock")
+		}
+
+		_, msg, options := apiOptions()
+		if verbose {
+			log.Println(msg)
+		}
+		resolved, err := pinutil.Resolve(refs, pullImagesJobs, options...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if lock != nil {
+			var mismatches []string
+			for _, r := range resolved.Images {
+				locked, ok := lock.Find(r.Ref)
+				switch {
+				case !ok:
+					mismatches = append(mismatches, fmt.Sprintf("%s is not in the lockfile", r.Ref))
+				case locked.Digest != r.Digest:
+					mismatches = append(mismatches, fmt.Sprintf("%s is %s, locked to %s", r.Ref, r.Digest, locked.Digest))
+				}
+			}
+			if len(mismatches) > 0 {
+				log.Fatalf("references do not match lockfile %s:\n%s", pullImagesLock, strings.Join(mismatches, "\n"))
+			}
+		}
+
+		var items []convertItem
+		for _, r := range resolved.Images {
+			ref, err := name.ParseReference(r.Ref)
+			if err != nil {
+				log.Fatalf("parsing reference %q: %v", r.Ref, err)
+			}
+			desc, err := remote.Get(ref.Context().Digest(r.Digest.String()), options...)
+			if err != nil {
+				log.Fatalf("error getting manifest for %s@%s: %v", r.Ref, r.Digest, err)
+			}
+			var add mutate.Appendable
+			if desc.MediaType.IsIndex() {
+				add, err = desc.ImageIndex()
+			} else {
+				add, err = desc.Image()
+			}
+			if err != nil {
+				log.Fatalf("error pulling %s@%s: %v", r.Ref, r.Digest, err)
+			}
+			items = append(items, convertItem{tags: []string{r.Ref}, add: add})
+		}
+		if err := writeLocal(pullImagesPath, pullImagesFormat, pullImagesPlatform, items); err != nil {
+			log.Fatalf("error saving: %v", err)
+		}
+		log.Printf("saved %d images to %s", len(items), pullImagesPath)
+	},
+}
+
+func pullImagesInit() {
+	pullImagesCmd.Flags().StringVar(&pullImagesPath, "path", "", "path to save the images to, as a tar file, or directory for layout")
+	pullImagesCmd.MarkFlagRequired("path")
+	pullImagesCmd.Flags().StringVar(&pullImagesFormat, "format", FormatV1Layout, "format to save the images, can be one of 'v1-layout', 'v1-tarball', 'legacy-tarball', 'oci-archive'")
+	pullImagesCmd.RegisterFlagCompletionFunc("format", completeFormat)
+	pullImagesCmd.Flags().StringVar(&pullImagesPlatform, "platform", "", "when writing an index to a tarball, the platform to take from it, in format 'os/arch[/variant]', defaults to linux on the local architecture")
+	pullImagesCmd.Flags().StringVar(&pullImagesFrom, "from", "", "file listing the references to pull, one per line")
+	pullImagesCmd.Flags().StringVar(&pullImagesLock, "lock", "", "lockfile from 'ocidist resolve' that the references must match")
+	pullImagesCmd.Flags().IntVar(&pullImagesJobs, "jobs", 8, "how many references to resolve at once")
+}
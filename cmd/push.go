@@ -19,4 +19,6 @@ func pushInit() {
 	pushManifestInit()
 	pushCmd.AddCommand(pushTagCmd)
 	pushTagInit()
+	pushCmd.AddCommand(pushImageCmd)
+	pushImageInit()
 }
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pushImagePath, pushImageTag, pushImageHash string
+	pushImageCompression                       compressionFlags
+)
+
+var pushImageCmd = &cobra.Command{
+	Use:   "image <image>",
+	Short: "Push a complete image or index from local files",
+	Long: `Push an image or index, with all of its manifests, configs and layers, from a local tarball, layout or oci-archive to
+the image reference provided. The format of the input is detected from its content. If the input holds more than one image,
+select one with --tag or, for a layout or oci-archive, --hash.
+With --compression, the layers are recompressed before pushing, and the manifests rewritten to match.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		image := args[0]
+		ref, err := name.ParseReference(image)
+		if err != nil {
+			log.Fatalf("error parsing name '%s': %v", image, err)
+		}
+		if pushImagePath == "" {
+			log.Fatalf("must provide source for image via --path")
+		}
+		if pushImageCompression.enabled() {
+			pushImageCompression.options()
+		}
+
+		_, msg, options := apiOptions()
+		if verbose {
+			log.Println(msg)
+		}
+
+		add, cleanup := loadLocal(pushImagePath, pushImageTag, pushImageHash)
+		defer cleanup()
+		add, err = pushImageCompression.recompress(add)
+		if err != nil {
+			log.Fatalf("unable to recompress %s: %v", pushImagePath, err)
+		}
+		if err := pushAppendable(ref, add, options); err != nil {
+			log.Fatalf("error pushing %s to %s: %v", pushImagePath, image, err)
+		}
+		log.Printf("successfully pushed %s to %s", pushImagePath, image)
+	},
+}
+
+func pushImageInit() {
+	pushImageCmd.Flags().StringVar(&pushImagePath, "path", "", "path to the local tarball, layout directory or oci-archive from which to push")
+	pushImageCmd.Flags().StringVar(&pushImageTag, "tag", "", "the tag of the image to select from the input, or its ref name in a layout")
+	pushImageCmd.Flags().StringVar(&pushImageHash, "hash", "", "when reading from a layout or oci-archive, the hash of the image or index to push, in 'sha256:<hash>' format")
+	addCompressionFlags(pushImageCmd, &pushImageCompression)
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/deitch/ocidist/pkg/pinutil"
+	"github.com/spf13/cobra"
+)
+
+var (
+	resolveFrom, resolveOut string
+	resolveJobs             int
+)
+
+var resolveCmd = &cobra.Command{
+	Use:   "resolve <ref>... | --from <file>",
+	Short: "Resolve references to digests and write them to a lockfile",
+	Long: `Look up what each of the references points to in its registry, many at once, and write a lockfile with, for each one, the digest,
+media type and size of its manifest, and if it is an index, those of each of the manifests in it, by platform.
+The references come from the arguments, or --from, a file listing them one per line, ignoring blank lines and lines starting with #.
+The lockfile goes to --out, or stdout; 'ocidist pull images --lock' checks against it.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		refs := readRefs(args, resolveFrom)
+		if len(refs) == 0 {
+			log.Fatal("must provide references to resolve, as arguments or with --from")
+		}
+		_, msg, options := apiOptions()
+		if verbose {
+			log.Println(msg)
+		}
+		lock, err := pinutil.Resolve(refs, resolveJobs, options...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if resolveOut == "" {
+			if err := lock.Write(os.Stdout); err != nil {
+				log.Fatalf("unable to write lockfile: %v", err)
+			}
+			return
+		}
+		f, err := os.Create(resolveOut)
+		if err != nil {
+			log.Fatalf("unable to create lockfile %s: %v", resolveOut, err)
+		}
+		defer f.Close()
+		if err := lock.Write(f); err != nil {
+			log.Fatalf("unable to write lockfile %s: %v", resolveOut, err)
+		}
+		log.Printf("resolved %d references to %s", len(lock.Images), resolveOut)
+	},
+}
+
+func resolveInit() {
+	resolveCmd.Flags().StringVar(&resolveFrom, "from", "", "file listing the references to resolve, one per line")
+	resolveCmd.Flags().StringVar(&resolveOut, "out", "", "file to write the lockfile to, defaults to stdout")
+	resolveCmd.Flags().IntVar(&resolveJobs, "jobs", 8, "how many references to resolve at once")
+}
+
+// readRefs the references in args, followed by those listed in the file from, if set, one per line, ignoring blank
+// lines and lines starting with #
+func readRefs(args []string, from string) []string {
+	refs := append([]string{}, args...)
+	if from == "" {
+		return refs
+	}
+	b, err := os.ReadFile(from)
+	if err != nil {
+		log.Fatalf("unable to read references from %s: %v", from, err)
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			refs = append(refs, line)
+		}
+	}
+	return refs
+}
@@ -5,9 +5,10 @@ import (
 )
 
 var (
-	rootCmd                        = &cobra.Command{Use: "ocidist"}
-	username, password, proxyUrl   string
-	anonymous, httpClient, verbose bool
+	rootCmd                               = &cobra.Command{Use: "ocidist"}
+	username, password, proxyUrl          string
+	anonymous, httpClient, verbose, trace bool
+	traceFile                             string
 )
 
 func init() {
@@ -21,6 +22,34 @@ func init() {
 	convertInit()
 	rootCmd.AddCommand(mergeImageCmd)
 	mergeImageInit()
+	rootCmd.AddCommand(layoutCmd)
+	layoutInit()
+	rootCmd.AddCommand(fsCmd)
+	fsInit()
+	rootCmd.AddCommand(diffCmd)
+	diffInit()
+	rootCmd.AddCommand(squashCmd)
+	squashInit()
+	rootCmd.AddCommand(mutateCmd)
+	mutateInit()
+	rootCmd.AddCommand(layerCmd)
+	layerInit()
+	rootCmd.AddCommand(inspectCmd)
+	inspectInit()
+	rootCmd.AddCommand(historyCmd)
+	historyInit()
+	rootCmd.AddCommand(diffManifestCmd)
+	diffManifestInit()
+	rootCmd.AddCommand(outdatedCmd)
+	outdatedInit()
+	rootCmd.AddCommand(resolveCmd)
+	resolveInit()
+	rootCmd.AddCommand(verifyCmd)
+	verifyInit()
+	rootCmd.AddCommand(signCmd)
+	signInit()
+	rootCmd.AddCommand(sbomCmd)
+	sbomInit()
 
 	rootCmd.PersistentFlags().StringVar(&username, "username", "", "username to authenticate against registry")
 	rootCmd.PersistentFlags().StringVar(&password, "password", "", "password to authenticate against registry")
@@ -28,9 +57,14 @@ func init() {
 	rootCmd.PersistentFlags().BoolVar(&httpClient, "http", false, "use our own http client, rather than the default, required for proxy")
 	rootCmd.PersistentFlags().StringVar(&proxyUrl, "proxy", "", "proxy URL to use")
 	rootCmd.PersistentFlags().BoolVar(&verbose, "verbose", false, "print lots of output to stderr")
+	rootCmd.PersistentFlags().BoolVar(&trace, "trace", false, "log every HTTP request to and response from registries to stderr, with method, URL, status, headers, timing and bytes transferred, with credentials redacted")
+	rootCmd.PersistentFlags().StringVar(&traceFile, "trace-file", "", "write every HTTP request to and response from registries, as --trace logs them, to this file in HAR format")
 }
 
 // Execute primary function for cobra
 func Execute() {
 	rootCmd.Execute()
+	if traceHARFile != nil {
+		traceHARFile.Close()
+	}
 }
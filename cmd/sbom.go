@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/deitch/ocidist/pkg/imageutil"
+	"github.com/deitch/ocidist/pkg/sbomutil"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/spf13/cobra"
+)
+
+var (
+	sbomSource          imageSource
+	sbomFormat, sbomOut string
+	sbomPush            bool
+)
+
+var sbomCmd = &cobra.Command{
+	Use:   "sbom <ref>",
+	Short: "Generate an SBOM of the OS packages and files in an image",
+	Long: `Read the filesystem of an image, in a registry or, with --path, in a local layout, oci-archive or tarball, with all of its layers
+applied, and write an SBOM of it, in SPDX or CycloneDX JSON, to --out or stdout. It lists the OS packages installed, from the dpkg status
+database, including the status.d of distroless images, and the apk installed database, with package URLs for the distro in os-release,
+and every regular file, with its sha256. rpm databases are not read.
+With --push, the SBOM is also pushed to the repository of the image, as a referrer of its manifest.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ref := args[0]
+		var mediaType types.MediaType
+		switch sbomFormat {
+		case sbomutil.FormatSPDX:
+			mediaType = sbomutil.SPDXMediaType
+		case sbomutil.FormatCycloneDX:
+			mediaType = sbomutil.CycloneDXMediaType
+		default:
+			log.Fatalf("unknown format %q, must be one of: %s, %s", sbomFormat, sbomutil.FormatSPDX, sbomutil.FormatCycloneDX)
+		}
+		if sbomPush && sbomSource.path != "" {
+			log.Fatal("--push needs the image to be in a registry, not read with --path")
+		}
+
+		img, cleanup := sbomSource.image(ref)
+		defer cleanup()
+		inv, err := sbomutil.Collect(imageIndex(img, ref))
+		if err != nil {
+			log.Fatalf("unable to read filesystem of %s: %v", ref, err)
+		}
+		digest, err := img.Digest()
+		if err != nil {
+			log.Fatalf("unable to get digest of %s: %v", ref, err)
+		}
+		subject := sbomutil.Subject{Name: ref, Digest: digest.String()}
+		var doc interface{}
+		if sbomFormat == sbomutil.FormatSPDX {
+			doc = inv.SPDX(subject, time.Now())
+		} else {
+			doc = inv.CycloneDX(subject, time.Now())
+		}
+		b, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			log.Fatalf("unable to write SBOM: %v", err)
+		}
+		b = append(b, '\n')
+		if sbomOut == "" {
+			os.Stdout.Write(b)
+		} else {
+			if err := os.WriteFile(sbomOut, b, 0644); err != nil {
+				log.Fatalf("unable to write SBOM to %s: %v", sbomOut, err)
+			}
+			log.Printf("wrote SBOM of %d packages and %d files to %s", len(inv.Packages), len(inv.Files), sbomOut)
+		}
+
+		if !sbomPush {
+			return
+		}
+		r, err := name.ParseReference(ref)
+		if err != nil {
+			log.Fatalf("parsing reference %q: %v", ref, err)
+		}
+		manifestMediaType, err := img.MediaType()
+		if err != nil {
+			log.Fatalf("unable to get media type of %s: %v", ref, err)
+		}
+		size, err := img.Size()
+		if err != nil {
+			log.Fatalf("unable to get size of %s: %v", ref, err)
+		}
+		referrer, err := imageutil.NewReferrer(v1.Descriptor{MediaType: manifestMediaType, Size: size, Digest: digest}, mediaType,
+			mutate.Addendum{Layer: static.NewLayer(b, mediaType)})
+		if err != nil {
+			log.Fatalf("unable to create SBOM artifact: %v", err)
+		}
+		referrerDigest, err := referrer.Digest()
+		if err != nil {
+			log.Fatalf("unable to create SBOM artifact: %v", err)
+		}
+		target := r.Context().Digest(referrerDigest.String())
+		_, msg, options := apiOptions()
+		if verbose {
+			log.Println(msg)
+		}
+		if err := remote.Write(target, referrer, options...); err != nil {
+			log.Fatalf("error pushing SBOM to %s: %v", target, err)
+		}
+		log.Printf("pushed SBOM to %s, referring to %s", target, digest)
+	},
+}
+
+func sbomInit() {
+	sbomSource.addFlags(sbomCmd)
+	sbomCmd.Flags().StringVar(&sbomFormat, "format", sbomutil.FormatSPDX, fmt.Sprintf("format of the SBOM, one of '%s' or '%s'", sbomutil.FormatSPDX, sbomutil.FormatCycloneDX))
+	sbomCmd.RegisterFlagCompletionFunc("format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{sbomutil.FormatSPDX, sbomutil.FormatCycloneDX}, cobra.ShellCompDirectiveNoFileComp
+	})
+	sbomCmd.Flags().StringVar(&sbomOut, "out", "", "file to write the SBOM to, defaults to stdout")
+	sbomCmd.Flags().BoolVar(&sbomPush, "push", false, "also push the SBOM to the repository of the image, as a referrer of its manifest")
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/deitch/ocidist/pkg/imageutil"
+	"github.com/deitch/ocidist/pkg/signutil"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/spf13/cobra"
+)
+
+var (
+	signKey         string
+	signAnnotations []string
+	signReferrer    bool
+	signRecursive   bool
+)
+
+var signCmd = &cobra.Command{
+	Use:   "sign <ref>",
+	Short: "Sign an image with a local private key, as cosign does",
+	Long: `Sign the manifest <ref> points to with the ECDSA or ED25519 private key in --key, and push the signature as cosign does: a simple signing
+payload, bound to the digest, in a layer annotated with its signature. It goes under the sha256-<digest>.sig tag, added to any signatures
+already there, or with --referrer, in a new manifest with the signed one as its subject, for registries with the referrers API.
+The key must be an unencrypted PEM private key, e.g. from 'openssl genpkey -algorithm ed25519'; encrypted cosign keys are not supported.
+With --recursive, every manifest in an index is signed as well as the index, as are those in any nested index; attestations
+are about the images, not images to run, so are not signed. 'ocidist verify' checks the signatures.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		image := args[0]
+		b, err := os.ReadFile(signKey)
+		if err != nil {
+			log.Fatalf("unable to read key %s: %v", signKey, err)
+		}
+		priv, err := signutil.LoadPrivateKey(b)
+		if err != nil {
+			log.Fatalf("unable to load key %s: %v", signKey, err)
+		}
+		opts := signutil.SignOptions{
+			Annotations: parseKeyValues("annotations", signAnnotations),
+			Referrer:    signReferrer,
+		}
+		ref, err := name.ParseReference(image)
+		if err != nil {
+			log.Fatalf("parsing reference %q: %v", image, err)
+		}
+		_, msg, options := apiOptions()
+		if verbose {
+			log.Println(msg)
+		}
+		desc, err := remote.Get(ref, options...)
+		if err != nil {
+			log.Fatalf("error getting manifest for %s: %v", image, err)
+		}
+
+		descs := []v1.Descriptor{desc.Descriptor}
+		if signRecursive && desc.MediaType.IsIndex() {
+			ii, err := desc.ImageIndex()
+			if err != nil {
+				log.Fatalf("error getting index %s: %v", image, err)
+			}
+			children, err := signChildren(ii)
+			if err != nil {
+				log.Fatalf("error getting index %s: %v", image, err)
+			}
+			descs = append(descs, children...)
+		}
+		for _, d := range descs {
+			sigRef, err := signutil.SignManifest(ref.Context(), d, priv, opts, options...)
+			if err != nil {
+				log.Fatalf("unable to sign %s@%s: %v", ref.Context(), d.Digest, err)
+			}
+			log.Printf("signed %s@%s, signature pushed to %s", ref.Context(), d.Digest, sigRef)
+		}
+	},
+}
+
+// signChildren the manifests in ii to sign with it, recursing into nested indexes, and leaving out attestations
+func signChildren(ii v1.ImageIndex) ([]v1.Descriptor, error) {
+	index, err := ii.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	var descs []v1.Descriptor
+	for _, child := range index.Manifests {
+		if imageutil.IsAttestation(child) {
+			continue
+		}
+		descs = append(descs, child)
+		if !child.MediaType.IsIndex() {
+			continue
+		}
+		nested, err := ii.ImageIndex(child.Digest)
+		if err != nil {
+			return nil, err
+		}
+		children, err := signChildren(nested)
+		if err != nil {
+			return nil, err
+		}
+		descs = append(descs, children...)
+	}
+	return descs, nil
+}
+
+func signInit() {
+	signCmd.Flags().StringVar(&signKey, "key", "", "path to the PEM-encoded private key to sign with")
+	signCmd.MarkFlagRequired("key")
+	signCmd.Flags().StringArrayVarP(&signAnnotations, "annotations", "a", nil, "annotation to add to the signed payload, as KEY=VALUE, may be repeated")
+	signCmd.Flags().BoolVar(&signReferrer, "referrer", false, "push the signature as a referrer of the manifest, rather than under the sha256-<digest>.sig tag")
+	signCmd.Flags().BoolVar(&signRecursive, "recursive", false, "if the ref is an index, sign every manifest in it, and in any nested index, as well as the index, other than attestations")
+}
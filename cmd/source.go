@@ -0,0 +1,222 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/deitch/ocidist/pkg/imageutil"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/spf13/cobra"
+)
+
+// imageSource where to get an image from: a registry, or if path is set, a local layout, oci-archive or tarball
+type imageSource struct {
+	path, platform string
+}
+
+func (s *imageSource) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&s.path, "path", "", "read the image from this local v1 layout, oci-archive or tarball, rather than from a registry; the ref then is the ref name annotation, tag or digest of the image in it")
+	cmd.Flags().StringVar(&s.platform, "platform", "", "when the ref is an index, the platform to take from it, in format 'os/arch[/variant]', defaults to linux on the local architecture")
+}
+
+// image get the image for ref, per load, exiting on any error
+func (s *imageSource) image(ref string) (v1.Image, func()) {
+	img, cleanup, err := s.load(ref)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return img, cleanup
+}
+
+// load get the image for ref, resolving an index to the image for the platform. The returned cleanup func
+// removes any temporary files.
+func (s *imageSource) load(ref string) (v1.Image, func(), error) {
+	add, cleanup, err := s.loadAppendable(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch a := add.(type) {
+	case v1.Image:
+		return a, cleanup, nil
+	case v1.ImageIndex:
+		platform, err := readPlatform(s.platform)
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		img, err := imageutil.ImageForPlatform(a, platform)
+		if err != nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("unable to get image for %s: %v", ref, err)
+		}
+		return img, cleanup, nil
+	}
+	cleanup()
+	return nil, nil, fmt.Errorf("%s is neither an image nor an index", ref)
+}
+
+// appendable get the image or index for ref, per loadAppendable, exiting on any error
+func (s *imageSource) appendable(ref string) (mutate.Appendable, func()) {
+	add, cleanup, err := s.loadAppendable(ref)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return add, cleanup
+}
+
+// loadAppendable get the image or index for ref, as it is. The returned cleanup func removes any temporary files.
+func (s *imageSource) loadAppendable(ref string) (mutate.Appendable, func(), error) {
+	if s.path != "" {
+		tag, hash := ref, ""
+		if strings.HasPrefix(ref, "sha256:") {
+			tag, hash = "", ref
+		}
+		return readLocal(s.path, tag, hash)
+	}
+
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing reference %q: %v", ref, err)
+	}
+	_, msg, options := apiOptions()
+	if verbose {
+		log.Println(msg)
+	}
+	desc, err := remote.Get(r, options...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting manifest for %s: %v", ref, err)
+	}
+	if desc.MediaType.IsIndex() {
+		ii, err := desc.ImageIndex()
+		if err != nil {
+			return nil, nil, fmt.Errorf("error getting index %s: %v", ref, err)
+		}
+		return ii, func() {}, nil
+	}
+	img, err := desc.Image()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting image %s: %v", ref, err)
+	}
+	return img, func() {}, nil
+}
+
+// imageSourcePair where to get the two images a command compares, each from a registry, or a local layout, oci-archive
+// or tarball of its own, both for the same platform
+type imageSourcePair struct {
+	a, b imageSource
+}
+
+func (s *imageSourcePair) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&s.a.path, "path-a", "", "read <refA> from this local v1 layout, oci-archive or tarball, rather than from a registry; the ref then is the ref name annotation, tag or digest of the image in it")
+	cmd.Flags().StringVar(&s.b.path, "path-b", "", "read <refB> from this local v1 layout, oci-archive or tarball, rather than from a registry; the ref then is the ref name annotation, tag or digest of the image in it")
+	cmd.Flags().StringVar(&s.a.platform, "platform", "", "when a ref is an index, the platform to take from it, in format 'os/arch[/variant]', defaults to linux on the local architecture")
+}
+
+// images get the images for refA and refB, per load, exiting on any error
+func (s *imageSourcePair) images(refA, refB string) (v1.Image, v1.Image, func()) {
+	imgA, imgB, cleanup, err := s.load(refA, refB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return imgA, imgB, cleanup
+}
+
+// load get the images for refA and refB, per imageSource.load. The returned cleanup func removes any temporary
+// files of both.
+func (s *imageSourcePair) load(refA, refB string) (v1.Image, v1.Image, func(), error) {
+	s.b.platform = s.a.platform
+	imgA, cleanupA, err := s.a.load(refA)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	imgB, cleanupB, err := s.b.load(refB)
+	if err != nil {
+		cleanupA()
+		return nil, nil, nil, err
+	}
+	return imgA, imgB, func() {
+		cleanupA()
+		cleanupB()
+	}, nil
+}
+
+// imageIndex index the filesystem of img, read from ref
+func imageIndex(img v1.Image, ref string) *imageutil.Index {
+	openers, err := imageutil.LayerOpeners(img)
+	if err != nil {
+		log.Fatalf("unable to get layers for %s: %v", ref, err)
+	}
+	idx, err := imageutil.NewIndex(openers)
+	if err != nil {
+		log.Fatalf("unable to read layers of %s: %v", ref, err)
+	}
+	return idx
+}
+
+// layerDigests the digests of the layers of img, read from ref, lowest first
+func layerDigests(img v1.Image, ref string) []v1.Hash {
+	layers, err := img.Layers()
+	if err != nil {
+		log.Fatalf("unable to get layers for %s: %v", ref, err)
+	}
+	var digests []v1.Hash
+	for i, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			log.Fatalf("unable to get digest of layer %d of %s: %v", i, ref, err)
+		}
+		digests = append(digests, digest)
+	}
+	return digests
+}
+
+// imageTarget where to write an image: a registry, or if format is set, a local file or directory in that format
+type imageTarget struct {
+	to, format, tag string
+}
+
+func (t *imageTarget) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&t.to, "to", "", "where to write the image: a registry reference or, with --format, a local path")
+	cmd.MarkFlagRequired("to")
+	t.addFormatFlags(cmd)
+}
+
+// addFormatFlags add the flags for a target other than where it is, for commands that take that as an argument
+func (t *imageTarget) addFormatFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&t.format, "format", "", "write to a local path, rather than to a registry, in this format, one of 'v1-tarball', 'legacy-tarball', 'v1-layout' or 'oci-archive'")
+	cmd.RegisterFlagCompletionFunc("format", completeFormat)
+	cmd.Flags().StringVar(&t.tag, "tag", "", "with --format, the tag or ref name under which to save the image, defaults to the source ref")
+}
+
+// write add, which must be an image or an index, to the target. defaultTag is the tag for a local format if none
+// was given, and an index written to a tarball is resolved to its image for platform.
+func (t *imageTarget) write(add mutate.Appendable, defaultTag, platform string) {
+	if t.format == "" {
+		ref, err := name.ParseReference(t.to)
+		if err != nil {
+			log.Fatalf("parsing reference %q: %v", t.to, err)
+		}
+		_, msg, options := apiOptions()
+		if verbose {
+			log.Println(msg)
+		}
+		if err := pushAppendable(ref, add, options); err != nil {
+			log.Fatalf("error pushing to %s: %v", t.to, err)
+		}
+		return
+	}
+	if err := validateFormat(t.format); err != nil {
+		log.Fatal(err)
+	}
+	tag := t.tag
+	if tag == "" {
+		tag = defaultTag
+	}
+	if err := writeLocal(t.to, t.format, platform, []convertItem{{tags: []string{tag}, add: add}}); err != nil {
+		log.Fatalf("failure to write to %s in format %s: %v", t.to, t.format, err)
+	}
+}
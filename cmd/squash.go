@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/deitch/ocidist/pkg/imageutil"
+	"github.com/spf13/cobra"
+)
+
+var (
+	squashSource imageSource
+	squashTarget imageTarget
+	squashLast   int
+)
+
+var squashCmd = &cobra.Command{
+	Use:   "squash <ref>",
+	Short: "Squash the layers of an image into a single layer, and write it as a new image",
+	Long: `Flatten the layers of an image, in a registry or, with --path, in a local layout, oci-archive or tarball, into a single layer,
+applying whiteouts, and write the result as a new image, to a registry, or with --format, locally. With --last, only the last N layers
+are flattened, with whiteouts kept for anything they remove from the layers below. The config stays as it was, other than the
+history entries of the squashed layers, which are collapsed into one.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ref := args[0]
+		img, cleanup := squashSource.image(ref)
+		defer cleanup()
+
+		out, squashCleanup, err := imageutil.Squash(img, squashLast)
+		if err != nil {
+			log.Fatalf("unable to squash %s: %v", ref, err)
+		}
+		defer squashCleanup()
+		squashTarget.write(out, ref, squashSource.platform)
+
+		before, err := img.Layers()
+		if err != nil {
+			log.Fatalf("unable to get layers for %s: %v", ref, err)
+		}
+		after, err := out.Layers()
+		if err != nil {
+			log.Fatalf("unable to get layers for squashed image: %v", err)
+		}
+		log.Printf("squashed %s from %d to %d layers, written to %s", ref, len(before), len(after), squashTarget.to)
+	},
+}
+
+func squashInit() {
+	squashSource.addFlags(squashCmd)
+	squashTarget.addFlags(squashCmd)
+	squashCmd.Flags().IntVar(&squashLast, "last", 0, "squash only the last N layers, rather than all of them")
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/deitch/ocidist/pkg/signutil"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/spf13/cobra"
+)
+
+var (
+	verifyKey, verifyOutput string
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <ref>",
+	Short: "Verify the cosign-style signatures of an image against a local public key",
+	Long: `Find the signatures of the manifest <ref> points to, under the cosign sha256-<digest>.sig tag and among its referrers, and verify each
+against the ECDSA or ED25519 public key in --key, as written by 'cosign generate-key-pair', and that it signs that digest. Works offline
+from any signing service; keyless signing is not supported.
+For an index, the manifest of each platform is checked too, and reported as covered if it, or the index, has a valid signature.
+Exits with status 1 unless the manifest, or for an index, every platform, is covered.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		image := args[0]
+		validateOutput(verifyOutput)
+		b, err := os.ReadFile(verifyKey)
+		if err != nil {
+			log.Fatalf("unable to read key %s: %v", verifyKey, err)
+		}
+		pub, err := signutil.LoadPublicKey(b)
+		if err != nil {
+			log.Fatalf("unable to load key %s: %v", verifyKey, err)
+		}
+		ref, err := name.ParseReference(image)
+		if err != nil {
+			log.Fatalf("parsing reference %q: %v", image, err)
+		}
+		_, msg, options := apiOptions()
+		if verbose {
+			log.Println(msg)
+		}
+		result, err := signutil.Verify(ref, pub, options...)
+		if err != nil {
+			log.Fatalf("unable to verify %s: %v", image, err)
+		}
+
+		if verifyOutput == outputJSON {
+			writeJSON(result)
+		} else {
+			printVerifyResult("", image, result, false)
+			for i := range result.Platforms {
+				p := &result.Platforms[i]
+				platform := "unknown platform"
+				if p.Platform != nil {
+					platform = p.Platform.String()
+				}
+				printVerifyResult("  ", platform, p, result.Covered)
+			}
+		}
+		if !result.Verified() {
+			log.Fatalf("%s is not signed with %s", image, verifyKey)
+		}
+	},
+}
+
+func verifyInit() {
+	verifyCmd.Flags().StringVar(&verifyKey, "key", "", "path to the PEM-encoded public key to verify against")
+	verifyCmd.MarkFlagRequired("key")
+	addOutputFlag(verifyCmd, &verifyOutput)
+}
+
+// printVerifyResult print the status of r, labelled label, with each line starting with indent. byIndex whether
+// the index r is in has a valid signature.
+func printVerifyResult(indent, label string, r *signutil.Result, byIndex bool) {
+	status := "not signed"
+	switch {
+	case len(r.Signatures) > 0:
+		status = fmt.Sprintf("signed, %d valid signatures", len(r.Signatures))
+	case byIndex:
+		status = "covered by the index signature"
+	}
+	fmt.Printf("%s%s %s: %s\n", indent, label, r.Digest, status)
+	for _, e := range r.Errors {
+		fmt.Printf("%s  invalid signature %s\n", indent, e)
+	}
+}
@@ -0,0 +1,165 @@
+package formatutil
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// V1Tarball a tarball as produced by docker save, with a manifest.json
+	V1Tarball = "v1-tarball"
+	// LegacyTarball a tarball as produced by docker save before 1.10, with a repositories file and a directory per layer
+	LegacyTarball = "legacy-tarball"
+	// V1Layout an OCI layout directory
+	V1Layout = "v1-layout"
+	// OCIArchive an OCI layout in a single tar file
+	OCIArchive = "oci-archive"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Input the detected format of an input
+type Input struct {
+	// Format one of the known formats
+	Format string
+	// Gzip whether the tar file is gzip-compressed
+	Gzip bool
+}
+
+// UnknownFormatError returned when the content of an input is not in any of the known formats
+type UnknownFormatError struct {
+	Path   string
+	Reason string
+}
+
+func (e *UnknownFormatError) Error() string {
+	return fmt.Sprintf("unknown format for %s: %s", e.Path, e.Reason)
+}
+
+// Detect determine the format of the input at p by inspecting its content, rather than its name.
+// Directories are recognized as layouts by their index.json. Files are read as tar streams,
+// transparently decompressing gzip, and recognized by their entries: manifest.json for docker save tarballs,
+// oci-layout or index.json for oci-archives, and a repositories file with layer directories for legacy tarballs.
+// Returns an *UnknownFormatError if the content is not recognized.
+func Detect(p string) (Input, error) {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return Input{}, err
+	}
+
+	if fi.IsDir() {
+		if _, err := os.Stat(filepath.Join(p, "index.json")); err != nil {
+			return Input{}, &UnknownFormatError{Path: p, Reason: "directory has no index.json"}
+		}
+		return Input{Format: V1Layout}, nil
+	}
+
+	rc, compressed, err := open(p)
+	if err != nil {
+		return Input{}, err
+	}
+	defer rc.Close()
+
+	var (
+		manifest, repositories, ociLayout, index, layerDir bool
+		entries                                            int
+	)
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if entries == 0 {
+				return Input{}, &UnknownFormatError{Path: p, Reason: "not a tar file"}
+			}
+			return Input{}, fmt.Errorf("error reading tar entry in %s: %v", p, err)
+		}
+		entries++
+		name := strings.TrimPrefix(path.Clean(hdr.Name), "./")
+		switch {
+		case name == "manifest.json":
+			manifest = true
+		case name == "repositories":
+			repositories = true
+		case name == "oci-layout":
+			ociLayout = true
+		case name == "index.json":
+			index = true
+		case path.Dir(name) != "." && !strings.Contains(path.Dir(name), "/") && (path.Base(name) == "layer.tar" || path.Base(name) == "json"):
+			layerDir = true
+		}
+	}
+
+	in := Input{Gzip: compressed}
+	switch {
+	// newer docker save tarballs also are OCI layouts, but manifest.json has everything we need, including the tags
+	case manifest:
+		in.Format = V1Tarball
+	case ociLayout || index:
+		in.Format = OCIArchive
+	case repositories && layerDir:
+		in.Format = LegacyTarball
+	case entries == 0:
+		return Input{}, &UnknownFormatError{Path: p, Reason: "empty tar file"}
+	default:
+		return Input{}, &UnknownFormatError{Path: p, Reason: "tar file has none of manifest.json, index.json, oci-layout or repositories"}
+	}
+	return in, nil
+}
+
+// Open open the file at p for reading, transparently decompressing it if it is gzip-compressed
+func Open(p string) (io.ReadCloser, error) {
+	rc, _, err := open(p)
+	return rc, err
+}
+
+// Opener returns a func that calls Open on p, suitable for tarball.Opener
+func Opener(p string) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return Open(p)
+	}
+}
+
+// open open the file at p, returning a reader of its decompressed content, and whether it was compressed
+func open(p string) (io.ReadCloser, bool, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, false, err
+	}
+	br := bufio.NewReader(f)
+	magic, err := br.Peek(len(gzipMagic))
+	if err != nil || !bytes.Equal(magic, gzipMagic) {
+		return readCloser{Reader: br, closers: []io.Closer{f}}, false, nil
+	}
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		f.Close()
+		return nil, false, fmt.Errorf("unable to read gzip content of %s: %v", p, err)
+	}
+	return readCloser{Reader: gz, closers: []io.Closer{gz, f}}, true, nil
+}
+
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r readCloser) Close() error {
+	var err error
+	for _, c := range r.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,52 @@
+package formatutil_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/deitch/ocidist/pkg/formatutil"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		path    string
+		format  string
+		gzip    bool
+		unknown bool
+	}{
+		{"docker-save.tar", formatutil.V1Tarball, false, false},
+		{"docker-save.tar.gz", formatutil.V1Tarball, true, false},
+		{"legacy.tar", formatutil.LegacyTarball, false, false},
+		{"oci-archive.tar", formatutil.OCIArchive, false, false},
+		{"layout", formatutil.V1Layout, false, false},
+		{"unknown.tar", "", false, true},
+		{"not-a-tar.txt", "", false, true},
+		// a directory that is not a layout
+		{".", "", false, true},
+	}
+
+	for _, tt := range tests {
+		in, err := formatutil.Detect(filepath.Join("testdata", tt.path))
+		var unknownErr *formatutil.UnknownFormatError
+		switch {
+		case tt.unknown && !errors.As(err, &unknownErr):
+			t.Errorf("%s: expected UnknownFormatError, actual %v", tt.path, err)
+		case tt.unknown:
+		case err != nil:
+			t.Errorf("%s: unexpected error %v", tt.path, err)
+		case in.Format != tt.format:
+			t.Errorf("%s: mismatched format, actual %s expected %s", tt.path, in.Format, tt.format)
+		case in.Gzip != tt.gzip:
+			t.Errorf("%s: mismatched gzip, actual %v expected %v", tt.path, in.Gzip, tt.gzip)
+		}
+	}
+}
+
+func TestDetectMissing(t *testing.T) {
+	_, err := formatutil.Detect(filepath.Join("testdata", "does-not-exist"))
+	if !os.IsNotExist(err) {
+		t.Errorf("expected not exist error, actual %v", err)
+	}
+}
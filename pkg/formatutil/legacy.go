@@ -0,0 +1,121 @@
+package formatutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/legacy"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// LegacyImages read every image in a legacy tarball, as produced by docker save before 1.10. These have no
+// manifest.json; instead, a repositories file maps each tag to the id of its top layer, and each layer is
+// a directory with its json metadata and an uncompressed layer.tar. Images are reassembled by following
+// the parent chain from each top layer. The image config is taken from the metadata of the top layer.
+func LegacyImages(opener tarball.Opener) ([]TaggedImage, error) {
+	files, err := readTarFiles(opener, func(name string) bool {
+		return name == "repositories" || (path.Base(name) == "json" && path.Dir(name) != ".")
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read legacy tarball: %v", err)
+	}
+	reposb, ok := files["repositories"]
+	if !ok {
+		return nil, fmt.Errorf("legacy tarball has no repositories file")
+	}
+	var repos map[string]map[string]string
+	if err := json.Unmarshal(reposb, &repos); err != nil {
+		return nil, fmt.Errorf("unable to parse repositories file: %v", err)
+	}
+
+	layers := map[string]*legacy.LayerConfigFile{}
+	for name, b := range files {
+		if name == "repositories" {
+			continue
+		}
+		var lc legacy.LayerConfigFile
+		if err := json.Unmarshal(b, &lc); err != nil {
+			return nil, fmt.Errorf("unable to parse layer metadata %s: %v", name, err)
+		}
+		layers[path.Dir(name)] = &lc
+	}
+
+	// collect the tags for each top layer, in a stable order
+	tagsByTop := map[string][]string{}
+	var tops []string
+	for repo, tags := range repos {
+		for tag, id := range tags {
+			if _, ok := tagsByTop[id]; !ok {
+				tops = append(tops, id)
+			}
+			tagsByTop[id] = append(tagsByTop[id], fmt.Sprintf("%s:%s", repo, tag))
+		}
+	}
+	sort.Strings(tops)
+
+	var images []TaggedImage
+	for _, top := range tops {
+		img, err := legacyImage(opener, layers, top)
+		if err != nil {
+			return nil, err
+		}
+		tags := tagsByTop[top]
+		sort.Strings(tags)
+		images = append(images, TaggedImage{Tags: tags, Image: img})
+	}
+	return images, nil
+}
+
+func legacyImage(opener tarball.Opener, layers map[string]*legacy.LayerConfigFile, top string) (v1.Image, error) {
+	// follow the chain from the top down to the base layer
+	var chain []string
+	seen := map[string]bool{}
+	for id := top; id != ""; {
+		lc, ok := layers[id]
+		if !ok {
+			return nil, fmt.Errorf("layer %s not found in legacy tarball", id)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("loop in parent chain at layer %s", id)
+		}
+		seen[id] = true
+		chain = append([]string{id}, chain...)
+		id = lc.Parent
+	}
+
+	cfg := layers[top].ConfigFile.DeepCopy()
+	cfg.RootFS = v1.RootFS{Type: "layers"}
+	cfg.History = nil
+	img, err := mutate.ConfigFile(empty.Image, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var addenda []mutate.Addendum
+	for _, id := range chain {
+		lc := layers[id]
+		if lc.Throwaway {
+			continue
+		}
+		layer, err := tarball.LayerFromOpener(tarEntryOpener(opener, path.Join(id, "layer.tar")))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read layer %s: %v", id, err)
+		}
+		addenda = append(addenda, mutate.Addendum{
+			Layer: layer,
+			History: v1.History{
+				Created:   lc.Created,
+				Author:    lc.Author,
+				CreatedBy: strings.Join(lc.ContainerConfig.Cmd, " "),
+				Comment:   lc.Comment,
+			},
+		})
+	}
+	return mutate.Append(img, addenda...)
+}
@@ -0,0 +1,168 @@
+package formatutil
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// TaggedImage an image read from a tarball, along with all of the tags it has there
+type TaggedImage struct {
+	Tags  []string
+	Image v1.Image
+}
+
+// TarballImages read every image listed in the manifest.json of a docker save tarball, as
+// created by `docker save a b c`. Images without tags are returned with no Tags.
+func TarballImages(opener tarball.Opener) ([]TaggedImage, error) {
+	manifest, err := tarball.LoadManifest(opener)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read manifest.json: %v", err)
+	}
+	var images []TaggedImage
+	for i, desc := range manifest {
+		var (
+			img  v1.Image
+			tags []string
+		)
+		// images saved by digest have an empty tag
+		for _, tag := range desc.RepoTags {
+			if tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+		if len(tags) > 0 {
+			tag, err := name.NewTag(tags[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid tag %s for image %d: %v", tags[0], i, err)
+			}
+			img, err = tarball.Image(opener, &tag)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read image %s: %v", tag, err)
+			}
+		} else {
+			// ggcr can only select an untagged image if it is the only one, so present it with a
+			// manifest.json that has just this image
+			img, err = tarball.Image(singleManifestOpener(opener, desc), nil)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read untagged image %d: %v", i, err)
+			}
+		}
+		images = append(images, TaggedImage{Tags: tags, Image: img})
+	}
+	return images, nil
+}
+
+// singleManifestOpener returns an opener for the tarball from opener, where manifest.json is replaced by one
+// that contains only desc
+func singleManifestOpener(opener tarball.Opener, desc tarball.Descriptor) tarball.Opener {
+	return func() (io.ReadCloser, error) {
+		b, err := json.Marshal(tarball.Manifest{desc})
+		if err != nil {
+			return nil, err
+		}
+		rc, err := opener()
+		if err != nil {
+			return nil, err
+		}
+		pr, pw := io.Pipe()
+		go func() {
+			defer rc.Close()
+			tr := tar.NewReader(rc)
+			tw := tar.NewWriter(pw)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+				if path.Clean(hdr.Name) == "manifest.json" {
+					hdr.Size = int64(len(b))
+					if err := tw.WriteHeader(hdr); err != nil {
+						pw.CloseWithError(err)
+						return
+					}
+					if _, err := tw.Write(b); err != nil {
+						pw.CloseWithError(err)
+						return
+					}
+					continue
+				}
+				if err := tw.WriteHeader(hdr); err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+				if _, err := io.Copy(tw, tr); err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+			}
+			pw.CloseWithError(tw.Close())
+		}()
+		return pr, nil
+	}
+}
+
+// tarEntryOpener returns an opener for the single file named entry inside the tarball from opener
+func tarEntryOpener(opener tarball.Opener, entry string) tarball.Opener {
+	return func() (io.ReadCloser, error) {
+		rc, err := opener()
+		if err != nil {
+			return nil, err
+		}
+		tr := tar.NewReader(rc)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				rc.Close()
+				return nil, fmt.Errorf("file %s not found in tar", entry)
+			}
+			if err != nil {
+				rc.Close()
+				return nil, err
+			}
+			if strings.TrimPrefix(path.Clean(hdr.Name), "./") == entry {
+				return readCloser{Reader: tr, closers: []io.Closer{rc}}, nil
+			}
+		}
+	}
+}
+
+// readTarFiles read the content of every regular file in the tarball from opener for which want returns true
+func readTarFiles(opener tarball.Opener, want func(string) bool) (map[string][]byte, error) {
+	rc, err := opener()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	files := map[string][]byte{}
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimPrefix(path.Clean(hdr.Name), "./")
+		if hdr.Typeflag != tar.TypeReg || !want(name) {
+			continue
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %v", name, err)
+		}
+		files[name] = b
+	}
+}
@@ -0,0 +1,195 @@
+package formatutil_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/deitch/ocidist/pkg/formatutil"
+	legacytarball "github.com/google/go-containerregistry/pkg/legacy/tarball"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+func bytesOpener(b []byte) tarball.Opener {
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(b)), nil
+	}
+}
+
+func TestTarballImages(t *testing.T) {
+	var (
+		imgs   []v1.Image
+		refs   = map[name.Reference]v1.Image{}
+		tagged = map[string]v1.Hash{}
+	)
+	for i := 0; i < 3; i++ {
+		img, err := random.Image(32, 2)
+		if err != nil {
+			t.Fatalf("unable to create random image: %v", err)
+		}
+		imgs = append(imgs, img)
+	}
+	for i, tagStr := range []string{"example.com/alpha:1", "example.com/beta:2", "example.com/gamma:3"} {
+		tag, _ := name.NewTag(tagStr)
+		refs[tag] = imgs[i]
+		tagged[tag.String()], _ = imgs[i].Digest()
+	}
+	var buf bytes.Buffer
+	if err := tarball.MultiRefWrite(refs, &buf); err != nil {
+		t.Fatalf("unable to write tarball: %v", err)
+	}
+	// the last one should be untagged, as if saved by image id, so remove its tags from manifest.json
+	untagged, _ := imgs[2].Digest()
+	buf = rewriteTarFile(t, buf.Bytes(), "manifest.json", func(b []byte) []byte {
+		var m tarball.Manifest
+		if err := json.Unmarshal(b, &m); err != nil {
+			t.Fatalf("unable to parse manifest.json: %v", err)
+		}
+		for i := range m {
+			if len(m[i].RepoTags) > 0 && m[i].RepoTags[0] == "example.com/gamma:3" {
+				m[i].RepoTags = nil
+			}
+		}
+		b, _ = json.Marshal(m)
+		return b
+	})
+
+	images, err := formatutil.TarballImages(bytesOpener(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("unable to read tarball: %v", err)
+	}
+	if len(images) != 3 {
+		t.Fatalf("mismatched image count, actual %d expected 3", len(images))
+	}
+	var foundUntagged bool
+	for _, ti := range images {
+		digest, err := ti.Image.Digest()
+		if err != nil {
+			t.Fatalf("unable to get digest: %v", err)
+		}
+		if len(ti.Tags) == 0 {
+			foundUntagged = true
+			if digest != untagged {
+				t.Errorf("mismatched untagged digest, actual %s expected %s", digest, untagged)
+			}
+			continue
+		}
+		for _, tag := range ti.Tags {
+			parsed, _ := name.NewTag(tag)
+			if expected := tagged[parsed.String()]; digest != expected {
+				t.Errorf("%s: mismatched digest, actual %s expected %s", tag, digest, expected)
+			}
+		}
+	}
+	if !foundUntagged {
+		t.Errorf("untagged image not found")
+	}
+}
+
+func TestLegacyImages(t *testing.T) {
+	img, err := random.Image(32, 3)
+	if err != nil {
+		t.Fatalf("unable to create random image: %v", err)
+	}
+	tag, _ := name.NewTag("example.com/legacy:1.0")
+	var buf bytes.Buffer
+	if err := legacytarball.Write(tag, img, &buf); err != nil {
+		t.Fatalf("unable to write legacy tarball: %v", err)
+	}
+	// strip manifest.json, as docker before 1.10 did not write it
+	var stripped bytes.Buffer
+	tr := tar.NewReader(&buf)
+	tw := tar.NewWriter(&stripped)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unable to read legacy tarball: %v", err)
+		}
+		if hdr.Name == "manifest.json" {
+			continue
+		}
+		tw.WriteHeader(hdr)
+		io.Copy(tw, tr)
+	}
+	tw.Close()
+
+	images, err := formatutil.LegacyImages(bytesOpener(stripped.Bytes()))
+	if err != nil {
+		t.Fatalf("unable to read legacy tarball: %v", err)
+	}
+	if len(images) != 1 {
+		t.Fatalf("mismatched image count, actual %d expected 1", len(images))
+	}
+	if len(images[0].Tags) != 1 || images[0].Tags[0] != tag.String() {
+		t.Errorf("mismatched tags, actual %v expected %v", images[0].Tags, []string{tag.String()})
+	}
+	expected, _ := img.ConfigFile()
+	actual, err := images[0].Image.ConfigFile()
+	if err != nil {
+		t.Fatalf("unable to read config: %v", err)
+	}
+	if len(actual.RootFS.DiffIDs) != len(expected.RootFS.DiffIDs) {
+		t.Fatalf("mismatched layer count, actual %d expected %d", len(actual.RootFS.DiffIDs), len(expected.RootFS.DiffIDs))
+	}
+	for i := range expected.RootFS.DiffIDs {
+		if actual.RootFS.DiffIDs[i] != expected.RootFS.DiffIDs[i] {
+			t.Errorf("layer %d: mismatched diffID, actual %s expected %s", i, actual.RootFS.DiffIDs[i], expected.RootFS.DiffIDs[i])
+		}
+	}
+	if actual.Architecture != expected.Architecture || actual.OS != expected.OS {
+		t.Errorf("mismatched platform, actual %s/%s expected %s/%s", actual.OS, actual.Architecture, expected.OS, expected.Architecture)
+	}
+}
+
+func TestLegacyFixture(t *testing.T) {
+	images, err := formatutil.LegacyImages(formatutil.Opener("testdata/legacy.tar"))
+	if err != nil {
+		t.Fatalf("unable to read legacy fixture: %v", err)
+	}
+	if len(images) != 1 {
+		t.Fatalf("mismatched image count, actual %d expected 1", len(images))
+	}
+	layers, err := images[0].Image.Layers()
+	if err != nil {
+		t.Fatalf("unable to read layers: %v", err)
+	}
+	if len(layers) != 2 {
+		t.Errorf("mismatched layer count, actual %d expected 2", len(layers))
+	}
+}
+
+// rewriteTarFile rewrite the content of the file named name in the tar stream b using update
+func rewriteTarFile(t *testing.T, b []byte, name string, update func([]byte) []byte) bytes.Buffer {
+	var out bytes.Buffer
+	tr := tar.NewReader(bytes.NewReader(b))
+	tw := tar.NewWriter(&out)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unable to read tar stream: %v", err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("unable to read tar entry %s: %v", hdr.Name, err)
+		}
+		if hdr.Name == name {
+			content = update(content)
+			hdr.Size = int64(len(content))
+		}
+		tw.WriteHeader(hdr)
+		tw.Write(content)
+	}
+	tw.Close()
+	return out
+}
@@ -0,0 +1,43 @@
+package imageutil
+
+import (
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+const (
+	// attestationTypeAnnotation the annotation on the descriptor of a manifest in an index, as buildkit writes them,
+	// with what kind of reference to another manifest it is
+	attestationTypeAnnotation = "vnd.docker.reference.type"
+	// attestationManifestType the attestationTypeAnnotation of an attestation manifest
+	attestationManifestType = "attestation-manifest"
+	// attestationReferenceAnnotation the annotation on the descriptor of an attestation manifest in an index, with the
+	// digest of the image manifest it is for
+	attestationReferenceAnnotation = "vnd.docker.reference.digest"
+)
+
+// IsAttestation whether desc, from an index, is of an attestation manifest, as buildkit adds for each image, rather
+// than of an image to run. These have the platform unknown/unknown, and annotations that refer to their image.
+func IsAttestation(desc v1.Descriptor) bool {
+	if desc.Annotations[attestationTypeAnnotation] == attestationManifestType {
+		return true
+	}
+	return desc.Platform != nil && desc.Platform.OS == "unknown" && desc.Platform.Architecture == "unknown"
+}
+
+// remapAttestations point the attestations among adds at the new digests of the images they are for, as given by
+// digests, new by old, for any that changed
+func remapAttestations(adds []mutate.IndexAddendum, digests map[string]string) {
+	for i, add := range adds {
+		ref, ok := add.Descriptor.Annotations[attestationReferenceAnnotation]
+		if !ok || digests[ref] == "" {
+			continue
+		}
+		annotations := map[string]string{}
+		for k, v := range add.Descriptor.Annotations {
+			annotations[k] = v
+		}
+		annotations[attestationReferenceAnnotation] = digests[ref]
+		adds[i].Descriptor.Annotations = annotations
+	}
+}
@@ -0,0 +1,299 @@
+package imageutil
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/containerd/stargz-snapshotter/estargz"
+	"github.com/google/go-containerregistry/pkg/compression"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// CompressionOptions how to recompress the layers of an image
+type CompressionOptions struct {
+	// Compression the target compression of every layer
+	Compression compression.Compression
+	// Level the compression level; 0 means the default for the algorithm
+	Level int
+	// Force recompress even those layers that already are in the target compression
+	Force bool
+	// Estargz convert the layers to eStargz, which always is gzip-compressed
+	Estargz bool
+	// PrioritizedFiles with Estargz, the files to place first in each layer, so that they can be prefetched
+	PrioritizedFiles []string
+}
+
+// LayerCompression get the compression of a layer from its media type
+func LayerCompression(mt types.MediaType) (compression.Compression, error) {
+	switch mt {
+	case types.OCILayer, types.DockerLayer, types.OCIRestrictedLayer, types.DockerForeignLayer:
+		return compression.GZip, nil
+	case types.OCILayerZStd:
+		return compression.ZStd, nil
+	case types.OCIUncompressedLayer, types.DockerUncompressedLayer, types.OCIUncompressedRestrictedLayer:
+		return compression.None, nil
+	}
+	return "", fmt.Errorf("unknown layer media type %s", mt)
+}
+
+// layerMediaType get the media type for a layer with the given compression. zstd only exists for OCI.
+func layerMediaType(comp compression.Compression, oci bool) types.MediaType {
+	switch {
+	case comp == compression.ZStd:
+		return types.OCILayerZStd
+	case comp == compression.None && oci:
+		return types.OCIUncompressedLayer
+	case comp == compression.None:
+		return types.DockerUncompressedLayer
+	case oci:
+		return types.OCILayer
+	}
+	return types.DockerLayer
+}
+
+// RecompressImage returns img with its layers recompressed per opts. The manifest is rewritten with the new layer
+// digests, sizes and media types; the diff IDs in the config do not change, as the uncompressed content is the same.
+// As zstd is only defined for OCI, a docker manifest is converted to OCI when recompressing to zstd.
+// Converting to eStargz changes the content of the layers, so their diff IDs are updated in the config.
+// Foreign layers, and any others that are not image layers, are left as they are. A manifest with no image layers at
+// all, such as an attestation, is returned unchanged.
+func RecompressImage(img v1.Image, opts CompressionOptions) (v1.Image, error) {
+	if opts.Estargz && opts.Compression != compression.GZip {
+		return nil, fmt.Errorf("eStargz layers must be gzip-compressed, not %s", opts.Compression)
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	origCfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	cfg := origCfg.DeepCopy()
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasImageLayers(manifest) {
+		return img, nil
+	}
+
+	mt := manifest.MediaType
+	cfgMT := manifest.Config.MediaType
+	if opts.Compression == compression.ZStd && mt == types.DockerManifestSchema2 {
+		mt = types.OCIManifestSchema1
+		cfgMT = types.OCIConfigJSON
+	}
+	oci := mt == types.OCIManifestSchema1
+
+	var addenda []mutate.Addendum
+	for i, layer := range layers {
+		desc := manifest.Layers[i]
+		newLayer, err := recompressLayer(layer, desc, oci, opts)
+		if err != nil {
+			return nil, fmt.Errorf("unable to recompress layer %s: %v", desc.Digest, err)
+		}
+		annotations := desc.Annotations
+		if el, ok := newLayer.(*estargzLayer); ok {
+			if annotations, err = el.annotate(desc.Annotations); err != nil {
+				return nil, fmt.Errorf("unable to convert layer %s to eStargz: %v", desc.Digest, err)
+			}
+			if cfg.RootFS.DiffIDs[i], err = el.DiffID(); err != nil {
+				return nil, err
+			}
+		}
+		addenda = append(addenda, mutate.Addendum{
+			Layer:       newLayer,
+			Annotations: annotations,
+			URLs:        desc.URLs,
+		})
+	}
+
+	base := mutate.ConfigMediaType(mutate.MediaType(empty.Image, mt), cfgMT)
+	if len(manifest.Annotations) > 0 {
+		base = mutate.Annotations(base, manifest.Annotations).(v1.Image)
+	}
+	out, err := mutate.Append(base, addenda...)
+	if err != nil {
+		return nil, err
+	}
+	// the config is the same as it was, other than eStargz diff IDs, including the history, which Append would have changed
+	return mutate.ConfigFile(out, cfg)
+}
+
+// RecompressIndex returns ii with the layers of every image in it, or in any child index, recompressed per opts.
+// The descriptors of the children keep their platforms and annotations.
+func RecompressIndex(ii v1.ImageIndex, opts CompressionOptions) (v1.ImageIndex, error) {
+	index, err := ii.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	mt := index.MediaType
+	if opts.Compression == compression.ZStd && mt == types.DockerManifestList {
+		mt = types.OCIImageIndex
+	}
+
+	var (
+		adds []mutate.IndexAddendum
+		// the new digests of the children that changed, by their old ones
+		digests = map[string]string{}
+	)
+	for _, desc := range index.Manifests {
+		var add mutate.Appendable
+		switch desc.MediaType {
+		case types.OCIImageIndex, types.DockerManifestList:
+			child, err := ii.ImageIndex(desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+			if add, err = RecompressIndex(child, opts); err != nil {
+				return nil, err
+			}
+		case types.OCIManifestSchema1, types.DockerManifestSchema2:
+			img, err := ii.Image(desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+			if add, err = RecompressImage(img, opts); err != nil {
+				return nil, err
+			}
+		default:
+			// anything else, such as an artifact, has no image layers to recompress
+			img, err := ii.Image(desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+			add = img
+		}
+		newDigest, err := add.Digest()
+		if err != nil {
+			return nil, err
+		}
+		if newDigest != desc.Digest {
+			digests[desc.Digest.String()] = newDigest.String()
+		}
+		adds = append(adds, mutate.IndexAddendum{
+			Add: add,
+			Descriptor: v1.Descriptor{
+				Platform:    desc.Platform,
+				Annotations: desc.Annotations,
+				URLs:        desc.URLs,
+			},
+		})
+	}
+	// attestations refer to the images they are for by digest, which changed with their layers
+	remapAttestations(adds, digests)
+	out := mutate.AppendManifests(mutate.IndexMediaType(empty.Index, mt), adds...)
+	if len(index.Annotations) > 0 {
+		out = mutate.Annotations(out, index.Annotations).(v1.ImageIndex)
+	}
+	return out, nil
+}
+
+// hasImageLayers whether any of the layers of manifest are image layers that can be recompressed
+func hasImageLayers(manifest *v1.Manifest) bool {
+	for _, l := range manifest.Layers {
+		if _, err := LayerCompression(l.MediaType); err == nil && l.MediaType != types.DockerForeignLayer {
+			return true
+		}
+	}
+	return false
+}
+
+// recompressLayer layer, with descriptor desc, recompressed per opts. Foreign layers, and any that are not image
+// layers, are returned as they are.
+func recompressLayer(layer v1.Layer, desc v1.Descriptor, oci bool, opts CompressionOptions) (v1.Layer, error) {
+	mt := desc.MediaType
+	current, err := LayerCompression(mt)
+	if err != nil || mt == types.DockerForeignLayer {
+		return layer, nil
+	}
+	target := layerMediaType(opts.Compression, oci)
+	if opts.Estargz {
+		if _, ok := desc.Annotations[estargz.TOCJSONDigestAnnotation]; !ok || opts.Force {
+			return &estargzLayer{base: layer, mediaType: target, level: opts.Level, prioritized: opts.PrioritizedFiles}, nil
+		}
+	}
+	if current == opts.Compression && !opts.Force {
+		// nothing to recompress, but the media type may change from docker to OCI
+		if target == mt {
+			return layer, nil
+		}
+		return &mediaTypeLayer{Layer: layer, mediaType: target}, nil
+	}
+	if opts.Compression == compression.None {
+		return &uncompressedLayer{base: layer, mediaType: target}, nil
+	}
+	layerOpts := []tarball.LayerOption{
+		tarball.WithCompression(opts.Compression),
+		tarball.WithMediaType(target),
+	}
+	if opts.Level != 0 {
+		layerOpts = append(layerOpts, tarball.WithCompressionLevel(opts.Level))
+	}
+	newLayer, err := tarball.LayerFromOpener(layer.Uncompressed, layerOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return &mediaTypeLayer{Layer: newLayer, mediaType: target}, nil
+}
+
+// mediaTypeLayer a layer with its media type overridden. Wrapping a tarball layer in it also hides its Descriptor,
+// so that the empty annotations that tarball layer descriptors have do not end up in the manifest.
+type mediaTypeLayer struct {
+	v1.Layer
+	mediaType types.MediaType
+}
+
+func (l *mediaTypeLayer) MediaType() (types.MediaType, error) {
+	return l.mediaType, nil
+}
+
+// uncompressedLayer a layer whose blob is the uncompressed tar stream of base
+type uncompressedLayer struct {
+	base      v1.Layer
+	mediaType types.MediaType
+	once      sync.Once
+	size      int64
+	sizeErr   error
+}
+
+func (l *uncompressedLayer) Digest() (v1.Hash, error) {
+	return l.base.DiffID()
+}
+
+func (l *uncompressedLayer) DiffID() (v1.Hash, error) {
+	return l.base.DiffID()
+}
+
+func (l *uncompressedLayer) Compressed() (io.ReadCloser, error) {
+	return l.base.Uncompressed()
+}
+
+func (l *uncompressedLayer) Uncompressed() (io.ReadCloser, error) {
+	return l.base.Uncompressed()
+}
+
+// Size the size of the uncompressed stream, which we only can know by reading it
+func (l *uncompressedLayer) Size() (int64, error) {
+	l.once.Do(func() {
+		var rc io.ReadCloser
+		rc, l.sizeErr = l.base.Uncompressed()
+		if l.sizeErr != nil {
+			return
+		}
+		defer rc.Close()
+		l.size, l.sizeErr = io.Copy(io.Discard, rc)
+	})
+	return l.size, l.sizeErr
+}
+
+func (l *uncompressedLayer) MediaType() (types.MediaType, error) {
+	return l.mediaType, nil
+}
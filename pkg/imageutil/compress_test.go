@@ -0,0 +1,209 @@
+package imageutil_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/deitch/ocidist/pkg/imageutil"
+	"github.com/google/go-containerregistry/pkg/compression"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
+)
+
+func TestRecompressImage(t *testing.T) {
+	tests := []struct {
+		opts       imageutil.CompressionOptions
+		manifestMT types.MediaType
+		layerMT    types.MediaType
+		magic      []byte
+	}{
+		{imageutil.CompressionOptions{Compression: compression.ZStd}, types.OCIManifestSchema1, types.OCILayerZStd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+		{imageutil.CompressionOptions{Compression: compression.ZStd, Level: 9}, types.OCIManifestSchema1, types.OCILayerZStd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+		{imageutil.CompressionOptions{Compression: compression.None}, types.DockerManifestSchema2, types.DockerUncompressedLayer, nil},
+		{imageutil.CompressionOptions{Compression: compression.GZip, Level: 9, Force: true}, types.DockerManifestSchema2, types.DockerLayer, []byte{0x1f, 0x8b}},
+	}
+	for i, tt := range tests {
+		// random images are docker media types with gzip layers
+		img, err := random.Image(256, 2)
+		if err != nil {
+			t.Fatalf("unable to create random image: %v", err)
+		}
+		out, err := imageutil.RecompressImage(img, tt.opts)
+		if err != nil {
+			t.Errorf("%d: unable to recompress: %v", i, err)
+			continue
+		}
+		// validate only knows how to decompress gzip, so the layers are checked below
+		if err := validate.Image(out, validate.Fast); err != nil {
+			t.Errorf("%d: invalid image: %v", i, err)
+		}
+		manifest, err := out.Manifest()
+		if err != nil {
+			t.Fatalf("%d: unable to read manifest: %v", i, err)
+		}
+		if manifest.MediaType != tt.manifestMT {
+			t.Errorf("%d: mismatched manifest media type, actual %s expected %s", i, manifest.MediaType, tt.manifestMT)
+		}
+		origDiffIDs := diffIDs(t, img)
+		newDiffIDs := diffIDs(t, out)
+		if len(origDiffIDs) != len(newDiffIDs) {
+			t.Fatalf("%d: mismatched layer count, actual %d expected %d", i, len(newDiffIDs), len(origDiffIDs))
+		}
+		for j := range origDiffIDs {
+			if origDiffIDs[j] != newDiffIDs[j] {
+				t.Errorf("%d: mismatched diff ID %d, actual %s expected %s", i, j, newDiffIDs[j], origDiffIDs[j])
+			}
+		}
+		layers, _ := out.Layers()
+		for j, desc := range manifest.Layers {
+			if desc.MediaType != tt.layerMT {
+				t.Errorf("%d: mismatched layer %d media type, actual %s expected %s", i, j, desc.MediaType, tt.layerMT)
+			}
+			rc, err := layers[j].Compressed()
+			if err != nil {
+				t.Fatalf("%d: unable to read layer %d: %v", i, j, err)
+			}
+			b, _ := io.ReadAll(rc)
+			rc.Close()
+			digest, size, _ := v1.SHA256(bytes.NewReader(b))
+			if digest != desc.Digest || size != desc.Size {
+				t.Errorf("%d: mismatched layer %d content, actual %s/%d expected %s/%d", i, j, digest, size, desc.Digest, desc.Size)
+			}
+			if tt.magic != nil && !bytes.HasPrefix(b, tt.magic) {
+				t.Errorf("%d: layer %d does not have the expected compression", i, j)
+			}
+		}
+		// gzip is recompressed only when forced
+		if tt.opts.Compression == compression.GZip {
+			origDigest, _ := img.Digest()
+			newDigest, _ := out.Digest()
+			if tt.opts.Force && origDigest == newDigest {
+				t.Errorf("%d: forced recompression left the image unchanged", i)
+			}
+		}
+	}
+}
+
+func TestRecompressUnchanged(t *testing.T) {
+	img, err := random.Image(256, 2)
+	if err != nil {
+		t.Fatalf("unable to create random image: %v", err)
+	}
+	out, err := imageutil.RecompressImage(img, imageutil.CompressionOptions{Compression: compression.GZip})
+	if err != nil {
+		t.Fatalf("unable to recompress: %v", err)
+	}
+	origLayers, _ := img.Layers()
+	newLayers, _ := out.Layers()
+	for i := range origLayers {
+		origDigest, _ := origLayers[i].Digest()
+		newDigest, _ := newLayers[i].Digest()
+		if origDigest != newDigest {
+			t.Errorf("layer %d was recompressed, actual %s expected %s", i, newDigest, origDigest)
+		}
+	}
+}
+
+func TestRecompressIndex(t *testing.T) {
+	child, err := random.Index(256, 1, 2)
+	if err != nil {
+		t.Fatalf("unable to create random index: %v", err)
+	}
+	platform := &v1.Platform{OS: "linux", Architecture: "arm64"}
+	ii := mutate.AppendManifests(empty.Index, mutate.IndexAddendum{Add: child, Descriptor: v1.Descriptor{Platform: platform}})
+	out, err := imageutil.RecompressIndex(ii, imageutil.CompressionOptions{Compression: compression.ZStd})
+	if err != nil {
+		t.Fatalf("unable to recompress: %v", err)
+	}
+	if err := validate.Index(out, validate.Fast); err != nil {
+		t.Errorf("invalid index: %v", err)
+	}
+	index, err := out.IndexManifest()
+	if err != nil {
+		t.Fatalf("unable to read index manifest: %v", err)
+	}
+	if len(index.Manifests) != 1 || index.Manifests[0].Platform == nil || !index.Manifests[0].Platform.Equals(*platform) {
+		t.Errorf("platform was not preserved: %v", index.Manifests)
+	}
+}
+
+func TestRecompressIndexAttestation(t *testing.T) {
+	img, err := random.Image(256, 2)
+	if err != nil {
+		t.Fatalf("unable to create random image: %v", err)
+	}
+	imgDigest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an attestation manifest, as buildkit adds for each image
+	attestation, err := mutate.Append(mutate.MediaType(empty.Image, types.OCIManifestSchema1), mutate.Addendum{
+		Layer: static.NewLayer([]byte(`{"_type":"https://in-toto.io/Statement/v0.1"}`), "application/vnd.in-toto+json"),
+	})
+	if err != nil {
+		t.Fatalf("unable to create attestation: %v", err)
+	}
+	attestationDigest, err := attestation.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ii := mutate.AppendManifests(mutate.IndexMediaType(empty.Index, types.OCIImageIndex),
+		mutate.IndexAddendum{Add: img, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}}},
+		mutate.IndexAddendum{Add: attestation, Descriptor: v1.Descriptor{
+			Platform: &v1.Platform{OS: "unknown", Architecture: "unknown"},
+			Annotations: map[string]string{
+				"vnd.docker.reference.type":   "attestation-manifest",
+				"vnd.docker.reference.digest": imgDigest.String(),
+			},
+		}},
+	)
+
+	out, err := imageutil.RecompressIndex(ii, imageutil.CompressionOptions{Compression: compression.ZStd})
+	if err != nil {
+		t.Fatalf("unable to recompress: %v", err)
+	}
+	index, err := out.IndexManifest()
+	if err != nil {
+		t.Fatalf("unable to read index manifest: %v", err)
+	}
+	if len(index.Manifests) != 2 {
+		t.Fatalf("expected 2 manifests, got %d", len(index.Manifests))
+	}
+	newImg, err := out.Image(index.Manifests[0].Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := newImg.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range manifest.Layers {
+		if l.MediaType != types.OCILayerZStd {
+			t.Errorf("layer %s not recompressed: %s", l.Digest, l.MediaType)
+		}
+	}
+	desc := index.Manifests[1]
+	if desc.Digest != attestationDigest {
+		t.Errorf("attestation changed, actual %s expected %s", desc.Digest, attestationDigest)
+	}
+	if ref := desc.Annotations["vnd.docker.reference.digest"]; ref != index.Manifests[0].Digest.String() {
+		t.Errorf("attestation refers to %s, not the recompressed image %s", ref, index.Manifests[0].Digest)
+	}
+	if desc.Annotations["vnd.docker.reference.type"] != "attestation-manifest" {
+		t.Errorf("attestation annotations not kept: %v", desc.Annotations)
+	}
+}
+
+func diffIDs(t *testing.T, img v1.Image) []v1.Hash {
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		t.Fatalf("unable to read config: %v", err)
+	}
+	return cfg.RootFS.DiffIDs
+}
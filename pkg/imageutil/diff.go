@@ -0,0 +1,166 @@
+package imageutil
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sort"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+const (
+	ChangeAdded    = "added"
+	ChangeRemoved  = "removed"
+	ChangeModified = "modified"
+
+	// what can differ between the two versions of a modified path
+	FieldType    = "type"
+	FieldContent = "content"
+	FieldMode    = "mode"
+	FieldOwner   = "owner"
+	FieldLink    = "link"
+)
+
+// FileChange a path that is different between two filesystems
+type FileChange struct {
+	Path   string `json:"path"`
+	Change string `json:"change"`
+	// Fields for a modified path, what is different
+	Fields []string `json:"fields,omitempty"`
+}
+
+// Diff compare the merged filesystems a and b, getting every path added, removed or modified going from a to b,
+// sorted by path. Paths are compared by type, content, permissions, owner and link target, but not by times, which
+// change with every build. The content of regular files is read only when they are the same size in both.
+func Diff(a, b *Index) ([]FileChange, error) {
+	hdrsA, hdrsB := headers(a), headers(b)
+	candidates := map[string]bool{}
+	for name, hdrA := range hdrsA {
+		if hdrB, ok := hdrsB[name]; ok && isRegular(hdrA) && isRegular(hdrB) && hdrA.Size == hdrB.Size {
+			candidates[name] = true
+		}
+	}
+	hashesA, err := a.hashes(candidates)
+	if err != nil {
+		return nil, err
+	}
+	hashesB, err := b.hashes(candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []FileChange
+	for name, hdrA := range hdrsA {
+		hdrB, ok := hdrsB[name]
+		if !ok {
+			changes = append(changes, FileChange{Path: name, Change: ChangeRemoved})
+			continue
+		}
+		var fields []string
+		switch {
+		case isRegular(hdrA) != isRegular(hdrB) || (!isRegular(hdrA) && hdrA.Typeflag != hdrB.Typeflag):
+			fields = append(fields, FieldType)
+		case isRegular(hdrA) && (hdrA.Size != hdrB.Size || hashesA[name] != hashesB[name]),
+			hdrA.Devmajor != hdrB.Devmajor || hdrA.Devminor != hdrB.Devminor:
+			fields = append(fields, FieldContent)
+		}
+		if hdrA.Mode&07777 != hdrB.Mode&07777 {
+			fields = append(fields, FieldMode)
+		}
+		if hdrA.Uid != hdrB.Uid || hdrA.Gid != hdrB.Gid {
+			fields = append(fields, FieldOwner)
+		}
+		if hdrA.Linkname != hdrB.Linkname {
+			fields = append(fields, FieldLink)
+		}
+		if len(fields) > 0 {
+			changes = append(changes, FileChange{Path: name, Change: ChangeModified, Fields: fields})
+		}
+	}
+	for name := range hdrsB {
+		if _, ok := hdrsA[name]; !ok {
+			changes = append(changes, FileChange{Path: name, Change: ChangeAdded})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// headers the header of every path in idx
+func headers(idx *Index) map[string]*tar.Header {
+	hdrs := map[string]*tar.Header{}
+	idx.Walk(func(hdr *tar.Header) error {
+		hdrs[hdr.Name] = hdr
+		return nil
+	})
+	return hdrs
+}
+
+func isRegular(hdr *tar.Header) bool {
+	return hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA
+}
+
+// hashes the sha256 of the content of each of the regular files names in idx, reading the layers once
+func (idx *Index) hashes(names map[string]bool) (map[string]string, error) {
+	hashes := map[string]string{}
+	if len(names) == 0 {
+		return hashes, nil
+	}
+	err := idx.Entries(func(hdr *tar.Header, r io.Reader) error {
+		if !names[hdr.Name] || !isRegular(hdr) {
+			return nil
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, r); err != nil {
+			return err
+		}
+		hashes[hdr.Name] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	return hashes, err
+}
+
+// LayerChange a layer in either or both of two images, by digest
+type LayerChange struct {
+	Digest v1.Hash `json:"digest"`
+	// A the position of the layer in the first image, lowest first, or -1 if it is not in it
+	A int `json:"a"`
+	// B the position of the layer in the second image, lowest first, or -1 if it is not in it
+	B int `json:"b"`
+}
+
+// Shared whether the layer is in both images
+func (l LayerChange) Shared() bool {
+	return l.A >= 0 && l.B >= 0
+}
+
+// DiffLayers compare the layer digests of two images, a and b, getting every layer of a, in order, followed by
+// the layers only in b, in order
+func DiffLayers(a, b []v1.Hash) []LayerChange {
+	positions := func(hashes []v1.Hash) map[v1.Hash]int {
+		m := map[v1.Hash]int{}
+		for i, h := range hashes {
+			if _, ok := m[h]; !ok {
+				m[h] = i
+			}
+		}
+		return m
+	}
+	inA, inB := positions(a), positions(b)
+	var changes []LayerChange
+	for i, h := range a {
+		j, ok := inB[h]
+		if !ok {
+			j = -1
+		}
+		changes = append(changes, LayerChange{Digest: h, A: i, B: j})
+	}
+	for j, h := range b {
+		if _, ok := inA[h]; !ok {
+			changes = append(changes, LayerChange{Digest: h, A: -1, B: j})
+		}
+	}
+	return changes
+}
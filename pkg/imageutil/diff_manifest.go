@@ -0,0 +1,181 @@
+package imageutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+const (
+	// what can differ between the manifests and configs of two images
+	MetadataMediaType   = "mediaType"
+	MetadataConfig      = "config"
+	MetadataPlatform    = "platform"
+	MetadataCreated     = "created"
+	MetadataLayer       = "layer"
+	MetadataAnnotation  = "annotation"
+	MetadataEnv         = "env"
+	MetadataLabel       = "label"
+	MetadataEntrypoint  = "entrypoint"
+	MetadataCmd         = "cmd"
+	MetadataWorkingDir  = "workdir"
+	MetadataUser        = "user"
+	MetadataExposedPort = "exposedPort"
+)
+
+// MetadataChange something that is different between the manifests or configs of two images
+type MetadataChange struct {
+	Field  string `json:"field"`
+	Change string `json:"change"`
+	// Key for a field with many entries, which one: the layer digest, the annotation, label or env variable name,
+	// or the port
+	Key string `json:"key,omitempty"`
+	// A the value in the first image, if it is there; for a layer, its position
+	A string `json:"a,omitempty"`
+	// B the value in the second image, if it is there; for a layer, its position
+	B string `json:"b,omitempty"`
+}
+
+// DiffMetadata compare the manifests and configs of images a and b, getting every change going from a to b: to the
+// media types, config digest, platform, created time, layers, annotations, env, labels, entrypoint, cmd, working dir,
+// user and exposed ports. Layers are compared by digest, and reported as modified if they are in both but moved.
+func DiffMetadata(a, b v1.Image) ([]MetadataChange, error) {
+	manifestA, err := a.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	manifestB, err := b.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	cfgA, err := a.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	cfgB, err := b.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []MetadataChange
+	scalar := func(field, valueA, valueB string) {
+		if valueA != valueB {
+			changes = append(changes, MetadataChange{Field: field, Change: ChangeModified, A: valueA, B: valueB})
+		}
+	}
+	scalar(MetadataMediaType, string(manifestA.MediaType), string(manifestB.MediaType))
+	scalar(MetadataConfig, manifestA.Config.Digest.String(), manifestB.Config.Digest.String())
+	scalar(MetadataPlatform, configPlatform(cfgA), configPlatform(cfgB))
+	scalar(MetadataCreated, cfgA.Created.UTC().String(), cfgB.Created.UTC().String())
+
+	var digestsA, digestsB []v1.Hash
+	for _, desc := range manifestA.Layers {
+		digestsA = append(digestsA, desc.Digest)
+	}
+	for _, desc := range manifestB.Layers {
+		digestsB = append(digestsB, desc.Digest)
+	}
+	for _, l := range DiffLayers(digestsA, digestsB) {
+		change := MetadataChange{Field: MetadataLayer, Key: l.Digest.String()}
+		switch {
+		case l.Shared() && l.A == l.B:
+			continue
+		case l.Shared():
+			change.Change = ChangeModified
+		case l.A >= 0:
+			change.Change = ChangeRemoved
+		default:
+			change.Change = ChangeAdded
+		}
+		if l.A >= 0 {
+			change.A = fmt.Sprint(l.A)
+		}
+		if l.B >= 0 {
+			change.B = fmt.Sprint(l.B)
+		}
+		changes = append(changes, change)
+	}
+
+	changes = append(changes, diffMap(MetadataAnnotation, manifestA.Annotations, manifestB.Annotations)...)
+	changes = append(changes, diffMap(MetadataEnv, envMap(cfgA.Config.Env), envMap(cfgB.Config.Env))...)
+	changes = append(changes, diffMap(MetadataLabel, cfgA.Config.Labels, cfgB.Config.Labels)...)
+	scalar(MetadataEntrypoint, commandString(cfgA.Config.Entrypoint), commandString(cfgB.Config.Entrypoint))
+	scalar(MetadataCmd, commandString(cfgA.Config.Cmd), commandString(cfgB.Config.Cmd))
+	scalar(MetadataWorkingDir, cfgA.Config.WorkingDir, cfgB.Config.WorkingDir)
+	scalar(MetadataUser, cfgA.Config.User, cfgB.Config.User)
+	changes = append(changes, diffMap(MetadataExposedPort, portMap(cfgA.Config.ExposedPorts), portMap(cfgB.Config.ExposedPorts))...)
+	return changes, nil
+}
+
+// diffMap compare the entries of a and b, sorted by key, as changes to field
+func diffMap(field string, a, b map[string]string) []MetadataChange {
+	keys := map[string]bool{}
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+	var sorted []string
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	var changes []MetadataChange
+	for _, k := range sorted {
+		valueA, inA := a[k]
+		valueB, inB := b[k]
+		switch {
+		case !inA:
+			changes = append(changes, MetadataChange{Field: field, Change: ChangeAdded, Key: k, B: valueB})
+		case !inB:
+			changes = append(changes, MetadataChange{Field: field, Change: ChangeRemoved, Key: k, A: valueA})
+		case valueA != valueB:
+			changes = append(changes, MetadataChange{Field: field, Change: ChangeModified, Key: k, A: valueA, B: valueB})
+		}
+	}
+	return changes
+}
+
+// envMap the variables in env, each KEY=VALUE, by key
+func envMap(env []string) map[string]string {
+	m := map[string]string{}
+	for _, kv := range env {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 1 {
+			parts = append(parts, "")
+		}
+		m[parts[0]] = parts[1]
+	}
+	return m
+}
+
+// portMap the ports in ports, each with an empty value
+func portMap(ports map[string]struct{}) map[string]string {
+	m := map[string]string{}
+	for port := range ports {
+		m[port] = ""
+	}
+	return m
+}
+
+// commandString command as a JSON array, or empty if there is none
+func commandString(command []string) string {
+	if command == nil {
+		return ""
+	}
+	b, _ := json.Marshal(command)
+	return string(b)
+}
+
+// configPlatform the platform of cfg, as os/arch[/variant]
+func configPlatform(cfg *v1.ConfigFile) string {
+	platform := cfg.OS + "/" + cfg.Architecture
+	if cfg.Variant != "" {
+		platform += "/" + cfg.Variant
+	}
+	return platform
+}
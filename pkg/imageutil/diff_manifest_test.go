@@ -0,0 +1,73 @@
+package imageutil_test
+
+import (
+	"testing"
+
+	"github.com/deitch/ocidist/pkg/imageutil"
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestDiffMetadata(t *testing.T) {
+	base, err := random.Image(100, 2)
+	if err != nil {
+		t.Fatalf("unable to create image: %v", err)
+	}
+	cfg, _ := base.ConfigFile()
+	cfg = cfg.DeepCopy()
+	cfg.OS, cfg.Architecture = "linux", "amd64"
+	cfg.Config.Env = []string{"PATH=/bin", "HOME=/root"}
+	cfg.Config.Labels = map[string]string{"a": "b", "c": "d"}
+	cfg.Config.Entrypoint = []string{"/bin/app"}
+	if base, err = mutate.ConfigFile(base, cfg); err != nil {
+		t.Fatalf("unable to set config: %v", err)
+	}
+	a := mutate.Annotations(base, map[string]string{"x": "y"}).(v1.Image)
+
+	extra, err := random.Layer(100, "")
+	if err != nil {
+		t.Fatalf("unable to create layer: %v", err)
+	}
+	b, err := mutate.AppendLayers(base, extra)
+	if err != nil {
+		t.Fatalf("unable to append layer: %v", err)
+	}
+	cfgB, _ := b.ConfigFile()
+	cfgB = cfgB.DeepCopy()
+	cfgB.Config.Env = []string{"PATH=/usr/bin", "HOME=/root", "FOO=bar"}
+	cfgB.Config.Labels = map[string]string{"a": "b"}
+	cfgB.Config.ExposedPorts = map[string]struct{}{"80/tcp": {}}
+	if b, err = mutate.ConfigFile(b, cfgB); err != nil {
+		t.Fatalf("unable to set config: %v", err)
+	}
+
+	changes, err := imageutil.DiffMetadata(a, b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	manifestA, _ := a.Manifest()
+	manifestB, _ := b.Manifest()
+	extraDigest, _ := extra.Digest()
+	expected := []imageutil.MetadataChange{
+		{Field: imageutil.MetadataConfig, Change: imageutil.ChangeModified, A: manifestA.Config.Digest.String(), B: manifestB.Config.Digest.String()},
+		{Field: imageutil.MetadataLayer, Change: imageutil.ChangeAdded, Key: extraDigest.String(), B: "2"},
+		{Field: imageutil.MetadataAnnotation, Change: imageutil.ChangeRemoved, Key: "x", A: "y"},
+		{Field: imageutil.MetadataEnv, Change: imageutil.ChangeAdded, Key: "FOO", B: "bar"},
+		{Field: imageutil.MetadataEnv, Change: imageutil.ChangeModified, Key: "PATH", A: "/bin", B: "/usr/bin"},
+		{Field: imageutil.MetadataLabel, Change: imageutil.ChangeRemoved, Key: "c", A: "d"},
+		{Field: imageutil.MetadataExposedPort, Change: imageutil.ChangeAdded, Key: "80/tcp"},
+	}
+	if diff := cmp.Diff(expected, changes); diff != "" {
+		t.Errorf("mismatched changes (-expected +actual):\n%s", diff)
+	}
+
+	same, err := imageutil.DiffMetadata(a, a)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(same) != 0 {
+		t.Errorf("unexpected changes comparing an image to itself: %v", same)
+	}
+}
@@ -0,0 +1,85 @@
+package imageutil_test
+
+import (
+	"archive/tar"
+	"testing"
+
+	"github.com/deitch/ocidist/pkg/imageutil"
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+func TestDiff(t *testing.T) {
+	base := []testEntry{
+		{name: "etc/", typeflag: tar.TypeDir},
+		{name: "etc/hosts", content: "localhost"},
+		{name: "etc/passwd", content: "root"},
+		{name: "etc/group", content: "root"},
+		{name: "bin/sh", content: "shell"},
+		{name: "bin/ash", typeflag: tar.TypeSymlink, linkname: "sh"},
+	}
+	a, err := imageutil.NewIndex(testOpeners([][]testEntry{base}))
+	if err != nil {
+		t.Fatalf("unable to index a: %v", err)
+	}
+	b, err := imageutil.NewIndex(testOpeners([][]testEntry{
+		base,
+		{
+			// same size, different content
+			{name: "etc/hosts", content: "127.0.0.1"},
+			// different size
+			{name: "etc/passwd", content: "root:x:0:0"},
+			// same content
+			{name: "etc/group", content: "root"},
+			{name: "bin/.wh.sh"},
+			{name: "bin/ash", typeflag: tar.TypeSymlink, linkname: "busybox"},
+			{name: "bin/busybox", content: "busybox"},
+			{name: "usr/", typeflag: tar.TypeDir},
+		},
+	}))
+	if err != nil {
+		t.Fatalf("unable to index b: %v", err)
+	}
+	changes, err := imageutil.Diff(a, b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []imageutil.FileChange{
+		{Path: "bin/ash", Change: imageutil.ChangeModified, Fields: []string{imageutil.FieldLink}},
+		{Path: "bin/busybox", Change: imageutil.ChangeAdded},
+		{Path: "bin/sh", Change: imageutil.ChangeRemoved},
+		{Path: "etc/hosts", Change: imageutil.ChangeModified, Fields: []string{imageutil.FieldContent}},
+		{Path: "etc/passwd", Change: imageutil.ChangeModified, Fields: []string{imageutil.FieldContent}},
+		{Path: "usr", Change: imageutil.ChangeAdded},
+	}
+	if diff := cmp.Diff(expected, changes); diff != "" {
+		t.Errorf("mismatched changes (-expected +actual):\n%s", diff)
+	}
+
+	changes, err = imageutil.Diff(b, b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("expected no changes comparing to itself, got %v", changes)
+	}
+}
+
+func TestDiffLayers(t *testing.T) {
+	h := func(c string) v1.Hash {
+		return v1.Hash{Algorithm: "sha256", Hex: c}
+	}
+	changes := imageutil.DiffLayers([]v1.Hash{h("1"), h("2"), h("3")}, []v1.Hash{h("1"), h("4"), h("3")})
+	expected := []imageutil.LayerChange{
+		{Digest: h("1"), A: 0, B: 0},
+		{Digest: h("2"), A: 1, B: -1},
+		{Digest: h("3"), A: 2, B: 2},
+		{Digest: h("4"), A: -1, B: 1},
+	}
+	if diff := cmp.Diff(expected, changes); diff != "" {
+		t.Errorf("mismatched changes (-expected +actual):\n%s", diff)
+	}
+	if !changes[0].Shared() || changes[1].Shared() || changes[3].Shared() {
+		t.Errorf("mismatched shared layers: %v", changes)
+	}
+}
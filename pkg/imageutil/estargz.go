@@ -0,0 +1,255 @@
+package imageutil
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/containerd/stargz-snapshotter/estargz"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/opencontainers/go-digest"
+)
+
+// estargzLayer a layer converted to eStargz from base. The conversion is done once, the first time anything about
+// the layer is needed, and the result is held in memory.
+type estargzLayer struct {
+	base        v1.Layer
+	mediaType   types.MediaType
+	level       int
+	prioritized []string
+
+	once             sync.Once
+	err              error
+	blob             []byte
+	digest, diffID   v1.Hash
+	tocDigest        string
+	uncompressedSize int64
+}
+
+func (l *estargzLayer) build() error {
+	l.once.Do(func() {
+		l.err = l.convert()
+	})
+	return l.err
+}
+
+func (l *estargzLayer) convert() error {
+	// estargz needs random access to the uncompressed tar, so keep it in a temporary file
+	f, err := os.CreateTemp("", "ocidist-estargz")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	rc, err := l.base.Uncompressed()
+	if err != nil {
+		return err
+	}
+	size, err := io.Copy(f, rc)
+	rc.Close()
+	if err != nil {
+		return err
+	}
+
+	level := l.level
+	if level == 0 {
+		level = gzip.BestCompression
+	}
+	var missing []string
+	opts := []estargz.Option{
+		estargz.WithPrioritizedFiles(l.prioritized),
+		estargz.WithAllowPrioritizeNotFound(&missing),
+		estargz.WithCompression(&gzipCompression{level: level}),
+	}
+	blob, err := estargz.Build(io.NewSectionReader(f, 0, size), opts...)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, blob); err != nil {
+		blob.Close()
+		return err
+	}
+	if err := blob.Close(); err != nil {
+		return err
+	}
+	l.blob = buf.Bytes()
+	if l.digest, _, err = v1.SHA256(bytes.NewReader(l.blob)); err != nil {
+		return err
+	}
+	if l.diffID, err = v1.NewHash(blob.DiffID().String()); err != nil {
+		return err
+	}
+	l.tocDigest = blob.TOCDigest().String()
+
+	zr, err := gzip.NewReader(bytes.NewReader(l.blob))
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	l.uncompressedSize, err = io.Copy(io.Discard, zr)
+	return err
+}
+
+// annotate returns annotations with those for eStargz added, the TOC digest and the uncompressed size
+func (l *estargzLayer) annotate(annotations map[string]string) (map[string]string, error) {
+	if err := l.build(); err != nil {
+		return nil, err
+	}
+	out := map[string]string{}
+	for k, v := range annotations {
+		out[k] = v
+	}
+	out[estargz.TOCJSONDigestAnnotation] = l.tocDigest
+	out[estargz.StoreUncompressedSizeAnnotation] = strconv.FormatInt(l.uncompressedSize, 10)
+	return out, nil
+}
+
+func (l *estargzLayer) Digest() (v1.Hash, error) {
+	return l.digest, l.build()
+}
+
+func (l *estargzLayer) DiffID() (v1.Hash, error) {
+	return l.diffID, l.build()
+}
+
+func (l *estargzLayer) Size() (int64, error) {
+	return int64(len(l.blob)), l.build()
+}
+
+func (l *estargzLayer) Compressed() (io.ReadCloser, error) {
+	if err := l.build(); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(l.blob)), nil
+}
+
+func (l *estargzLayer) Uncompressed() (io.ReadCloser, error) {
+	if err := l.build(); err != nil {
+		return nil, err
+	}
+	return gzip.NewReader(bytes.NewReader(l.blob))
+}
+
+func (l *estargzLayer) MediaType() (types.MediaType, error) {
+	return l.mediaType, nil
+}
+
+// gzipCompression the eStargz gzip compression. It is the same as that of the estargz package, except that it
+// builds the footer itself, as the estargz package relies on compress/gzip writing an empty stream in exactly
+// 51 bytes, which newer versions of Go no longer do.
+type gzipCompression struct {
+	estargz.GzipDecompressor
+	level int
+}
+
+func (gc *gzipCompression) Writer(w io.Writer) (estargz.WriteFlushCloser, error) {
+	return gzip.NewWriterLevel(w, gc.level)
+}
+
+func (gc *gzipCompression) WriteTOCAndFooter(w io.Writer, off int64, toc *estargz.JTOC, diffHash hash.Hash) (digest.Digest, error) {
+	tocJSON, err := json.MarshalIndent(toc, "", "\t")
+	if err != nil {
+		return "", err
+	}
+	gz, err := gzip.NewWriterLevel(w, gc.level)
+	if err != nil {
+		return "", err
+	}
+	gw := io.Writer(gz)
+	if diffHash != nil {
+		gw = io.MultiWriter(gz, diffHash)
+	}
+	tw := tar.NewWriter(gw)
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     estargz.TOCTarName,
+		Size:     int64(len(tocJSON)),
+	}); err != nil {
+		return "", err
+	}
+	if _, err := tw.Write(tocJSON); err != nil {
+		return "", err
+	}
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+	if _, err := w.Write(gzipFooter(off)); err != nil {
+		return "", err
+	}
+	return digest.FromBytes(tocJSON), nil
+}
+
+// gzipFooter the eStargz footer, an empty gzip stream whose extra header field holds the offset of the TOC
+func gzipFooter(tocOffset int64) []byte {
+	subfield := fmt.Sprintf("%016xSTARGZ", tocOffset)
+	extra := make([]byte, 4, 4+len(subfield))
+	extra[0], extra[1] = 'S', 'G'
+	binary.LittleEndian.PutUint16(extra[2:], uint16(len(subfield)))
+	extra = append(extra, subfield...)
+
+	footer := make([]byte, 0, estargz.FooterSize)
+	// magic, deflate, FEXTRA flag, no mtime, no extra flags, unknown OS
+	footer = append(footer, 0x1f, 0x8b, 8, 4, 0, 0, 0, 0, 0, 255)
+	footer = binary.LittleEndian.AppendUint16(footer, uint16(len(extra)))
+	footer = append(footer, extra...)
+	// a single, final, empty stored block
+	footer = append(footer, 1, 0, 0, 0xff, 0xff)
+	// crc32 and size of the empty content
+	footer = append(footer, 0, 0, 0, 0, 0, 0, 0, 0)
+	return footer
+}
+
+// ReadTOC reads the table of contents of the eStargz blob in r, which is size bytes long, returning it along with its digest.
+// Only the footer and the table of contents itself are read from r, so it can be backed by ranged requests.
+func ReadTOC(r io.ReaderAt, size int64) (*estargz.JTOC, v1.Hash, error) {
+	decompressors := []estargz.Decompressor{&estargz.GzipDecompressor{}, &estargz.LegacyGzipDecompressor{}}
+	tail := int64(estargz.FooterSize)
+	if size < tail {
+		tail = size
+	}
+	buf := make([]byte, tail)
+	if _, err := r.ReadAt(buf, size-tail); err != nil && err != io.EOF {
+		return nil, v1.Hash{}, fmt.Errorf("unable to read footer: %v", err)
+	}
+	for _, d := range decompressors {
+		footerSize := d.FooterSize()
+		if footerSize > tail {
+			continue
+		}
+		_, tocOffset, tocSize, err := d.ParseFooter(buf[tail-footerSize:])
+		if err != nil {
+			continue
+		}
+		// the gzip footers only give the start of the table of contents, which runs up to the footer
+		if tocSize <= 0 {
+			tocSize = size - footerSize - tocOffset
+		}
+		if tocOffset < 0 || tocSize <= 0 || tocOffset+tocSize > size {
+			return nil, v1.Hash{}, fmt.Errorf("invalid table of contents location %d+%d in blob of size %d", tocOffset, tocSize, size)
+		}
+		tocBytes := make([]byte, tocSize)
+		if _, err := r.ReadAt(tocBytes, tocOffset); err != nil && err != io.EOF {
+			return nil, v1.Hash{}, fmt.Errorf("unable to read table of contents: %v", err)
+		}
+		toc, dgst, err := d.ParseTOC(bytes.NewReader(tocBytes))
+		if err != nil {
+			return nil, v1.Hash{}, fmt.Errorf("unable to parse table of contents: %v", err)
+		}
+		h, err := v1.NewHash(dgst.String())
+		return toc, h, err
+	}
+	return nil, v1.Hash{}, fmt.Errorf("not an eStargz blob, no footer found")
+}
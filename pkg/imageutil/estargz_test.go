@@ -0,0 +1,129 @@
+package imageutil_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/containerd/stargz-snapshotter/estargz"
+	"github.com/deitch/ocidist/pkg/imageutil"
+	"github.com/google/go-containerregistry/pkg/compression"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
+)
+
+// countingReaderAt counts the reads made through it
+type countingReaderAt struct {
+	r     io.ReaderAt
+	reads int
+	bytes int
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	c.reads++
+	n, err := c.r.ReadAt(p, off)
+	c.bytes += n
+	return n, err
+}
+
+func TestEstargz(t *testing.T) {
+	files := []string{"etc/passwd", "bin/sh", "usr/lib/big"}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, d := range []string{"etc/", "bin/", "usr/", "usr/lib/"} {
+		tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: d, Mode: 0755})
+	}
+	for _, f := range files {
+		content := bytes.Repeat([]byte(f), 4096)
+		tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: f, Size: int64(len(content)), Mode: 0644})
+		tw.Write(content)
+	}
+	tw.Close()
+	layer, err := tarball.LayerFromReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("unable to create layer: %v", err)
+	}
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatalf("unable to create image: %v", err)
+	}
+
+	out, err := imageutil.RecompressImage(img, imageutil.CompressionOptions{
+		Compression:      compression.GZip,
+		Estargz:          true,
+		PrioritizedFiles: []string{"bin/sh", "missing"},
+	})
+	if err != nil {
+		t.Fatalf("unable to convert to estargz: %v", err)
+	}
+	if err := validate.Image(out); err != nil {
+		t.Errorf("invalid image: %v", err)
+	}
+	manifest, err := out.Manifest()
+	if err != nil {
+		t.Fatalf("unable to read manifest: %v", err)
+	}
+	desc := manifest.Layers[0]
+	if desc.MediaType != types.DockerLayer {
+		t.Errorf("mismatched media type, actual %s expected %s", desc.MediaType, types.DockerLayer)
+	}
+	tocDigest := desc.Annotations[estargz.TOCJSONDigestAnnotation]
+	if tocDigest == "" {
+		t.Fatalf("missing TOC digest annotation: %v", desc.Annotations)
+	}
+	if desc.Annotations[estargz.StoreUncompressedSizeAnnotation] == "" {
+		t.Errorf("missing uncompressed size annotation: %v", desc.Annotations)
+	}
+
+	layers, _ := out.Layers()
+	rc, err := layers[0].Compressed()
+	if err != nil {
+		t.Fatalf("unable to read layer: %v", err)
+	}
+	blob, _ := io.ReadAll(rc)
+	rc.Close()
+
+	r := &countingReaderAt{r: bytes.NewReader(blob)}
+	toc, h, err := imageutil.ReadTOC(r, int64(len(blob)))
+	if err != nil {
+		t.Fatalf("unable to read TOC: %v", err)
+	}
+	if h.String() != tocDigest {
+		t.Errorf("mismatched TOC digest, actual %s expected %s", h, tocDigest)
+	}
+	if r.reads != 2 || r.bytes >= len(blob) {
+		t.Errorf("TOC read %d bytes in %d reads from a blob of %d, expected just the footer and TOC", r.bytes, r.reads, len(blob))
+	}
+	// the prioritized file comes first
+	var names []string
+	for _, e := range toc.Entries {
+		if e.Type == "reg" && e.Name != estargz.PrefetchLandmark {
+			names = append(names, e.Name)
+		}
+	}
+	if len(names) != len(files) || names[0] != "bin/sh" {
+		t.Errorf("mismatched TOC files, actual %v expected bin/sh first of %v", names, files)
+	}
+
+	// a layer already in estargz is not converted again
+	again, err := imageutil.RecompressImage(out, imageutil.CompressionOptions{Compression: compression.GZip, Estargz: true})
+	if err != nil {
+		t.Fatalf("unable to recompress: %v", err)
+	}
+	d1, _ := out.Digest()
+	d2, _ := again.Digest()
+	if d1 != d2 {
+		t.Errorf("estargz image was converted again, actual %s expected %s", d2, d1)
+	}
+
+	if _, _, err := imageutil.ReadTOC(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err == nil {
+		t.Errorf("expected error reading TOC of plain tar")
+	}
+	if _, err := imageutil.RecompressImage(img, imageutil.CompressionOptions{Compression: compression.ZStd, Estargz: true}); err == nil {
+		t.Errorf("expected error converting to estargz with zstd")
+	}
+}
@@ -0,0 +1,59 @@
+package imageutil
+
+import (
+	"github.com/google/go-containerregistry/pkg/v1"
+)
+
+// HistoryEntry an entry in the history of an image, along with the layer it created, if any
+type HistoryEntry struct {
+	v1.History
+	// Index the position of the layer, lowest first, or -1 if there is none
+	Index int `json:"index"`
+	// Layer the descriptor of the layer, if there is one
+	Layer *v1.Descriptor `json:"layer,omitempty"`
+}
+
+// ImageHistory the history of an image, aligned with its layers
+type ImageHistory struct {
+	Entries []HistoryEntry `json:"entries"`
+	// Layers the number of layers, and NonEmpty the number of history entries that claim to have created one
+	Layers   int `json:"layers"`
+	NonEmpty int `json:"nonEmptyEntries"`
+}
+
+// Mismatched whether the history does not account for every layer exactly once, in which case the alignment of
+// entries with layers past the point where they diverge is a guess
+func (h ImageHistory) Mismatched() bool {
+	return h.Layers != h.NonEmpty
+}
+
+// History get the history of img, oldest first, with each entry that is not an empty layer aligned with the next
+// layer, lowest first. Layers left over once the entries run out get entries of their own, with no history, and
+// entries left over once the layers run out get no layer.
+func History(img v1.Image) (*ImageHistory, error) {
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	out := &ImageHistory{Entries: []HistoryEntry{}, Layers: len(manifest.Layers)}
+	next := 0
+	for _, h := range cfg.History {
+		entry := HistoryEntry{History: h, Index: -1}
+		if !h.EmptyLayer {
+			out.NonEmpty++
+			if next < len(manifest.Layers) {
+				entry.Index, entry.Layer = next, &manifest.Layers[next]
+			}
+			next++
+		}
+		out.Entries = append(out.Entries, entry)
+	}
+	for ; next < len(manifest.Layers); next++ {
+		out.Entries = append(out.Entries, HistoryEntry{Index: next, Layer: &manifest.Layers[next]})
+	}
+	return out, nil
+}
@@ -0,0 +1,61 @@
+package imageutil_test
+
+import (
+	"testing"
+
+	"github.com/deitch/ocidist/pkg/imageutil"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestHistory(t *testing.T) {
+	img, err := random.Image(100, 2)
+	if err != nil {
+		t.Fatalf("unable to create image: %v", err)
+	}
+	manifest, _ := img.Manifest()
+	tests := []struct {
+		name       string
+		history    []v1.History
+		indexes    []int
+		mismatched bool
+	}{
+		{"aligned", []v1.History{{CreatedBy: "ADD"}, {CreatedBy: "ENV", EmptyLayer: true}, {CreatedBy: "RUN"}}, []int{0, -1, 1}, false},
+		{"no history", nil, []int{0, 1}, true},
+		{"too few", []v1.History{{CreatedBy: "ENV", EmptyLayer: true}, {CreatedBy: "ADD"}}, []int{-1, 0, 1}, true},
+		{"too many", []v1.History{{CreatedBy: "ADD"}, {CreatedBy: "RUN"}, {CreatedBy: "COPY"}}, []int{0, 1, -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, _ := img.ConfigFile()
+			cfg = cfg.DeepCopy()
+			cfg.History = tt.history
+			withHistory, err := mutate.ConfigFile(img, cfg)
+			if err != nil {
+				t.Fatalf("unable to set config: %v", err)
+			}
+			h, err := imageutil.History(withHistory)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if h.Mismatched() != tt.mismatched {
+				t.Errorf("mismatched, actual %v expected %v", h.Mismatched(), tt.mismatched)
+			}
+			if len(h.Entries) != len(tt.indexes) {
+				t.Fatalf("mismatched entries, actual %d expected %d", len(h.Entries), len(tt.indexes))
+			}
+			for i, e := range h.Entries {
+				if e.Index != tt.indexes[i] {
+					t.Errorf("%d: mismatched index, actual %d expected %d", i, e.Index, tt.indexes[i])
+				}
+				switch {
+				case e.Index < 0 && e.Layer != nil:
+					t.Errorf("%d: unexpected layer %v", i, e.Layer)
+				case e.Index >= 0 && (e.Layer == nil || e.Layer.Digest != manifest.Layers[e.Index].Digest):
+					t.Errorf("%d: mismatched layer %v", i, e.Layer)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package imageutil
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// Inspection a summary of an index or an image. For an index, Manifests has each of its children; for an image,
+// Image has the summary of it.
+type Inspection struct {
+	Digest      v1.Hash           `json:"digest"`
+	MediaType   types.MediaType   `json:"mediaType"`
+	Size        int64             `json:"size"`
+	Platform    *v1.Platform      `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Manifests   []Inspection      `json:"manifests,omitempty"`
+	Image       *ImageSummary     `json:"image,omitempty"`
+}
+
+// ImageSummary what is in an image, from its manifest and config
+type ImageSummary struct {
+	Created      time.Time         `json:"created,omitempty"`
+	OS           string            `json:"os,omitempty"`
+	Architecture string            `json:"architecture,omitempty"`
+	Variant      string            `json:"variant,omitempty"`
+	Entrypoint   []string          `json:"entrypoint,omitempty"`
+	Cmd          []string          `json:"cmd,omitempty"`
+	WorkingDir   string            `json:"workingDir,omitempty"`
+	User         string            `json:"user,omitempty"`
+	Env          []string          `json:"env,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	ExposedPorts []string          `json:"exposedPorts,omitempty"`
+	Layers       []LayerSummary    `json:"layers"`
+	// Size the total of the compressed sizes of the layers
+	Size int64 `json:"size"`
+	// UncompressedSize the total of the uncompressed sizes of the layers, if they were counted
+	UncompressedSize int64        `json:"uncompressedSize,omitempty"`
+	History          []v1.History `json:"history,omitempty"`
+}
+
+// LayerSummary a layer of an image
+type LayerSummary struct {
+	Digest    v1.Hash         `json:"digest"`
+	DiffID    v1.Hash         `json:"diffID"`
+	MediaType types.MediaType `json:"mediaType"`
+	Size      int64           `json:"size"`
+	// UncompressedSize the size of the tar stream, if it was counted
+	UncompressedSize int64 `json:"uncompressedSize,omitempty"`
+}
+
+// Inspect summarize add, which must be an image or an index, recursing into the children of an index. If
+// uncompressed is set, the uncompressed size of each layer is counted, which means reading every compressed layer.
+func Inspect(add mutate.Appendable, uncompressed bool) (*Inspection, error) {
+	switch a := add.(type) {
+	case v1.ImageIndex:
+		return inspectIndex(a, uncompressed)
+	case v1.Image:
+		return inspectImage(a, uncompressed)
+	}
+	return nil, fmt.Errorf("neither an image nor an index")
+}
+
+func inspectIndex(ii v1.ImageIndex, uncompressed bool) (*Inspection, error) {
+	index, err := ii.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	digest, err := ii.Digest()
+	if err != nil {
+		return nil, err
+	}
+	size, err := ii.Size()
+	if err != nil {
+		return nil, err
+	}
+	out := &Inspection{Digest: digest, MediaType: index.MediaType, Size: size, Annotations: index.Annotations}
+	for _, desc := range index.Manifests {
+		var child *Inspection
+		switch desc.MediaType {
+		case types.OCIImageIndex, types.DockerManifestList:
+			childIndex, err := ii.ImageIndex(desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+			if child, err = inspectIndex(childIndex, uncompressed); err != nil {
+				return nil, err
+			}
+		case types.OCIManifestSchema1, types.DockerManifestSchema2:
+			img, err := ii.Image(desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+			if child, err = inspectImage(img, uncompressed); err != nil {
+				return nil, fmt.Errorf("unable to inspect %s: %v", desc.Digest, err)
+			}
+		default:
+			// something other than an image, e.g. an artifact, of which all we know is its descriptor
+			child = &Inspection{Digest: desc.Digest, MediaType: desc.MediaType, Size: desc.Size}
+		}
+		child.Platform = desc.Platform
+		child.Annotations = desc.Annotations
+		out.Manifests = append(out.Manifests, *child)
+	}
+	return out, nil
+}
+
+func inspectImage(img v1.Image, uncompressed bool) (*Inspection, error) {
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return nil, err
+	}
+	size, err := img.Size()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	summary := &ImageSummary{
+		Created:      cfg.Created.Time,
+		OS:           cfg.OS,
+		Architecture: cfg.Architecture,
+		Variant:      cfg.Variant,
+		Entrypoint:   cfg.Config.Entrypoint,
+		Cmd:          cfg.Config.Cmd,
+		WorkingDir:   cfg.Config.WorkingDir,
+		User:         cfg.Config.User,
+		Env:          cfg.Config.Env,
+		Labels:       cfg.Config.Labels,
+		History:      cfg.History,
+		Layers:       []LayerSummary{},
+	}
+	for port := range cfg.Config.ExposedPorts {
+		summary.ExposedPorts = append(summary.ExposedPorts, port)
+	}
+	sort.Strings(summary.ExposedPorts)
+
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+	for i, desc := range manifest.Layers {
+		ls := LayerSummary{Digest: desc.Digest, MediaType: desc.MediaType, Size: desc.Size}
+		if i < len(cfg.RootFS.DiffIDs) {
+			ls.DiffID = cfg.RootFS.DiffIDs[i]
+		}
+		if uncompressed {
+			if ls.UncompressedSize, err = uncompressedSize(layers[i], desc); err != nil {
+				return nil, fmt.Errorf("unable to read layer %s: %v", desc.Digest, err)
+			}
+		}
+		summary.Size += ls.Size
+		summary.UncompressedSize += ls.UncompressedSize
+		summary.Layers = append(summary.Layers, ls)
+	}
+	return &Inspection{
+		Digest:      digest,
+		MediaType:   manifest.MediaType,
+		Size:        size,
+		Annotations: manifest.Annotations,
+		Image:       summary,
+	}, nil
+}
+
+// uncompressedSize the size of the tar stream of layer, with descriptor desc, reading it only if it is compressed
+func uncompressedSize(layer v1.Layer, desc v1.Descriptor) (int64, error) {
+	switch desc.MediaType {
+	case types.OCIUncompressedLayer, types.DockerUncompressedLayer, types.OCIUncompressedRestrictedLayer:
+		return desc.Size, nil
+	}
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	return io.Copy(io.Discard, rc)
+}
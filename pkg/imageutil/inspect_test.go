@@ -0,0 +1,78 @@
+package imageutil_test
+
+import (
+	"testing"
+
+	"github.com/deitch/ocidist/pkg/imageutil"
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestInspect(t *testing.T) {
+	img, err := random.Image(100, 2)
+	if err != nil {
+		t.Fatalf("unable to create image: %v", err)
+	}
+	cfg, _ := img.ConfigFile()
+	cfg = cfg.DeepCopy()
+	cfg.OS, cfg.Architecture = "linux", "arm64"
+	cfg.Config.Entrypoint = []string{"/bin/app"}
+	cfg.Config.ExposedPorts = map[string]struct{}{"443/tcp": {}, "80/tcp": {}}
+	cfg.Config.Labels = map[string]string{"a": "b"}
+	if img, err = mutate.ConfigFile(img, cfg); err != nil {
+		t.Fatalf("unable to set config: %v", err)
+	}
+	platform := &v1.Platform{OS: "linux", Architecture: "arm64"}
+	ii := mutate.AppendManifests(empty.Index, mutate.IndexAddendum{Add: img, Descriptor: v1.Descriptor{Platform: platform}})
+
+	for _, uncompressed := range []bool{false, true} {
+		in, err := imageutil.Inspect(ii, uncompressed)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		digest, _ := ii.Digest()
+		if in.Digest != digest || len(in.Manifests) != 1 || in.Image != nil {
+			t.Fatalf("mismatched index inspection: %+v", in)
+		}
+		child := in.Manifests[0]
+		imgDigest, _ := img.Digest()
+		if child.Digest != imgDigest || !child.Platform.Equals(*platform) || child.Image == nil {
+			t.Fatalf("mismatched image inspection: %+v", child)
+		}
+		summary := child.Image
+		if diff := cmp.Diff([]string{"443/tcp", "80/tcp"}, summary.ExposedPorts); diff != "" {
+			t.Errorf("mismatched ports (-expected +actual):\n%s", diff)
+		}
+		if diff := cmp.Diff([]string{"/bin/app"}, summary.Entrypoint); diff != "" {
+			t.Errorf("mismatched entrypoint (-expected +actual):\n%s", diff)
+		}
+		if summary.Architecture != "arm64" || summary.Labels["a"] != "b" {
+			t.Errorf("mismatched config: %+v", summary)
+		}
+		layers, _ := img.Layers()
+		if len(summary.Layers) != len(layers) {
+			t.Fatalf("mismatched layers, actual %d expected %d", len(summary.Layers), len(layers))
+		}
+		var size int64
+		for i, layer := range layers {
+			ls := summary.Layers[i]
+			layerDigest, _ := layer.Digest()
+			diffID, _ := layer.DiffID()
+			layerSize, _ := layer.Size()
+			if ls.Digest != layerDigest || ls.DiffID != diffID || ls.Size != layerSize {
+				t.Errorf("%d: mismatched layer %+v", i, ls)
+			}
+			// random layers are uncompressed tars of 100 bytes of content, so always bigger than that
+			if uncompressed != (ls.UncompressedSize > 100) {
+				t.Errorf("%d: mismatched uncompressed size %d", i, ls.UncompressedSize)
+			}
+			size += layerSize
+		}
+		if summary.Size != size {
+			t.Errorf("mismatched total size, actual %d expected %d", summary.Size, size)
+		}
+	}
+}
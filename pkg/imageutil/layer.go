@@ -0,0 +1,218 @@
+package imageutil
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/compression"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// TarOptions how to tar up a directory. The zero value keeps everything other than access and change times, and
+// user and group names, as it is on disk.
+type TarOptions struct {
+	// ModTime if not zero, the modification time of every entry, e.g. from SOURCE_DATE_EPOCH
+	ModTime time.Time
+	// NormalizeOwners give every entry the owner UID and group GID
+	NormalizeOwners bool
+	UID, GID        int
+	// Prefix the path in the tar under which to put the content of the directory
+	Prefix string
+	// Whiteouts paths, from the root of the tar rather than Prefix, to delete from the layers below, each of which
+	// becomes a whiteout entry
+	Whiteouts []string
+	// Skipped if set, called with each file that a tar cannot hold, such as a socket, which is left out
+	Skipped func(p string, err error)
+}
+
+// LayerFromPath get a gzip-compressed layer of media type mt from p, which is either a tar file, compressed or
+// not, or a directory, whose content becomes the layer, per WriteDirTar
+func LayerFromPath(p string, mt types.MediaType) (v1.Layer, error) {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	var layer v1.Layer
+	if fi.IsDir() {
+		layer, err = tarball.LayerFromOpener(dirOpener(p, TarOptions{}), tarball.WithMediaType(mt))
+	} else {
+		layer, err = tarball.LayerFromFile(p, tarball.WithMediaType(mt))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &mediaTypeLayer{Layer: layer, mediaType: mt}, nil
+}
+
+// LayerFromDir get an OCI layer of the content of dir, per WriteDirTar with opts, compressed with comp, at level,
+// where 0 is the default for the algorithm. The directory is read just once, into a temporary file, so that the
+// layer is the same each time it is read, even if dir changes in the meantime. The returned cleanup func removes
+// the file.
+func LayerFromDir(dir string, opts TarOptions, comp compression.Compression, level int) (v1.Layer, func(), error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, nil, err
+	}
+	f, err := os.CreateTemp("", "ocidist-layer")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() { os.Remove(f.Name()) }
+	defer f.Close()
+	if err := WriteDirTar(f, dir, opts); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	if err := f.Close(); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	opener := func() (io.ReadCloser, error) {
+		return os.Open(f.Name())
+	}
+
+	mt := layerMediaType(comp, true)
+	if comp == compression.None {
+		base, err := tarball.LayerFromOpener(opener)
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		return &uncompressedLayer{base: base, mediaType: mt}, cleanup, nil
+	}
+	layerOpts := []tarball.LayerOption{
+		tarball.WithCompression(comp),
+		tarball.WithMediaType(mt),
+	}
+	if level != 0 {
+		layerOpts = append(layerOpts, tarball.WithCompressionLevel(level))
+	}
+	layer, err := tarball.LayerFromOpener(opener, layerOpts...)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return &mediaTypeLayer{Layer: layer, mediaType: mt}, cleanup, nil
+}
+
+// dirOpener get a func to open the tar stream of dir, written per WriteDirTar as it is read
+func dirOpener(dir string, opts TarOptions) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(WriteDirTar(pw, dir, opts))
+		}()
+		return pr, nil
+	}
+}
+
+// WriteDirTar writes the content of dir, but not dir itself, to w as a tar stream, with paths relative to dir, in
+// sorted order, so that the same content always gives the same stream. Symlinks are written as they are, and never
+// followed, and files a tar cannot hold, such as sockets, are left out. Metadata is changed per opts; the
+// directories of any prefix come first, and any whiteouts last.
+func WriteDirTar(w io.Writer, dir string, opts TarOptions) error {
+	tw := tar.NewWriter(w)
+	normalize := func(hdr *tar.Header) {
+		hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+		hdr.Uname, hdr.Gname = "", ""
+		if !opts.ModTime.IsZero() {
+			hdr.ModTime = opts.ModTime
+		}
+		if opts.NormalizeOwners {
+			hdr.Uid, hdr.Gid = opts.UID, opts.GID
+		}
+	}
+
+	prefix := CleanPath(opts.Prefix)
+	if prefix != "" {
+		parts := strings.Split(prefix, "/")
+		for i := range parts {
+			hdr := &tar.Header{Name: path.Join(parts[:i+1]...) + "/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: time.Unix(0, 0)}
+			normalize(hdr)
+			if err := tw.WriteHeader(hdr); err != nil {
+				return fmt.Errorf("error writing header for directory %s: %v", hdr.Name, err)
+			}
+		}
+	}
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == dir {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var link string
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			// only file types a tar cannot hold, such as sockets, fail
+			if opts.Skipped != nil {
+				opts.Skipped(p, err)
+			}
+			return nil
+		}
+		hdr.Name = path.Join(prefix, filepath.ToSlash(rel))
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		normalize(hdr)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("error writing header for file %s: %v", hdr.Name, err)
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := io.Copy(tw, f); err != nil {
+			return fmt.Errorf("error writing file %s: %v", hdr.Name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var whiteouts []string
+	for _, p := range opts.Whiteouts {
+		p = CleanPath(p)
+		if p == "" {
+			return fmt.Errorf("cannot delete the root")
+		}
+		whiteouts = append(whiteouts, path.Join(path.Dir(p), whiteoutPrefix+path.Base(p)))
+	}
+	sort.Strings(whiteouts)
+	for _, name := range whiteouts {
+		hdr := &tar.Header{Name: name, Typeflag: tar.TypeReg, ModTime: time.Unix(0, 0)}
+		normalize(hdr)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("error writing whiteout %s: %v", name, err)
+		}
+	}
+	return tw.Close()
+}
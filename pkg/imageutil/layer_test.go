@@ -0,0 +1,159 @@
+package imageutil_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/deitch/ocidist/pkg/imageutil"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/compression"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+func TestWriteDirTar(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bin", "app"), []byte("app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("bin/app", filepath.Join(dir, "app")); err != nil {
+		t.Fatal(err)
+	}
+	epoch := time.Unix(1700000000, 0)
+	opts := imageutil.TarOptions{
+		ModTime:         epoch,
+		NormalizeOwners: true,
+		UID:             1000,
+		GID:             1001,
+		Prefix:          "/opt/app/",
+		Whiteouts:       []string{"/etc/old.conf", "var/cache"},
+	}
+
+	var first bytes.Buffer
+	if err := imageutil.WriteDirTar(&first, dir, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// a different time on disk must not change the stream
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "bin", "app"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	var second bytes.Buffer
+	if err := imageutil.WriteDirTar(&second, dir, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Errorf("tar streams differ")
+	}
+
+	var names []string
+	tr := tar.NewReader(&first)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("tar file header read error: %v", err)
+		}
+		names = append(names, hdr.Name)
+		if !hdr.ModTime.Equal(epoch) {
+			t.Errorf("%s: mismatched mtime %v", hdr.Name, hdr.ModTime)
+		}
+		if hdr.Uid != 1000 || hdr.Gid != 1001 || hdr.Uname != "" || hdr.Gname != "" {
+			t.Errorf("%s: mismatched owner %d:%d %q:%q", hdr.Name, hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname)
+		}
+	}
+	expected := []string{"opt/", "opt/app/", "opt/app/app", "opt/app/bin/", "opt/app/bin/app", "etc/.wh.old.conf", "var/.wh.cache"}
+	if diff := cmp.Diff(expected, names); diff != "" {
+		t.Errorf("mismatched entries (-expected +actual):\n%s", diff)
+	}
+}
+
+func TestLayerFromDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// a socket, which a tar cannot hold
+	l, err := net.Listen("unix", filepath.Join(dir, "sock"))
+	if err != nil {
+		t.Fatalf("unable to create socket: %v", err)
+	}
+	defer l.Close()
+	tests := []struct {
+		comp      compression.Compression
+		mediaType types.MediaType
+	}{
+		{compression.GZip, types.OCILayer},
+		{compression.ZStd, types.OCILayerZStd},
+		{compression.None, types.OCIUncompressedLayer},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.comp), func(t *testing.T) {
+			var skipped []string
+			opts := imageutil.TarOptions{Skipped: func(p string, err error) { skipped = append(skipped, filepath.Base(p)) }}
+			layer, cleanup, err := imageutil.LayerFromDir(dir, opts, tt.comp, 0)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer cleanup()
+			if diff := cmp.Diff([]string{"sock"}, skipped); diff != "" {
+				t.Errorf("mismatched skipped files (-expected +actual):\n%s", diff)
+			}
+			// the layer is what the directory held when it was created
+			later := filepath.Join(dir, "later")
+			if err := os.WriteFile(later, []byte("later"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(later)
+			mt, err := layer.MediaType()
+			if err != nil || mt != tt.mediaType {
+				t.Errorf("mismatched media type, actual %s expected %s, error %v", mt, tt.mediaType, err)
+			}
+			digest, err := layer.Digest()
+			if err != nil {
+				t.Fatalf("unable to get digest: %v", err)
+			}
+			diffID, err := layer.DiffID()
+			if err != nil {
+				t.Fatalf("unable to get diffID: %v", err)
+			}
+			if (digest == diffID) != (tt.comp == compression.None) {
+				t.Errorf("mismatched digest %s and diffID %s", digest, diffID)
+			}
+			compressed, err := layer.Compressed()
+			if err != nil {
+				t.Fatalf("unable to read layer: %v", err)
+			}
+			h := sha256.New()
+			_, err = io.Copy(h, compressed)
+			compressed.Close()
+			if err != nil {
+				t.Fatalf("unable to read layer: %v", err)
+			}
+			if actual := (v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(h.Sum(nil))}); actual != digest {
+				t.Errorf("mismatched digest of content, actual %s expected %s", actual, digest)
+			}
+			rc, err := layer.Uncompressed()
+			if err != nil {
+				t.Fatalf("unable to read layer: %v", err)
+			}
+			defer rc.Close()
+			if diff := cmp.Diff(map[string]string{"a": "0  a"}, tarSummary(t, rc)); diff != "" {
+				t.Errorf("mismatched layer (-expected +actual):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package imageutil
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/compression"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// MutateOptions changes to make to an image. Anything left empty stays as it is.
+type MutateOptions struct {
+	// Append paths to add as layers on top of the image, each a tar file or a directory, per LayerFromPath
+	Append []string
+	// Env variables to set, each KEY=VALUE, replacing any value the image has for KEY
+	Env []string
+	// Entrypoint the new entrypoint; an empty, but not nil, slice clears it
+	Entrypoint []string
+	// Cmd the new command; an empty, but not nil, slice clears it
+	Cmd        []string
+	WorkingDir string
+	User       string
+	// Labels to set in the config, replacing any with the same keys
+	Labels map[string]string
+	// Annotations to set on the manifest, replacing any with the same keys; on an index, they go on the index too
+	Annotations map[string]string
+}
+
+// MutateImage returns img changed per opts. Appended layers are gzip-compressed, with a history entry for each.
+func MutateImage(img v1.Image, opts MutateOptions) (v1.Image, error) {
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.Append) > 0 {
+		mt := layerMediaType(compression.GZip, manifest.MediaType == types.OCIManifestSchema1)
+		var addenda []mutate.Addendum
+		for _, p := range opts.Append {
+			layer, err := LayerFromPath(p, mt)
+			if err != nil {
+				return nil, fmt.Errorf("unable to create layer from %s: %v", p, err)
+			}
+			addenda = append(addenda, mutate.Addendum{
+				Layer:   layer,
+				History: v1.History{CreatedBy: "ocidist mutate --append " + filepath.Base(p)},
+			})
+		}
+		if img, err = mutate.Append(img, addenda...); err != nil {
+			return nil, err
+		}
+	}
+
+	origCfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	cfg := origCfg.DeepCopy()
+	for _, kv := range opts.Env {
+		cfg.Config.Env = setEnv(cfg.Config.Env, kv)
+	}
+	// cleared is nil, rather than empty, which is how it reads back from the serialized config
+	if opts.Entrypoint != nil {
+		cfg.Config.Entrypoint = nil
+		if len(opts.Entrypoint) > 0 {
+			cfg.Config.Entrypoint = opts.Entrypoint
+		}
+	}
+	if opts.Cmd != nil {
+		cfg.Config.Cmd = nil
+		if len(opts.Cmd) > 0 {
+			cfg.Config.Cmd = opts.Cmd
+		}
+	}
+	if opts.WorkingDir != "" {
+		cfg.Config.WorkingDir = opts.WorkingDir
+	}
+	if opts.User != "" {
+		cfg.Config.User = opts.User
+	}
+	if len(opts.Labels) > 0 && cfg.Config.Labels == nil {
+		cfg.Config.Labels = map[string]string{}
+	}
+	for k, v := range opts.Labels {
+		cfg.Config.Labels[k] = v
+	}
+	if img, err = mutate.ConfigFile(img, cfg); err != nil {
+		return nil, err
+	}
+	if len(opts.Annotations) > 0 {
+		img = mutate.Annotations(img, opts.Annotations).(v1.Image)
+	}
+	return img, nil
+}
+
+// setEnv set the variable in kv, which is KEY=VALUE, in env, replacing any existing value
+func setEnv(env []string, kv string) []string {
+	key := strings.SplitN(kv, "=", 2)[0]
+	for i, e := range env {
+		if strings.SplitN(e, "=", 2)[0] == key {
+			out := append([]string{}, env...)
+			out[i] = kv
+			return out
+		}
+	}
+	return append(env[:len(env):len(env)], kv)
+}
+
+// MutateIndex returns ii with every image in it, or in any child index, changed per opts. The descriptors of
+// the children keep their platforms and annotations. Attestations, and any other manifests that are not of images,
+// are left as they are, other than pointing attestations at the new digests of their images.
+func MutateIndex(ii v1.ImageIndex, opts MutateOptions) (v1.ImageIndex, error) {
+	index, err := ii.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		adds []mutate.IndexAddendum
+		// the new digests of the children, by their old ones
+		digests = map[string]string{}
+	)
+	for _, desc := range index.Manifests {
+		var add mutate.Appendable
+		switch desc.MediaType {
+		case types.OCIImageIndex, types.DockerManifestList:
+			child, err := ii.ImageIndex(desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+			if add, err = MutateIndex(child, opts); err != nil {
+				return nil, err
+			}
+		case types.OCIManifestSchema1, types.DockerManifestSchema2:
+			img, err := ii.Image(desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+			manifest, err := img.Manifest()
+			if err != nil {
+				return nil, err
+			}
+			if IsAttestation(desc) || !manifest.Config.MediaType.IsConfig() {
+				add = img
+				break
+			}
+			if add, err = MutateImage(img, opts); err != nil {
+				return nil, fmt.Errorf("unable to mutate %s: %v", desc.Digest, err)
+			}
+		default:
+			// anything else, such as an artifact, is not an image to change
+			img, err := ii.Image(desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+			add = img
+		}
+		newDigest, err := add.Digest()
+		if err != nil {
+			return nil, err
+		}
+		if newDigest != desc.Digest {
+			digests[desc.Digest.String()] = newDigest.String()
+		}
+		adds = append(adds, mutate.IndexAddendum{
+			Add: add,
+			Descriptor: v1.Descriptor{
+				Platform:    desc.Platform,
+				Annotations: desc.Annotations,
+				URLs:        desc.URLs,
+			},
+		})
+	}
+	remapAttestations(adds, digests)
+	out := mutate.AppendManifests(mutate.IndexMediaType(empty.Index, index.MediaType), adds...)
+	annotations := map[string]string{}
+	for k, v := range index.Annotations {
+		annotations[k] = v
+	}
+	for k, v := range opts.Annotations {
+		annotations[k] = v
+	}
+	if len(annotations) > 0 {
+		out = mutate.Annotations(out, annotations).(v1.ImageIndex)
+	}
+	return out, nil
+}
@@ -0,0 +1,204 @@
+package imageutil_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/deitch/ocidist/pkg/imageutil"
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
+)
+
+func TestMutateImage(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "etc", "ssl"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "etc", "ssl", "ca.pem"), []byte("cert"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("ssl/ca.pem", filepath.Join(dir, "etc", "ca.pem")); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := random.Image(100, 1)
+	if err != nil {
+		t.Fatalf("unable to create image: %v", err)
+	}
+	cfg, _ := img.ConfigFile()
+	cfg = cfg.DeepCopy()
+	cfg.Config.Env = []string{"PATH=/bin", "HOME=/root"}
+	cfg.Config.Cmd = []string{"sh"}
+	if img, err = mutate.ConfigFile(img, cfg); err != nil {
+		t.Fatalf("unable to set config: %v", err)
+	}
+
+	out, err := imageutil.MutateImage(img, imageutil.MutateOptions{
+		Append:      []string{dir},
+		Env:         []string{"PATH=/usr/bin:/bin", "FOO=bar"},
+		Entrypoint:  []string{"/bin/app", "--serve"},
+		Cmd:         []string{},
+		WorkingDir:  "/app",
+		Labels:      map[string]string{"a": "b"},
+		Annotations: map[string]string{"c": "d"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := validate.Image(out); err != nil {
+		t.Errorf("invalid image: %v", err)
+	}
+	outCfg, err := out.ConfigFile()
+	if err != nil {
+		t.Fatalf("unable to get config: %v", err)
+	}
+	expected := v1.Config{
+		Env:        []string{"PATH=/usr/bin:/bin", "HOME=/root", "FOO=bar"},
+		Entrypoint: []string{"/bin/app", "--serve"},
+		// cleared
+		Cmd:        nil,
+		WorkingDir: "/app",
+		Labels:     map[string]string{"a": "b"},
+	}
+	if diff := cmp.Diff(expected, outCfg.Config); diff != "" {
+		t.Errorf("mismatched config (-expected +actual):\n%s", diff)
+	}
+	manifest, err := out.Manifest()
+	if err != nil {
+		t.Fatalf("unable to get manifest: %v", err)
+	}
+	if manifest.Annotations["c"] != "d" {
+		t.Errorf("missing annotation: %v", manifest.Annotations)
+	}
+	if len(manifest.Layers) != 2 || manifest.Layers[1].MediaType != types.DockerLayer {
+		t.Fatalf("expected a docker layer appended: %v", manifest.Layers)
+	}
+	if len(outCfg.History) != 2 || outCfg.History[1].CreatedBy != "ocidist mutate --append "+filepath.Base(dir) {
+		t.Errorf("mismatched history: %v", outCfg.History)
+	}
+
+	layers, _ := out.Layers()
+	rc, err := layers[1].Uncompressed()
+	if err != nil {
+		t.Fatalf("unable to read layer: %v", err)
+	}
+	defer rc.Close()
+	summary := tarSummary(t, rc)
+	expectedSummary := map[string]string{"etc": "5  ", "etc/ssl": "5  ", "etc/ssl/ca.pem": "0  cert", "etc/ca.pem": "2 ssl/ca.pem "}
+	if diff := cmp.Diff(expectedSummary, summary); diff != "" {
+		t.Errorf("mismatched layer (-expected +actual):\n%s", diff)
+	}
+}
+
+func TestMutateIndex(t *testing.T) {
+	amd64 := &v1.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := &v1.Platform{OS: "linux", Architecture: "arm64"}
+	var adds []mutate.IndexAddendum
+	for _, platform := range []*v1.Platform{amd64, arm64} {
+		img, err := random.Image(100, 1)
+		if err != nil {
+			t.Fatalf("unable to create image: %v", err)
+		}
+		cfg, _ := img.ConfigFile()
+		cfg = cfg.DeepCopy()
+		cfg.OS, cfg.Architecture = platform.OS, platform.Architecture
+		if img, err = mutate.ConfigFile(img, cfg); err != nil {
+			t.Fatalf("unable to set config: %v", err)
+		}
+		adds = append(adds, mutate.IndexAddendum{Add: img, Descriptor: v1.Descriptor{Platform: platform}})
+	}
+	ii := mutate.AppendManifests(empty.Index, adds...)
+
+	out, err := imageutil.MutateIndex(ii, imageutil.MutateOptions{User: "nobody", Annotations: map[string]string{"c": "d"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := validate.Index(out); err != nil {
+		t.Errorf("invalid index: %v", err)
+	}
+	index, err := out.IndexManifest()
+	if err != nil {
+		t.Fatalf("unable to get index: %v", err)
+	}
+	if index.Annotations["c"] != "d" {
+		t.Errorf("missing index annotation: %v", index.Annotations)
+	}
+	for i, platform := range []*v1.Platform{amd64, arm64} {
+		desc := index.Manifests[i]
+		if !desc.Platform.Equals(*platform) {
+			t.Errorf("%d: mismatched platform, actual %v expected %v", i, desc.Platform, platform)
+		}
+		img, err := out.Image(desc.Digest)
+		if err != nil {
+			t.Fatalf("%d: unable to get image: %v", i, err)
+		}
+		cfg, err := img.ConfigFile()
+		if err != nil {
+			t.Fatalf("%d: unable to get config: %v", i, err)
+		}
+		if cfg.Config.User != "nobody" {
+			t.Errorf("%d: mismatched user %q", i, cfg.Config.User)
+		}
+	}
+}
+
+func TestMutateIndexAttestation(t *testing.T) {
+	img, err := random.Image(100, 1)
+	if err != nil {
+		t.Fatalf("unable to create image: %v", err)
+	}
+	imgDigest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an attestation manifest, as buildkit adds for each image
+	attestation, err := mutate.Append(mutate.MediaType(empty.Image, types.OCIManifestSchema1), mutate.Addendum{
+		Layer: static.NewLayer([]byte(`{"_type":"https://in-toto.io/Statement/v0.1"}`), "application/vnd.in-toto+json"),
+	})
+	if err != nil {
+		t.Fatalf("unable to create attestation: %v", err)
+	}
+	attestationDigest, err := attestation.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ii := mutate.AppendManifests(mutate.IndexMediaType(empty.Index, types.OCIImageIndex),
+		mutate.IndexAddendum{Add: img, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}}},
+		mutate.IndexAddendum{Add: attestation, Descriptor: v1.Descriptor{
+			Platform: &v1.Platform{OS: "unknown", Architecture: "unknown"},
+			Annotations: map[string]string{
+				"vnd.docker.reference.type":   "attestation-manifest",
+				"vnd.docker.reference.digest": imgDigest.String(),
+			},
+		}},
+	)
+
+	out, err := imageutil.MutateIndex(ii, imageutil.MutateOptions{Env: []string{"A=B"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	index, err := out.IndexManifest()
+	if err != nil {
+		t.Fatalf("unable to get index: %v", err)
+	}
+	if len(index.Manifests) != 2 {
+		t.Fatalf("expected 2 manifests, got %d", len(index.Manifests))
+	}
+	if index.Manifests[0].Digest == imgDigest {
+		t.Errorf("image not changed")
+	}
+	desc := index.Manifests[1]
+	if desc.Digest != attestationDigest {
+		t.Errorf("attestation changed, actual %s expected %s", desc.Digest, attestationDigest)
+	}
+	if ref := desc.Annotations["vnd.docker.reference.digest"]; ref != index.Manifests[0].Digest.String() {
+		t.Errorf("attestation refers to %s, not the changed image %s", ref, index.Manifests[0].Digest)
+	}
+}
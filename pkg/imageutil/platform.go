@@ -0,0 +1,21 @@
+package imageutil
+
+import (
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/match"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+)
+
+// ImageForPlatform resolve the index ii to the image in it for platform
+func ImageForPlatform(ii v1.ImageIndex, platform v1.Platform) (v1.Image, error) {
+	images, err := partial.FindImages(ii, match.Platforms(platform))
+	if err != nil {
+		return nil, fmt.Errorf("error finding image for platform %s: %v", platform.String(), err)
+	}
+	if len(images) < 1 {
+		return nil, fmt.Errorf("no image found for platform %s", platform.String())
+	}
+	return images[0], nil
+}
@@ -0,0 +1,21 @@
+package imageutil
+
+import (
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// NewReferrer get an OCI artifact manifest of artifactType, with the layers in adds, that refers to the manifest
+// subject. The artifact type is given as the config media type, which registries report as the artifact type of
+// the referrer.
+func NewReferrer(subject v1.Descriptor, artifactType types.MediaType, adds ...mutate.Addendum) (v1.Image, error) {
+	img, err := mutate.Append(mutate.MediaType(empty.Image, types.OCIManifestSchema1), adds...)
+	if err != nil {
+		return nil, err
+	}
+	// set after appending, which would otherwise drop the subject
+	img = mutate.ConfigMediaType(img, artifactType)
+	return mutate.Subject(img, v1.Descriptor{MediaType: subject.MediaType, Size: subject.Size, Digest: subject.Digest}).(v1.Image), nil
+}
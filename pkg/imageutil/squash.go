@@ -0,0 +1,194 @@
+package imageutil
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/deitch/ocidist/pkg/util"
+	"github.com/google/go-containerregistry/pkg/compression"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// Squash returns img with its last n layers flattened into a single gzip-compressed layer, or all of its layers if
+// n is 0 or more than it has. The config stays as it was, other than the diff IDs, and the history entries of the
+// squashed layers, which are collapsed into one. The layers below keep their descriptors. The new layer is staged in
+// a temporary file, which the returned cleanup func removes once the image has been written.
+func Squash(img v1.Image, n int) (v1.Image, func(), error) {
+	noop := func() {}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, noop, err
+	}
+	origCfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, noop, err
+	}
+	cfg := origCfg.DeepCopy()
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, noop, err
+	}
+	if n <= 0 || n > len(layers) {
+		n = len(layers)
+	}
+	keep := len(layers) - n
+
+	var openers []util.GetReadCloser
+	for _, layer := range layers {
+		openers = append(openers, layer.Uncompressed)
+	}
+	f, err := os.CreateTemp("", "ocidist-squash")
+	if err != nil {
+		return nil, noop, err
+	}
+	cleanup := func() { os.Remove(f.Name()) }
+	err = SquashLayers(f, openers[:keep], openers[keep:])
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		cleanup()
+		return nil, noop, fmt.Errorf("unable to squash layers: %v", err)
+	}
+
+	oci := manifest.MediaType == types.OCIManifestSchema1
+	mt := layerMediaType(compression.GZip, oci)
+	squashed, err := tarball.LayerFromFile(f.Name(), tarball.WithMediaType(mt))
+	if err != nil {
+		cleanup()
+		return nil, noop, err
+	}
+	diffID, err := squashed.DiffID()
+	if err != nil {
+		cleanup()
+		return nil, noop, err
+	}
+
+	var addenda []mutate.Addendum
+	for i, layer := range layers[:keep] {
+		desc := manifest.Layers[i]
+		addenda = append(addenda, mutate.Addendum{
+			// wrapped, so that the manifest has the descriptor as it was, rather than whatever the layer has
+			Layer:       &mediaTypeLayer{Layer: layer, mediaType: desc.MediaType},
+			Annotations: desc.Annotations,
+			URLs:        desc.URLs,
+		})
+	}
+	addenda = append(addenda, mutate.Addendum{Layer: &mediaTypeLayer{Layer: squashed, mediaType: mt}})
+
+	base := mutate.ConfigMediaType(mutate.MediaType(empty.Image, manifest.MediaType), manifest.Config.MediaType)
+	if len(manifest.Annotations) > 0 {
+		base = mutate.Annotations(base, manifest.Annotations).(v1.Image)
+	}
+	out, err := mutate.Append(base, addenda...)
+	if err != nil {
+		cleanup()
+		return nil, noop, err
+	}
+	cfg.RootFS.DiffIDs = append(cfg.RootFS.DiffIDs[:keep:keep], diffID)
+	cfg.History = squashHistory(cfg.History, keep, n)
+	out, err = mutate.ConfigFile(out, cfg)
+	if err != nil {
+		cleanup()
+		return nil, noop, err
+	}
+	return out, cleanup, nil
+}
+
+// squashHistory collapse the history entries from the first of the n squashed layers on, which come after the
+// keep layers below them, into a single entry. Without any history, there is none to collapse.
+func squashHistory(history []v1.History, keep, n int) []v1.History {
+	if len(history) == 0 {
+		return nil
+	}
+	var (
+		out       []v1.History
+		collapsed []v1.History
+		layers    int
+	)
+	for _, h := range history {
+		if len(collapsed) == 0 && (h.EmptyLayer || layers < keep) {
+			if !h.EmptyLayer {
+				layers++
+			}
+			out = append(out, h)
+			continue
+		}
+		collapsed = append(collapsed, h)
+	}
+	squashed := v1.History{Comment: fmt.Sprintf("squashed %d layers", n)}
+	var createdBy []string
+	for _, h := range collapsed {
+		squashed.Created = h.Created
+		if h.Author != "" {
+			squashed.Author = h.Author
+		}
+		if h.CreatedBy != "" {
+			createdBy = append(createdBy, h.CreatedBy)
+		}
+	}
+	squashed.CreatedBy = strings.Join(createdBy, "\n")
+	return append(out, squashed)
+}
+
+// SquashLayers writes to w a single layer that has the same effect on lower as applying all of upper, lowest first.
+// That is upper merged, with whiteouts for anything in lower that upper removes.
+func SquashLayers(w io.Writer, lower, upper []util.GetReadCloser) error {
+	var whiteouts []string
+	if len(lower) > 0 {
+		lowerIdx, err := NewIndex(lower)
+		if err != nil {
+			return err
+		}
+		fullIdx, err := NewIndex(append(lower[:len(lower):len(lower)], upper...))
+		if err != nil {
+			return err
+		}
+		lowerIdx.Walk(func(hdr *tar.Header) error {
+			if _, ok := fullIdx.Lookup(hdr.Name); ok {
+				return nil
+			}
+			// only the highest path that is gone needs a whiteout, as that takes everything under it with it
+			dir, base := path.Split(hdr.Name)
+			dir = strings.TrimSuffix(dir, "/")
+			if dir != "" {
+				if parent, ok := fullIdx.Lookup(dir); !ok || parent.Typeflag != tar.TypeDir {
+					return nil
+				}
+			}
+			whiteouts = append(whiteouts, path.Join(dir, whiteoutPrefix+base))
+			return nil
+		})
+	}
+
+	upperIdx, err := NewIndex(upper)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	if err := upperIdx.Entries(func(hdr *tar.Header, r io.Reader) error {
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("error writing header for file %s: %v", hdr.Name, err)
+		}
+		if _, err := io.Copy(tw, r); err != nil {
+			return fmt.Errorf("error writing file %s: %v", hdr.Name, err)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, name := range whiteouts {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg}); err != nil {
+			return fmt.Errorf("error writing whiteout %s: %v", name, err)
+		}
+	}
+	return tw.Close()
+}
@@ -0,0 +1,124 @@
+package imageutil_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/deitch/ocidist/pkg/imageutil"
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
+)
+
+func TestSquash(t *testing.T) {
+	layers := [][]testEntry{
+		{{name: "a", content: "a"}, {name: "dir/", typeflag: tar.TypeDir}, {name: "dir/b", content: "b"}, {name: "dir/c", content: "c"}, {name: "gone/x", content: "x"}},
+		{{name: "dir/.wh.b"}, {name: "d", content: "d"}},
+		{{name: "e", content: "e"}, {name: ".wh.a"}, {name: ".wh.gone"}, {name: "dir/c", content: "c2"}},
+	}
+	var addenda []mutate.Addendum
+	for i, entries := range layers {
+		b := testLayer(entries)
+		layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(b)), nil
+		})
+		if err != nil {
+			t.Fatalf("unable to create layer: %v", err)
+		}
+		addenda = append(addenda, mutate.Addendum{Layer: layer, History: v1.History{CreatedBy: "step " + string(rune('0'+i))}})
+	}
+	img, err := mutate.Append(empty.Image, addenda...)
+	if err != nil {
+		t.Fatalf("unable to create image: %v", err)
+	}
+	// an entry without a layer at the end, as for ENV or CMD
+	cfg, _ := img.ConfigFile()
+	cfg = cfg.DeepCopy()
+	cfg.Config.Env = []string{"FOO=bar"}
+	cfg.History = append(cfg.History, v1.History{CreatedBy: "ENV FOO=bar", EmptyLayer: true})
+	if img, err = mutate.ConfigFile(img, cfg); err != nil {
+		t.Fatalf("unable to set config: %v", err)
+	}
+	expected := mergedSummary(t, img)
+
+	tests := []struct {
+		n         int
+		layers    int
+		createdBy []string
+	}{
+		{0, 1, []string{"step 0\nstep 1\nstep 2\nENV FOO=bar"}},
+		{2, 2, []string{"step 0", "step 1\nstep 2\nENV FOO=bar"}},
+		{1, 3, []string{"step 0", "step 1", "step 2\nENV FOO=bar"}},
+	}
+	for _, tt := range tests {
+		out, cleanup, err := imageutil.Squash(img, tt.n)
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %v", tt.n, err)
+		}
+		defer cleanup()
+		if err := validate.Image(out); err != nil {
+			t.Errorf("%d: invalid image: %v", tt.n, err)
+		}
+		outLayers, err := out.Layers()
+		if err != nil {
+			t.Fatalf("%d: unable to get layers: %v", tt.n, err)
+		}
+		if len(outLayers) != tt.layers {
+			t.Errorf("%d: mismatched layer count, actual %d expected %d", tt.n, len(outLayers), tt.layers)
+		}
+		if diff := cmp.Diff(expected, mergedSummary(t, out)); diff != "" {
+			t.Errorf("%d: mismatched filesystem (-expected +actual):\n%s", tt.n, diff)
+		}
+		outCfg, err := out.ConfigFile()
+		if err != nil {
+			t.Fatalf("%d: unable to get config: %v", tt.n, err)
+		}
+		if len(outCfg.Config.Env) != 1 || outCfg.Config.Env[0] != "FOO=bar" {
+			t.Errorf("%d: config not kept: %v", tt.n, outCfg.Config.Env)
+		}
+		var createdBy []string
+		for _, h := range outCfg.History {
+			createdBy = append(createdBy, h.CreatedBy)
+		}
+		if diff := cmp.Diff(tt.createdBy, createdBy); diff != "" {
+			t.Errorf("%d: mismatched history (-expected +actual):\n%s", tt.n, diff)
+		}
+	}
+
+	// squashing everything needs no whiteouts
+	out, cleanup, err := imageutil.Squash(img, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer cleanup()
+	outLayers, _ := out.Layers()
+	rc, err := outLayers[0].Uncompressed()
+	if err != nil {
+		t.Fatalf("unable to read layer: %v", err)
+	}
+	defer rc.Close()
+	for name := range tarSummary(t, rc) {
+		if strings.Contains(name, ".wh.") {
+			t.Errorf("unexpected whiteout %s", name)
+		}
+	}
+}
+
+// mergedSummary the tarSummary of the filesystem of img with all of its layers applied
+func mergedSummary(t *testing.T, img v1.Image) map[string]string {
+	openers, err := imageutil.LayerOpeners(img)
+	if err != nil {
+		t.Fatalf("unable to get layers: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := imageutil.ApplyLayers(&buf, openers); err != nil {
+		t.Fatalf("unable to apply layers: %v", err)
+	}
+	return tarSummary(t, &buf)
+}
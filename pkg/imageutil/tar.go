@@ -4,10 +4,13 @@ import (
 	"archive/tar"
 	"fmt"
 	"io"
+	"os"
 	"path"
+	"sort"
 	"strings"
 
 	"github.com/deitch/ocidist/pkg/util"
+	"github.com/google/go-containerregistry/pkg/v1"
 )
 
 const (
@@ -15,103 +18,510 @@ const (
 	whiteoutOpaqueDir = whiteoutPrefix + whiteoutPrefix + ".opq"
 )
 
+// ApplyLayers merges layers, in order from the lowest to the highest, into the single tar stream of the
+// filesystem they produce, written to w. It follows the overlay semantics of OCI layers: each path appears once,
+// in its last version; whiteouts remove what is below them; opaque whiteouts hide everything below in their
+// directory; and a file replacing a directory removes what was in it. Hard links whose target was removed or
+// replaced by a higher layer are written as regular files with the content of their original target.
 func ApplyLayers(w io.Writer, layers []util.GetReadCloser) error {
+	index, err := NewIndex(layers)
+	if err != nil {
+		return err
+	}
+	return index.WriteTar(w)
+}
+
+// LayerOpeners get a func to open the uncompressed content of each layer of img, lowest first, for use with
+// ApplyLayers or NewIndex.
+func LayerOpeners(img v1.Image) ([]util.GetReadCloser, error) {
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+	var openers []util.GetReadCloser
+	for _, layer := range layers {
+		openers = append(openers, layer.Uncompressed)
+	}
+	return openers, nil
+}
+
+// CleanPath normalizes a path in a layer, so that "./a/b", "a/b" and "/a/b" all are "a/b". The root is "".
+func CleanPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// WalkLayer calls fn for every entry in the uncompressed layer tar stream r, with the name and link name of
+// each header normalized with CleanPath. The root directory itself is skipped. The reader passed to fn is valid
+// only until it returns.
+func WalkLayer(r io.Reader, fn func(hdr *tar.Header, r io.Reader) error) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("tar file header read error: %v", err)
+		}
+		hdr.Name = CleanPath(hdr.Name)
+		if hdr.Name == "" {
+			continue
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			hdr.Linkname = CleanPath(hdr.Linkname)
+		}
+		if err := fn(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// entryRef the position of an entry: its layer, and its position in the tar stream of that layer
+type entryRef struct {
+	layer, pos int
+}
+
+// node a path in the merged filesystem
+type node struct {
+	name string
+	// hdr the header of the entry for this path, or nil if it is a directory that only is implied by its children
+	hdr *tar.Header
+	// src where hdr comes from
+	src entryRef
+	// at where the path first appears, declared or implied, which is where it is given by Entries, so that a
+	// directory declared again by a higher layer still comes before what is under it
+	at entryRef
+	// touched the highest layer that added this path or anything under it
+	touched  int
+	children map[string]*node
+	// link for a hard link, the regular file entry of its target at the time the link was added
+	link *entryRef
+	// materialize whether this hard link must be written as a regular file, as its target is gone
+	materialize bool
+}
 
-	// we cannot simply expand it to a directory, since we cannot necessarily do the right permissions
-	// or ownership. We want to preserve those in a single tar file.
-	// Instead, we do a two-pass. In the first pass, we track every entry to build up what file should exist,
-	// adding when we see one, deleting when we see a whiteout. In the second, we actually write the files,
-	// based on the index created in the first pass.
+func (n *node) isDir() bool {
+	return n.hdr == nil || n.hdr.Typeflag == tar.TypeDir
+}
 
-	fileIndex := map[string]bool{}
+// Index the merged filesystem of a list of layers, as it is after applying all of them
+type Index struct {
+	layers []util.GetReadCloser
+	root   *node
+	// links the regular file entry of every hard link target, as it was when the link was added
+	links map[entryRef]*tar.Header
+}
 
-	// first pass, find out each file
+// NewIndex reads layers, lowest first, and indexes the filesystem they produce. Only the headers are kept;
+// content is read again from the layers when needed.
+func NewIndex(layers []util.GetReadCloser) (*Index, error) {
+	idx := &Index{
+		layers: layers,
+		root:   &node{children: map[string]*node{}},
+		links:  map[entryRef]*tar.Header{},
+	}
 	for i, layer := range layers {
 		rc, err := layer()
 		if err != nil {
-			return fmt.Errorf("could not get ReadCloser for layer %d: %v", i, err)
+			return nil, fmt.Errorf("could not get ReadCloser for layer %d: %v", i, err)
 		}
-		tr := tar.NewReader(rc)
-		for {
-			hdr, err := tr.Next()
-			if err == io.EOF {
-				// clear the error, since we do not pass an io.EOF
-				err = nil
-				break // End of archive
-			}
-			if err != nil {
-				// pass the error on
-				return fmt.Errorf("tar file header read error: %v", err)
+		pos := 0
+		err = WalkLayer(rc, func(hdr *tar.Header, _ io.Reader) error {
+			idx.apply(hdr, entryRef{layer: i, pos: pos})
+			pos++
+			return nil
+		})
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("layer %d: %v", i, err)
+		}
+	}
+	// any hard link whose target is not the same entry as when it was linked must carry the content itself
+	idx.walk(idx.root, func(n *node) error {
+		if n.link != nil {
+			target := idx.lookup(n.hdr.Linkname)
+			n.materialize = target == nil || target.hdr == nil || (target.src != *n.link && (target.link == nil || *target.link != *n.link))
+		}
+		return nil
+	})
+	return idx, nil
+}
+
+// apply one entry of a layer to the index
+func (idx *Index) apply(hdr *tar.Header, ref entryRef) {
+	dir, base := path.Split(hdr.Name)
+	dir = strings.TrimSuffix(dir, "/")
+	switch {
+	case base == whiteoutOpaqueDir:
+		// hide everything below this directory from lower layers, but keep what this layer added
+		if parent := idx.lookup(dir); parent != nil && parent.isDir() {
+			prune(parent, ref.layer)
+		}
+	case strings.HasPrefix(base, whiteoutPrefix):
+		// remove the path, and anything under it, from lower layers; what this layer added stays
+		parent := idx.lookup(dir)
+		if parent == nil || !parent.isDir() {
+			return
+		}
+		name := base[len(whiteoutPrefix):]
+		if child, ok := parent.children[name]; ok {
+			if child.touched < ref.layer {
+				delete(parent.children, name)
+			} else {
+				prune(child, ref.layer)
 			}
-			// is it a whiteout file?
-			base := path.Base(hdr.Name)
-			dir := path.Dir(hdr.Name)
-			switch {
-			case base == whiteoutOpaqueDir:
-				// opaque dir means to delete all of the children but keep the item itself
-				removedFull := path.Clean(dir)
-				for k := range fileIndex {
-					if k != removedFull && k != removedFull+"/" && strings.HasPrefix(k, removedFull+"/") {
-						delete(fileIndex, k)
-					}
+		}
+	default:
+		parent := idx.mkdirs(dir, ref)
+		existing, ok := parent.children[base]
+		if ok && hdr.Typeflag == tar.TypeDir && existing.isDir() {
+			// a directory over a directory merges with it, keeping its place
+			existing.hdr, existing.src, existing.touched, existing.link = hdr, ref, ref.layer, nil
+			return
+		}
+		n := &node{name: hdr.Name, hdr: hdr, src: ref, at: ref, touched: ref.layer, children: map[string]*node{}}
+		if hdr.Typeflag == tar.TypeLink {
+			if target := idx.lookup(hdr.Linkname); target != nil && target.hdr != nil {
+				// link to the file itself, not to another link
+				linked := target.src
+				if target.link != nil {
+					linked = *target.link
 				}
-			case strings.HasPrefix(base, whiteoutPrefix):
-				// whiteout means to delete this file/dir and all children
-				removedBase := base[len(whiteoutPrefix):]
-				removedFull := path.Clean(path.Join(dir, removedBase))
-				// need to delete this and every child, in case it is a dir
-				delete(fileIndex, removedFull)
-				// this is inefficient, looping through every time we find a dir; a tree might be more efficient, for later
-				for k := range fileIndex {
-					if strings.HasPrefix(k, removedFull+"/") {
-						delete(fileIndex, k)
-					}
+				n.link = &linked
+				if _, ok := idx.links[linked]; !ok {
+					idx.links[linked] = target.hdr
 				}
-			default:
-				fileIndex[hdr.Name] = true
 			}
 		}
-		if err := rc.Close(); err != nil {
-			return fmt.Errorf("error closing layer %d: %v", i, err)
+		parent.children[base] = n
+	}
+}
+
+// mkdirs get the directory node for dir, creating any missing directories, and replacing any non-directories,
+// on the way, for the entry at ref. Each is marked as touched by its layer.
+func (idx *Index) mkdirs(dir string, ref entryRef) *node {
+	n := idx.root
+	if dir == "" {
+		return n
+	}
+	for _, part := range strings.Split(dir, "/") {
+		child, ok := n.children[part]
+		if !ok || !child.isDir() {
+			child = &node{name: path.Join(n.name, part), at: ref, children: map[string]*node{}}
+			n.children[part] = child
+		}
+		child.touched = ref.layer
+		n = child
+	}
+	return n
+}
+
+// lookup get the node for name, or nil if it does not exist
+func (idx *Index) lookup(name string) *node {
+	n := idx.root
+	if name == "" {
+		return n
+	}
+	for _, part := range strings.Split(name, "/") {
+		child, ok := n.children[part]
+		if !ok {
+			return nil
+		}
+		n = child
+	}
+	return n
+}
+
+// prune remove everything under n that was not touched by layer
+func prune(n *node, layer int) {
+	for name, child := range n.children {
+		if child.touched < layer {
+			delete(n.children, name)
+			continue
+		}
+		prune(child, layer)
+	}
+}
+
+// walk call fn for n and everything under it, parents before their children, in sorted order
+func (idx *Index) walk(n *node, fn func(*node) error) error {
+	if n != idx.root {
+		if err := fn(n); err != nil {
+			return err
+		}
+	}
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := idx.walk(n.children[name], fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// header the header of n as it is in the merged filesystem
+func (idx *Index) header(n *node) *tar.Header {
+	if n.hdr == nil {
+		return &tar.Header{Typeflag: tar.TypeDir, Name: n.name, Mode: 0755}
+	}
+	hdr := *n.hdr
+	if n.materialize {
+		// take on the type of the target, normally a regular file, but hard links to symlinks exist too
+		target := idx.links[*n.link]
+		hdr.Typeflag, hdr.Linkname, hdr.Size = target.Typeflag, target.Linkname, target.Size
+	}
+	return &hdr
+}
+
+// Lookup get the header of name in the merged filesystem. A directory that exists only because of what is
+// in it has a synthesized header.
+func (idx *Index) Lookup(name string) (*tar.Header, bool) {
+	n := idx.lookup(CleanPath(name))
+	if n == nil || n == idx.root {
+		return nil, false
+	}
+	return idx.header(n), true
+}
+
+// Layer get the position of the layer, lowest first, whose entry gives name its place in the merged filesystem.
+// It is -1 for a directory that exists only because of what is in it.
+func (idx *Index) Layer(name string) (int, bool) {
+	n := idx.lookup(CleanPath(name))
+	if n == nil || n == idx.root {
+		return 0, false
+	}
+	if n.hdr == nil {
+		return -1, true
+	}
+	return n.src.layer, true
+}
+
+// Resolve get the path of name in the merged filesystem with every symlink in it followed, including the last
+// element. Symlinks are followed as if the merged filesystem were the root, so ".." never goes above it and
+// absolute targets are taken relative to it.
+func (idx *Index) Resolve(name string) (string, error) {
+	parts := strings.Split(CleanPath(name), "/")
+	resolved := ""
+	for links := 0; len(parts) > 0; {
+		part := parts[0]
+		parts = parts[1:]
+		if part == "" {
+			continue
+		}
+		next := path.Join(resolved, part)
+		n := idx.lookup(next)
+		if n == nil {
+			return "", fmt.Errorf("%s: %w", name, os.ErrNotExist)
+		}
+		hdr := idx.header(n)
+		if hdr.Typeflag != tar.TypeSymlink {
+			resolved = next
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return "", fmt.Errorf("%s: too many levels of symbolic links", name)
+		}
+		link := hdr.Linkname
+		if !path.IsAbs(link) {
+			link = path.Join(resolved, link)
+		}
+		// start again from the root, with the target of the link followed by the rest of the path
+		parts = append(strings.Split(CleanPath(link), "/"), parts...)
+		resolved = ""
+	}
+	return resolved, nil
+}
+
+// Walk calls fn with the header of every path in the merged filesystem, parents before their children,
+// and in sorted order within each directory
+func (idx *Index) Walk(fn func(hdr *tar.Header) error) error {
+	return idx.walk(idx.root, func(n *node) error {
+		return fn(idx.header(n))
+	})
+}
+
+// ReadDir get the headers of what is directly in the directory name, in sorted order
+func (idx *Index) ReadDir(name string) ([]*tar.Header, error) {
+	n := idx.lookup(CleanPath(name))
+	if n == nil {
+		return nil, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+	if !n.isDir() {
+		return nil, fmt.Errorf("%s: not a directory", name)
+	}
+	var hdrs []*tar.Header
+	for _, child := range n.children {
+		hdrs = append(hdrs, idx.header(child))
+	}
+	sort.Slice(hdrs, func(i, j int) bool { return hdrs[i].Name < hdrs[j].Name })
+	return hdrs, nil
+}
+
+// Open get the content of the regular file name in the merged filesystem, following hard links
+func (idx *Index) Open(name string) (io.ReadCloser, error) {
+	n := idx.lookup(CleanPath(name))
+	if n == nil || n == idx.root {
+		return nil, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+	ref := n.src
+	switch {
+	case n.link != nil:
+		ref = *n.link
+	case n.hdr == nil || (n.hdr.Typeflag != tar.TypeReg && n.hdr.Typeflag != tar.TypeRegA):
+		return nil, fmt.Errorf("%s: not a regular file", name)
+	}
+	return idx.openEntry(ref)
+}
+
+// openEntry get the content of the entry at ref, reading its layer up to it
+func (idx *Index) openEntry(ref entryRef) (io.ReadCloser, error) {
+	rc, err := idx.layers[ref.layer]()
+	if err != nil {
+		return nil, fmt.Errorf("could not get ReadCloser for layer %d: %v", ref.layer, err)
+	}
+	tr := tar.NewReader(rc)
+	for pos := 0; ; {
+		hdr, err := tr.Next()
+		if err != nil {
+			rc.Close()
+			return nil, fmt.Errorf("unable to find entry %d in layer %d: %v", ref.pos, ref.layer, err)
+		}
+		if CleanPath(hdr.Name) == "" {
+			continue
+		}
+		if pos == ref.pos {
+			return &readCloser{Reader: tr, Closer: rc}, nil
 		}
+		pos++
 	}
-	// now we need a writer
+}
+
+// readCloser a reader of part of a stream, that closes the whole stream
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// WriteTar writes the merged filesystem as a tar stream to w, in the order given by Entries
+func (idx *Index) WriteTar(w io.Writer) error {
 	tw := tar.NewWriter(w)
-	defer tw.Close()
-	// second pass, write it all out
-	for i, layer := range layers {
+	if err := idx.Entries(func(hdr *tar.Header, r io.Reader) error {
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("error writing header for file %s: %v", hdr.Name, err)
+		}
+		if _, err := io.Copy(tw, r); err != nil {
+			return fmt.Errorf("error writing file %s: %v", hdr.Name, err)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// Entries calls fn with the header and content of every entry of the merged filesystem. Entries are given in the
+// order of the layers they first appear in, and in their order within those layers, so that every directory and
+// hard link target comes before what depends on it. A directory declared again by a higher layer is given where it
+// first appeared, with the header from the highest layer. Directories that only are implied are not given. The
+// reader passed to fn is valid only until it returns.
+func (idx *Index) Entries(fn func(hdr *tar.Header, r io.Reader) error) error {
+	// the nodes to give at each entry, parents first
+	emit := map[entryRef][]*node{}
+	idx.walk(idx.root, func(n *node) error {
+		if n.hdr != nil {
+			emit[n.at] = append(emit[n.at], n)
+		}
+		return nil
+	})
+
+	// hard link targets that must be copied, as the link will carry the content
+	copies := map[entryRef]string{}
+	defer func() {
+		for _, f := range copies {
+			os.Remove(f)
+		}
+	}()
+	needed := map[entryRef]bool{}
+	for _, nodes := range emit {
+		for _, n := range nodes {
+			if n.materialize {
+				needed[*n.link] = true
+			}
+		}
+	}
+
+	for i, layer := range idx.layers {
 		rc, err := layer()
 		if err != nil {
 			return fmt.Errorf("could not get ReadCloser for layer %d: %v", i, err)
 		}
-		tr := tar.NewReader(rc)
-		for {
-			hdr, err := tr.Next()
-			if err == io.EOF {
-				// clear the error, since we do not pass an io.EOF
-				err = nil
-				break // End of archive
-			}
-			if err != nil {
-				// pass the error on
-				return fmt.Errorf("tar file header read error: %v", err)
+		pos := 0
+		err = WalkLayer(rc, func(hdr *tar.Header, r io.Reader) error {
+			ref := entryRef{layer: i, pos: pos}
+			pos++
+			// directories that first appeared here, but were declared again higher up, have no content to read from
+			// here; they are this entry or its parents, so come first
+			var n *node
+			for _, d := range emit[ref] {
+				if d.src == ref {
+					n = d
+					continue
+				}
+				if err := fn(idx.header(d), strings.NewReader("")); err != nil {
+					return err
+				}
 			}
-			// does this file exist?
-			if _, ok := fileIndex[hdr.Name]; !ok {
-				continue
+			if needed[ref] {
+				f, err := copyToTemp(r)
+				if err != nil {
+					return fmt.Errorf("unable to copy hard link target %s: %v", hdr.Name, err)
+				}
+				copies[ref] = f
+				if n != nil {
+					return entryFromFile(fn, idx.header(n), f)
+				}
+				return nil
 			}
-			// if we made it this far, this file was not whited out, just stream the header and content
-			if err := tw.WriteHeader(hdr); err != nil {
-				return fmt.Errorf("error writing header for layer %d file %s: %v", i, hdr.Name, err)
+			if n == nil {
+				return nil
 			}
-			if _, err := io.Copy(tw, tr); err != nil {
-				return fmt.Errorf("error writing file for layer %d file %s: %v", i, hdr.Name, err)
+			if n.materialize {
+				return entryFromFile(fn, idx.header(n), copies[*n.link])
 			}
+			return fn(idx.header(n), r)
+		})
+		if cerr := rc.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("error closing layer %d: %v", i, cerr)
 		}
-		if err := rc.Close(); err != nil {
-			return fmt.Errorf("error closing layer %d: %v", i, err)
+		if err != nil {
+			return fmt.Errorf("layer %d: %v", i, err)
 		}
 	}
-
 	return nil
 }
+
+func copyToTemp(r io.Reader) (string, error) {
+	f, err := os.CreateTemp("", "ocidist-link")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+func entryFromFile(fn func(hdr *tar.Header, r io.Reader) error, hdr *tar.Header, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return fn(hdr, f)
+}
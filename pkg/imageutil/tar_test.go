@@ -7,10 +7,15 @@ import (
 	"io"
 	"io/ioutil"
 	"sort"
+	"strings"
 	"testing"
 
 	"github.com/deitch/ocidist/pkg/imageutil"
 	"github.com/deitch/ocidist/pkg/util"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
 )
 
 func TestMergeLayers(t *testing.T) {
@@ -20,19 +25,31 @@ func TestMergeLayers(t *testing.T) {
 		err error
 	}{
 		// basic mix single files
-		{[][]string{{"/etc/foo"}, {"/a/b"}}, []string{"/a/b", "/etc/foo"}, nil},
+		{[][]string{{"/etc/foo"}, {"/a/b"}}, []string{"a/b", "etc/foo"}, nil},
 		// basic mix multiple files
-		{[][]string{{"/etc/foo", "/a/c"}, {"/a/b"}}, []string{"/a/b", "/etc/foo", "/a/c"}, nil},
+		{[][]string{{"/etc/foo", "/a/c"}, {"/a/b"}}, []string{"a/b", "etc/foo", "a/c"}, nil},
 		// duplicates
-		{[][]string{{"/etc/foo", "/a/c", "/a/b", "/a/d"}, {"/a/b", "/a/.wh.c"}}, []string{"/a/b", "/a/b", "/a/d", "/etc/foo"}, nil},
+		{[][]string{{"/etc/foo", "/a/c", "/a/b", "/a/d"}, {"/a/b", "/a/.wh.c"}}, []string{"a/b", "a/d", "etc/foo"}, nil},
 		// whiteout single file
-		{[][]string{{"/etc/foo", "/a/c"}, {"/a/b", "/a/.wh.c"}}, []string{"/a/b", "/etc/foo"}, nil},
+		{[][]string{{"/etc/foo", "/a/c"}, {"/a/b", "/a/.wh.c"}}, []string{"a/b", "etc/foo"}, nil},
 		// whiteout single file with peers and duplicates
-		{[][]string{{"/etc/foo", "/a/c", "/a/b", "/a/d"}, {"/a/b", "/a/.wh.c"}}, []string{"/a/b", "/a/b", "/a/d", "/etc/foo"}, nil},
+		{[][]string{{"/etc/foo", "/a/c", "/a/b", "/a/d"}, {"/a/b", "/a/.wh.c"}}, []string{"a/b", "a/d", "etc/foo"}, nil},
 		// whiteout directory
-		{[][]string{{"/etc/foo", "/a/c", "/a/b", "/a/d"}, {"/.wh.a"}}, []string{"/etc/foo"}, nil},
+		{[][]string{{"/etc/foo", "/a/c", "/a/b", "/a/d"}, {"/.wh.a"}}, []string{"etc/foo"}, nil},
 		// opaque directory
-		{[][]string{{"/etc/foo", "/a/c", "/a/b", "/a/d"}, {"/a/.wh..wh..opq"}}, []string{"/etc/foo"}, nil},
+		{[][]string{{"/etc/foo", "/a/c", "/a/b", "/a/d"}, {"/a/.wh..wh..opq"}}, []string{"etc/foo"}, nil},
+		// opaque directory keeps what the same layer adds, before or after the marker
+		{[][]string{{"/a/c", "/a/d"}, {"/a/b", "/a/.wh..wh..opq", "/a/e"}}, []string{"a/b", "a/e"}, nil},
+		// different spellings of the same path
+		{[][]string{{"./a/b", "etc/foo"}, {"/a/b", "a/b", "a//b"}}, []string{"a/b", "etc/foo"}, nil},
+		// directory replaced by a file
+		{[][]string{{"a/", "a/b", "a/c/", "a/c/d"}, {"a"}}, []string{"a"}, nil},
+		// file replaced by a directory
+		{[][]string{{"a", "b"}, {"a/", "a/c"}}, []string{"a", "a/c", "b"}, nil},
+		// file replaced by an implied directory
+		{[][]string{{"a", "b"}, {"a/c"}}, []string{"a/c", "b"}, nil},
+		// directory over a directory merges
+		{[][]string{{"a/", "a/b"}, {"a/", "a/c"}}, []string{"a", "a/b", "a/c"}, nil},
 	}
 
 	for i, tt := range tests {
@@ -47,9 +64,12 @@ func TestMergeLayers(t *testing.T) {
 				buf := bytes.NewBuffer(nil)
 				tw := tar.NewWriter(buf)
 				for _, f := range infiles {
-					tw.WriteHeader(&tar.Header{
-						Name: f,
-					})
+					hdr := &tar.Header{Name: f, Typeflag: tar.TypeReg}
+					// a trailing slash marks a directory
+					if strings.HasSuffix(f, "/") {
+						hdr.Typeflag = tar.TypeDir
+					}
+					tw.WriteHeader(hdr)
 				}
 				tw.Close()
 
@@ -113,3 +133,282 @@ func stringSliceEqualIgnoreOrder(a, b []string) bool {
 	}
 	return stringSliceEqual(a1, b1)
 }
+
+// testEntry an entry in a test layer
+type testEntry struct {
+	name     string
+	typeflag byte
+	content  string
+	linkname string
+	// mode of the entry, defaults to 0644
+	mode int64
+}
+
+// testLayer a tar stream of entries
+func testLayer(entries []testEntry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		typeflag := e.typeflag
+		if typeflag == 0 {
+			typeflag = tar.TypeReg
+		}
+		mode := e.mode
+		if mode == 0 {
+			mode = 0644
+		}
+		tw.WriteHeader(&tar.Header{Name: e.name, Typeflag: typeflag, Linkname: e.linkname, Size: int64(len(e.content)), Mode: mode})
+		tw.Write([]byte(e.content))
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+func testOpeners(layers [][]testEntry) []util.GetReadCloser {
+	var rcgs []util.GetReadCloser
+	for _, entries := range layers {
+		b := testLayer(entries)
+		rcgs = append(rcgs, func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(b)), nil
+		})
+	}
+	return rcgs
+}
+
+// tarSummary the name, type, link and content of every entry in a tar stream, with names normalized
+func tarSummary(t *testing.T, r io.Reader) map[string]string {
+	summary := map[string]string{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("tar file header read error: %v", err)
+		}
+		name := imageutil.CleanPath(hdr.Name)
+		if _, ok := summary[name]; ok {
+			t.Errorf("duplicate entry %s", name)
+		}
+		b, _ := io.ReadAll(tr)
+		summary[name] = fmt.Sprintf("%c %s %s", hdr.Typeflag, imageutil.CleanPath(hdr.Linkname), b)
+	}
+	return summary
+}
+
+func TestApplyLayersGolden(t *testing.T) {
+	tests := [][][]testEntry{
+		// overwrites, with different spellings of the same path; mutate.Extract does not handle a leading /, so that
+		// is left to TestMergeLayers
+		{
+			{{name: "etc/", typeflag: tar.TypeDir}, {name: "etc/hosts", content: "one"}, {name: "./etc/passwd", content: "root"}},
+			{{name: "etc//hosts", content: "two"}, {name: "etc/group", content: "wheel"}},
+			{{name: "./etc/hosts", content: "three"}},
+		},
+		// whiteouts of files and directories
+		{
+			{{name: "a/", typeflag: tar.TypeDir}, {name: "a/b", content: "b"}, {name: "a/c", content: "c"}, {name: "d/", typeflag: tar.TypeDir}, {name: "d/e", content: "e"}},
+			{{name: "a/.wh.b"}, {name: ".wh.d"}, {name: "f", content: "f"}},
+		},
+		// directories replaced by files and files by directories
+		{
+			{{name: "a/", typeflag: tar.TypeDir}, {name: "a/b", content: "b"}, {name: "c", content: "c"}},
+			{{name: "a", content: "now a file"}, {name: "c/", typeflag: tar.TypeDir}, {name: "c/d", content: "d"}},
+		},
+		// symlinks and hard links to targets that survive
+		{
+			{{name: "bin/", typeflag: tar.TypeDir}, {name: "bin/busybox", content: "busybox"}, {name: "bin/sh", typeflag: tar.TypeLink, linkname: "bin/busybox"}, {name: "bin/ls", typeflag: tar.TypeSymlink, linkname: "busybox"}},
+			{{name: "bin/cat", typeflag: tar.TypeLink, linkname: "/bin/busybox"}, {name: "bin/.wh.ls"}},
+		},
+	}
+	for i, layers := range tests {
+		var actual bytes.Buffer
+		if err := imageutil.ApplyLayers(&actual, testOpeners(layers)); err != nil {
+			t.Fatalf("%d: unable to apply layers: %v", i, err)
+		}
+
+		img := empty.Image
+		for _, entries := range layers {
+			b := testLayer(entries)
+			layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(b)), nil })
+			if err != nil {
+				t.Fatalf("%d: unable to create layer: %v", i, err)
+			}
+			if img, err = mutate.AppendLayers(img, layer); err != nil {
+				t.Fatalf("%d: unable to append layer: %v", i, err)
+			}
+		}
+		rc := mutate.Extract(img)
+		expected := tarSummary(t, rc)
+		rc.Close()
+
+		if diff := cmp.Diff(expected, tarSummary(t, &actual)); diff != "" {
+			t.Errorf("%d: mismatched merged filesystem (-mutate.Extract +ApplyLayers):\n%s", i, diff)
+		}
+	}
+}
+
+func TestApplyLayersHardLinks(t *testing.T) {
+	layers := [][]testEntry{
+		{
+			{name: "a", content: "original a"}, {name: "b", content: "original b"}, {name: "c", content: "c"},
+			{name: "link-a", typeflag: tar.TypeLink, linkname: "a"},
+			{name: "link-b", typeflag: tar.TypeLink, linkname: "./b"},
+			{name: "link-c", typeflag: tar.TypeLink, linkname: "c"},
+			{name: "link-link-a", typeflag: tar.TypeLink, linkname: "link-a"},
+		},
+		{
+			// a is whited out, b is replaced, c stays
+			{name: ".wh.a"}, {name: "b", content: "new b"},
+		},
+	}
+	var buf bytes.Buffer
+	if err := imageutil.ApplyLayers(&buf, testOpeners(layers)); err != nil {
+		t.Fatalf("unable to apply layers: %v", err)
+	}
+	expected := map[string]string{
+		"b":           "0  new b",
+		"c":           "0  c",
+		"link-a":      "0  original a",
+		"link-b":      "0  original b",
+		"link-c":      "1 c ",
+		"link-link-a": "1 link-a ",
+	}
+	if diff := cmp.Diff(expected, tarSummary(t, &buf)); diff != "" {
+		t.Errorf("mismatched merged filesystem (-expected +actual):\n%s", diff)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	layers := [][]testEntry{
+		{{name: "etc/", typeflag: tar.TypeDir}, {name: "etc/hosts", content: "one"}, {name: "etc/passwd", content: "root"}, {name: "usr/bin/env", content: "env"}},
+		{{name: "etc/hosts", content: "two"}, {name: "etc/.wh.passwd"}, {name: "env", typeflag: tar.TypeLink, linkname: "usr/bin/env"}},
+	}
+	idx, err := imageutil.NewIndex(testOpeners(layers))
+	if err != nil {
+		t.Fatalf("unable to index layers: %v", err)
+	}
+	if _, ok := idx.Lookup("/etc/passwd"); ok {
+		t.Errorf("whited out file still exists")
+	}
+	hdr, ok := idx.Lookup("./usr/bin")
+	if !ok || hdr.Typeflag != tar.TypeDir {
+		t.Errorf("implied directory missing: %v", hdr)
+	}
+	hdrs, err := idx.ReadDir("etc")
+	if err != nil {
+		t.Fatalf("unable to read directory: %v", err)
+	}
+	if len(hdrs) != 1 || hdrs[0].Name != "etc/hosts" {
+		t.Errorf("mismatched directory content: %v", hdrs)
+	}
+	for name, content := range map[string]string{"etc/hosts": "two", "/env": "env", "usr/bin/env": "env"} {
+		rc, err := idx.Open(name)
+		if err != nil {
+			t.Errorf("%s: unable to open: %v", name, err)
+			continue
+		}
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+		if string(b) != content {
+			t.Errorf("%s: mismatched content, actual %q expected %q", name, b, content)
+		}
+	}
+	if _, err := idx.Open("etc"); err == nil {
+		t.Errorf("expected error opening a directory")
+	}
+	for name, layer := range map[string]int{"etc/hosts": 1, "etc": 0, "usr/bin": -1, "usr/bin/env": 0, "env": 1} {
+		if actual, ok := idx.Layer(name); !ok || actual != layer {
+			t.Errorf("%s: mismatched layer, actual %d %v expected %d", name, actual, ok, layer)
+		}
+	}
+	if _, ok := idx.Layer("etc/passwd"); ok {
+		t.Errorf("whited out file has a layer")
+	}
+	var names []string
+	idx.Walk(func(hdr *tar.Header) error {
+		names = append(names, hdr.Name)
+		return nil
+	})
+	expected := []string{"env", "etc", "etc/hosts", "usr", "usr/bin", "usr/bin/env"}
+	if !stringSliceEqual(names, expected) {
+		t.Errorf("mismatched walk, actual %v expected %v", names, expected)
+	}
+}
+
+func TestIndexEntriesOrder(t *testing.T) {
+	layers := [][]testEntry{
+		{{name: "a/", typeflag: tar.TypeDir}, {name: "a/b", content: "b"}, {name: "c/d", content: "d"}},
+		// a declared again, c declared where it only was implied, both with new modes
+		{{name: "e", content: "e"}, {name: "a/", typeflag: tar.TypeDir, mode: 0700}, {name: "c/", typeflag: tar.TypeDir, mode: 0750}},
+	}
+	idx, err := imageutil.NewIndex(testOpeners(layers))
+	if err != nil {
+		t.Fatalf("unable to index layers: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := idx.WriteTar(&buf); err != nil {
+		t.Fatalf("unable to write tar: %v", err)
+	}
+	var actual []string
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("tar file header read error: %v", err)
+		}
+		actual = append(actual, fmt.Sprintf("%s %o", imageutil.CleanPath(hdr.Name), hdr.Mode))
+	}
+	expected := []string{"a 700", "a/b 644", "c 750", "c/d 644", "e 644"}
+	if !stringSliceEqual(actual, expected) {
+		t.Errorf("mismatched entries, actual %v expected %v", actual, expected)
+	}
+}
+
+func TestIndexResolve(t *testing.T) {
+	layers := [][]testEntry{
+		{
+			{name: "usr/lib/os-release", content: "ID=test"},
+			{name: "etc/os-release", typeflag: tar.TypeSymlink, linkname: "../usr/lib/os-release"},
+			{name: "lib", typeflag: tar.TypeSymlink, linkname: "/usr/lib"},
+			{name: "up", typeflag: tar.TypeSymlink, linkname: "../../.."},
+			{name: "loop", typeflag: tar.TypeSymlink, linkname: "loop"},
+			{name: "dangling", typeflag: tar.TypeSymlink, linkname: "nothing"},
+		},
+	}
+	idx, err := imageutil.NewIndex(testOpeners(layers))
+	if err != nil {
+		t.Fatalf("unable to index layers: %v", err)
+	}
+	tests := []struct {
+		name     string
+		expected string
+		err      bool
+	}{
+		{"etc/os-release", "usr/lib/os-release", false},
+		{"/lib/os-release", "usr/lib/os-release", false},
+		{"up/lib", "usr/lib", false},
+		{"up", "", false},
+		{"usr", "usr", false},
+		{"loop", "", true},
+		{"dangling", "", true},
+		{"lib/missing", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved, err := idx.Resolve(tt.name)
+			switch {
+			case tt.err && err == nil:
+				t.Errorf("expected error, got %q", resolved)
+			case !tt.err && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case resolved != tt.expected:
+				t.Errorf("mismatched path, actual %q expected %q", resolved, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package imageutil
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	xattrPrefix = "SCHILY.xattr."
+	// maxSymlinks how many symlinks may be followed resolving a single path, as in Linux
+	maxSymlinks = 40
+)
+
+// UnpackOptions how to unpack a merged filesystem to a directory
+type UnpackOptions struct {
+	// Owners set the owner and group of each file as in the layers, which normally requires running as root.
+	// It also is needed to keep setuid and setgid bits, and to create device files.
+	Owners bool
+	// Xattrs set the extended attributes of each file as in the layers, where the filesystem and permissions allow
+	Xattrs bool
+}
+
+// Unpack writes the merged filesystem into dir, which is created if needed, for use as a rootfs.
+// Nothing is written outside of dir: paths are clean, so cannot use "..", and anything already in dir that is in
+// the way, such as a symlink where the merged filesystem has a directory, is replaced rather than followed.
+// The targets of hard links are resolved following symlinks as if dir were the root, so that neither ".." nor
+// absolute symlinks can escape it.
+func (idx *Index) Unpack(dir string, opts UnpackOptions) error {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return fmt.Errorf("unable to create %s: %v", root, err)
+	}
+
+	// the mode and times of directories are set at the end, as creating their content could need or change them
+	var dirs []*tar.Header
+	var dirPaths []string
+
+	err = idx.Entries(func(hdr *tar.Header, r io.Reader) error {
+		target, err := mkdirsInRoot(root, hdr.Name)
+		if err != nil {
+			return fmt.Errorf("unable to create parent directory of %s: %v", hdr.Name, err)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if fi, err := os.Lstat(target); err == nil && !fi.IsDir() {
+				if err := os.Remove(target); err != nil {
+					return err
+				}
+			}
+			if err := os.Mkdir(target, 0755); err != nil && !os.IsExist(err) {
+				return fmt.Errorf("unable to create directory %s: %v", hdr.Name, err)
+			}
+			dirs = append(dirs, hdr)
+			dirPaths = append(dirPaths, target)
+		case tar.TypeReg, tar.TypeRegA:
+			if err := removeExisting(target); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+			if err != nil {
+				return fmt.Errorf("unable to create %s: %v", hdr.Name, err)
+			}
+			_, err = io.Copy(f, r)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return fmt.Errorf("unable to write %s: %v", hdr.Name, err)
+			}
+		case tar.TypeSymlink:
+			if err := removeExisting(target); err != nil {
+				return err
+			}
+			// the target is written as it is; it is only ever followed within root, by resolveInRoot
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return fmt.Errorf("unable to create symlink %s: %v", hdr.Name, err)
+			}
+		case tar.TypeLink:
+			src, err := resolveInRoot(root, hdr.Linkname)
+			if err != nil {
+				return fmt.Errorf("%s: link target %s: %v", hdr.Name, hdr.Linkname, err)
+			}
+			if err := removeExisting(target); err != nil {
+				return err
+			}
+			if err := os.Link(src, target); err != nil {
+				return fmt.Errorf("unable to create hard link %s: %v", hdr.Name, err)
+			}
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			// devices need root, so only try when asked to preserve ownership, as root would
+			if hdr.Typeflag != tar.TypeFifo && !opts.Owners {
+				return nil
+			}
+			if err := removeExisting(target); err != nil {
+				return err
+			}
+			if err := mknod(target, hdr); err != nil {
+				return fmt.Errorf("unable to create special file %s: %v", hdr.Name, err)
+			}
+		default:
+			return nil
+		}
+		return setMetadata(target, hdr, opts)
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := setMode(dirPaths[i], dirs[i], opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setMetadata set the ownership and extended attributes of target per hdr, and the mode and times of anything
+// other than a directory or symlink
+func setMetadata(target string, hdr *tar.Header, opts UnpackOptions) error {
+	if hdr.Typeflag == tar.TypeLink {
+		// a hard link shares all of this with its target
+		return nil
+	}
+	// ownership first, as changing it clears setuid and setgid
+	if opts.Owners {
+		if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil {
+			return fmt.Errorf("unable to set owner of %s: %v", hdr.Name, err)
+		}
+	}
+	if opts.Xattrs {
+		for k, v := range hdr.PAXRecords {
+			if !strings.HasPrefix(k, xattrPrefix) {
+				continue
+			}
+			if err := setXattr(target, k[len(xattrPrefix):], v); err != nil && !xattrNotPermitted(err) {
+				return fmt.Errorf("unable to set extended attribute %s of %s: %v", k[len(xattrPrefix):], hdr.Name, err)
+			}
+		}
+	}
+	if hdr.Typeflag == tar.TypeSymlink || hdr.Typeflag == tar.TypeDir {
+		return nil
+	}
+	return setMode(target, hdr, opts)
+}
+
+// setMode set the permissions and times of target per hdr
+func setMode(target string, hdr *tar.Header, opts UnpackOptions) error {
+	mode := hdr.FileInfo().Mode() & (os.ModePerm | os.ModeSticky | os.ModeSetuid | os.ModeSetgid)
+	if !opts.Owners {
+		// setuid and setgid files would belong to whoever unpacks them
+		mode &^= os.ModeSetuid | os.ModeSetgid
+	}
+	if err := os.Chmod(target, mode); err != nil {
+		return fmt.Errorf("unable to set mode of %s: %v", hdr.Name, err)
+	}
+	if err := os.Chtimes(target, hdr.AccessTime, hdr.ModTime); err != nil {
+		return fmt.Errorf("unable to set times of %s: %v", hdr.Name, err)
+	}
+	return nil
+}
+
+// removeExisting remove whatever is at target, so that something new can be created there
+func removeExisting(target string) error {
+	fi, err := os.Lstat(target)
+	switch {
+	case os.IsNotExist(err):
+		return nil
+	case err != nil:
+		return err
+	case fi.IsDir():
+		return os.RemoveAll(target)
+	}
+	return os.Remove(target)
+}
+
+// mkdirsInRoot get the path on disk of name, a clean path within root, making sure that every directory leading to
+// it is a directory, and not a symlink to somewhere else
+func mkdirsInRoot(root, name string) (string, error) {
+	parts := strings.Split(name, "/")
+	dir := root
+	for _, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		fi, err := os.Lstat(dir)
+		switch {
+		case err == nil && fi.IsDir():
+			continue
+		case err == nil:
+			if err := os.Remove(dir); err != nil {
+				return "", err
+			}
+		case !os.IsNotExist(err):
+			return "", err
+		}
+		if err := os.Mkdir(dir, 0755); err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(dir, parts[len(parts)-1]), nil
+}
+
+// resolveInRoot get the path on disk of name, a clean path within root. Symlinks in the directories leading to
+// name are followed as if root were the root of the filesystem, so ".." never goes above it and absolute
+// targets are taken relative to it. The last element of name is not followed, as a hard link is to it itself.
+func resolveInRoot(root, name string) (string, error) {
+	parts := strings.Split(name, "/")
+	resolved := ""
+	for links := 0; len(parts) > 1; {
+		part := parts[0]
+		parts = parts[1:]
+		next := path.Join(resolved, part)
+		fi, err := os.Lstat(filepath.Join(root, filepath.FromSlash(next)))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return "", err
+			}
+			resolved = next
+			continue
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return "", fmt.Errorf("too many levels of symbolic links")
+		}
+		link, err := os.Readlink(filepath.Join(root, filepath.FromSlash(next)))
+		if err != nil {
+			return "", err
+		}
+		if !path.IsAbs(link) {
+			link = path.Join(resolved, link)
+		}
+		// start again from the root, with the target of the link followed by the rest of the path
+		if link = CleanPath(link); link != "" {
+			parts = append(strings.Split(link, "/"), parts...)
+		}
+		resolved = ""
+	}
+	return filepath.Join(root, filepath.FromSlash(path.Join(resolved, parts[0]))), nil
+}
@@ -0,0 +1,22 @@
+//go:build !(darwin || linux)
+
+package imageutil
+
+import (
+	"archive/tar"
+	"errors"
+)
+
+var errUnsupported = errors.New("not supported on this platform")
+
+func mknod(target string, hdr *tar.Header) error {
+	return errUnsupported
+}
+
+func setXattr(target, name, value string) error {
+	return errUnsupported
+}
+
+func xattrNotPermitted(err error) bool {
+	return errors.Is(err, errUnsupported)
+}
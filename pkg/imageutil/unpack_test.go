@@ -0,0 +1,171 @@
+package imageutil_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/deitch/ocidist/pkg/imageutil"
+	"github.com/deitch/ocidist/pkg/util"
+)
+
+func headerOpeners(layers [][]*tar.Header) []util.GetReadCloser {
+	var rcgs []util.GetReadCloser
+	for _, hdrs := range layers {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, hdr := range hdrs {
+			var content []byte
+			if hdr.Typeflag == tar.TypeReg {
+				content = []byte("content of " + hdr.Name)
+				hdr.Size = int64(len(content))
+			}
+			tw.WriteHeader(hdr)
+			tw.Write(content)
+		}
+		tw.Close()
+		b := buf.Bytes()
+		rcgs = append(rcgs, func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(b)), nil
+		})
+	}
+	return rcgs
+}
+
+func TestUnpack(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	dir := func(name string, mode int64) *tar.Header {
+		return &tar.Header{Name: name, Typeflag: tar.TypeDir, Mode: mode, ModTime: mtime}
+	}
+	file := func(name string, mode int64) *tar.Header {
+		return &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: mode, ModTime: mtime}
+	}
+	link := func(name string, typeflag byte, target string) *tar.Header {
+		return &tar.Header{Name: name, Typeflag: typeflag, Linkname: target, Mode: 0777, ModTime: mtime}
+	}
+	layers := [][]*tar.Header{
+		{
+			dir("etc/", 0755),
+			file("etc/passwd", 0644),
+			file("etc/removed", 0644),
+			dir("ro/", 0555),
+			file("ro/file", 0444),
+			file("bin/tool", 04755),
+			link("abs", tar.TypeSymlink, "/"),
+			link("up", tar.TypeSymlink, "../../.."),
+			link("loop", tar.TypeSymlink, "loop"),
+		},
+		{
+			file("etc/.wh.removed", 0644),
+			file("etc/shadow", 0600),
+			// these all would be outside of the root if followed as on the host
+			file("../../dotdot", 0644),
+			link("etc/hard", tar.TypeLink, "abs/etc/passwd"),
+			link("etc/hardup", tar.TypeLink, "up/etc/shadow"),
+			link("etc/softlink", tar.TypeSymlink, "/etc/passwd"),
+		},
+	}
+	idx, err := imageutil.NewIndex(headerOpeners(layers))
+	if err != nil {
+		t.Fatalf("unexpected error indexing: %v", err)
+	}
+	parent := t.TempDir()
+	root := filepath.Join(parent, "rootfs")
+	// existing content is replaced, rather than followed
+	if err := os.MkdirAll(filepath.Join(root, "etc", "passwd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(parent, filepath.Join(root, "bin")); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Unpack(root, imageutil.UnpackOptions{}); err != nil {
+		t.Fatalf("unexpected error unpacking: %v", err)
+	}
+
+	for _, name := range []string{"etc/passwd", "etc/shadow", "dotdot", "ro/file", "bin/tool"} {
+		b, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if len(b) == 0 {
+			t.Errorf("%s: empty", name)
+		}
+	}
+	if entries, err := os.ReadDir(parent); err != nil || len(entries) != 1 {
+		t.Errorf("expected only the rootfs in %s, got %v %v", parent, entries, err)
+	}
+	if _, err := os.Lstat(filepath.Join(root, "etc", "removed")); !os.IsNotExist(err) {
+		t.Errorf("whiteout not applied: %v", err)
+	}
+
+	// the hard link was resolved within the root, and shares the file
+	a, err := os.Stat(filepath.Join(root, "etc", "passwd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.Stat(filepath.Join(root, "etc", "hard"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(a, b) {
+		t.Errorf("etc/hard is not a hard link to etc/passwd")
+	}
+	a, err = os.Stat(filepath.Join(root, "etc", "shadow"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = os.Stat(filepath.Join(root, "etc", "hardup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(a, b) {
+		t.Errorf("etc/hardup is not a hard link to etc/shadow")
+	}
+	if target, err := os.Readlink(filepath.Join(root, "etc", "softlink")); err != nil || target != "/etc/passwd" {
+		t.Errorf("etc/softlink: got %q %v", target, err)
+	}
+
+	tests := []struct {
+		name string
+		mode os.FileMode
+	}{
+		{"etc/passwd", 0644},
+		{"etc/shadow", 0600},
+		{"ro", os.ModeDir | 0555},
+		{"ro/file", 0444},
+		// setuid is dropped when not preserving ownership
+		{"bin/tool", 0755},
+		{"bin", os.ModeDir | 0755},
+	}
+	for _, tt := range tests {
+		fi, err := os.Lstat(filepath.Join(root, tt.name))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if fi.Mode() != tt.mode {
+			t.Errorf("%s: mode %v instead of %v", tt.name, fi.Mode(), tt.mode)
+		}
+		if fi.Name() != "bin" && !fi.ModTime().Equal(mtime) {
+			t.Errorf("%s: mtime %v instead of %v", tt.name, fi.ModTime(), mtime)
+		}
+	}
+}
+
+func TestUnpackSymlinkLoop(t *testing.T) {
+	idx, err := imageutil.NewIndex(headerOpeners([][]*tar.Header{
+		{{Name: "loop", Typeflag: tar.TypeSymlink, Linkname: "loop"}},
+		{{Name: "file", Typeflag: tar.TypeLink, Linkname: "loop/file"}},
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error indexing: %v", err)
+	}
+	if err := idx.Unpack(t.TempDir(), imageutil.UnpackOptions{}); err == nil {
+		t.Errorf("expected error unpacking through a symlink loop")
+	}
+}
@@ -0,0 +1,32 @@
+//go:build darwin || linux
+
+package imageutil
+
+import (
+	"archive/tar"
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+func mknod(target string, hdr *tar.Header) error {
+	mode := uint32(hdr.Mode & 07777)
+	switch hdr.Typeflag {
+	case tar.TypeChar:
+		mode |= unix.S_IFCHR
+	case tar.TypeBlock:
+		mode |= unix.S_IFBLK
+	case tar.TypeFifo:
+		mode |= unix.S_IFIFO
+	}
+	return unix.Mknod(target, mode, int(unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))))
+}
+
+func setXattr(target, name, value string) error {
+	return unix.Lsetxattr(target, name, []byte(value), 0)
+}
+
+// xattrNotPermitted whether err means that the attribute cannot be set here, rather than that something failed
+func xattrNotPermitted(err error) bool {
+	return errors.Is(err, unix.EPERM) || errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EACCES)
+}
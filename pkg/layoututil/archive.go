@@ -0,0 +1,345 @@
+package layoututil
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// WriteArchive writes the layout at p to w as a single tar stream, in the oci-archive format used by
+// skopeo, podman and buildkit. Entries are written in a stable order, with oci-layout and index.json first,
+// followed by the blobs.
+func WriteArchive(w io.Writer, p layout.Path) error {
+	tw := tar.NewWriter(w)
+	for _, name := range []string{"oci-layout", "index.json"} {
+		if err := writeArchiveFile(tw, filepath.Join(string(p), name), name); err != nil {
+			return err
+		}
+	}
+
+	blobsDir := filepath.Join(string(p), "blobs")
+	algs, err := sortedDir(blobsDir)
+	if err != nil {
+		return fmt.Errorf("unable to read blobs directory %s: %v", blobsDir, err)
+	}
+	if err := writeArchiveDir(tw, "blobs"); err != nil {
+		return err
+	}
+	for _, alg := range algs {
+		algDir := filepath.Join(blobsDir, alg)
+		if err := writeArchiveDir(tw, path.Join("blobs", alg)); err != nil {
+			return err
+		}
+		blobs, err := sortedDir(algDir)
+		if err != nil {
+			return fmt.Errorf("unable to read blobs directory %s: %v", algDir, err)
+		}
+		for _, blob := range blobs {
+			if err := writeArchiveFile(tw, filepath.Join(algDir, blob), path.Join("blobs", alg, blob)); err != nil {
+				return err
+			}
+		}
+	}
+	return tw.Close()
+}
+
+// WriteArchiveFile writes the layout at p as an oci-archive tar file at target
+func WriteArchiveFile(target string, p layout.Path) error {
+	f, err := os.Create(target)
+	if err != nil {
+		return fmt.Errorf("unable to create archive %s: %v", target, err)
+	}
+	defer f.Close()
+	if err := WriteArchive(f, p); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func sortedDir(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func writeArchiveDir(tw *tar.Writer, name string) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     0755,
+	}); err != nil {
+		return fmt.Errorf("unable to write archive entry %s: %v", name, err)
+	}
+	return nil
+}
+
+func writeArchiveFile(tw *tar.Writer, src, name string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("unable to open %s: %v", src, err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("unable to stat %s: %v", src, err)
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     fi.Size(),
+	}); err != nil {
+		return fmt.Errorf("unable to write archive entry %s: %v", name, err)
+	}
+	if _, err := io.Copy(tw, f); err != nil {
+		return fmt.Errorf("unable to write archive content %s: %v", name, err)
+	}
+	return nil
+}
+
+// WriteIndexArchive writes an oci-archive to w, using ii as its index.json, and including every blob reachable
+// from ii. Content is streamed directly into the tar, without first being written to disk. Each blob is written
+// only once, however often it is referenced.
+func WriteIndexArchive(w io.Writer, ii v1.ImageIndex) error {
+	tw := tar.NewWriter(w)
+	if err := writeArchiveBytes(tw, "oci-layout", []byte(layoutFile)); err != nil {
+		return err
+	}
+	b, err := ii.RawManifest()
+	if err != nil {
+		return err
+	}
+	if err := writeArchiveBytes(tw, "index.json", b); err != nil {
+		return err
+	}
+	aw := &archiveWriter{tw: tw, written: map[v1.Hash]bool{}, dirs: map[string]bool{}}
+	if err := aw.writeChildren(ii); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// archiveWriter tracks what has been written already to an archive
+type archiveWriter struct {
+	tw      *tar.Writer
+	written map[v1.Hash]bool
+	dirs    map[string]bool
+}
+
+func (a *archiveWriter) writeChildren(ii v1.ImageIndex) error {
+	index, err := ii.IndexManifest()
+	if err != nil {
+		return err
+	}
+	for _, desc := range index.Manifests {
+		switch desc.MediaType {
+		case types.OCIImageIndex, types.DockerManifestList:
+			child, err := ii.ImageIndex(desc.Digest)
+			if err != nil {
+				return err
+			}
+			if err := a.writeIndex(child); err != nil {
+				return err
+			}
+		case types.OCIManifestSchema1, types.DockerManifestSchema2:
+			img, err := ii.Image(desc.Digest)
+			if err != nil {
+				return err
+			}
+			if err := a.writeImage(img); err != nil {
+				return err
+			}
+		default:
+			var blob io.ReadCloser
+			switch b := ii.(type) {
+			case withLayer:
+				layer, err := b.Layer(desc.Digest)
+				if err != nil {
+					return err
+				}
+				if blob, err = layer.Compressed(); err != nil {
+					return err
+				}
+			case withBlob:
+				if blob, err = b.Blob(desc.Digest); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("unable to read descriptor %s of media type %s", desc.Digest, desc.MediaType)
+			}
+			if err := a.writeBlob(desc.Digest, desc.Size, blob); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (a *archiveWriter) writeIndex(ii v1.ImageIndex) error {
+	if err := a.writeChildren(ii); err != nil {
+		return err
+	}
+	d, err := ii.Digest()
+	if err != nil {
+		return err
+	}
+	b, err := ii.RawManifest()
+	if err != nil {
+		return err
+	}
+	return a.writeBlob(d, int64(len(b)), io.NopCloser(bytes.NewReader(b)))
+}
+
+func (a *archiveWriter) writeImage(img v1.Image) error {
+	layers, err := img.Layers()
+	if err != nil {
+		return err
+	}
+	for _, layer := range layers {
+		d, err := layer.Digest()
+		if err != nil {
+			return err
+		}
+		size, err := layer.Size()
+		if err != nil {
+			return err
+		}
+		if a.written[d] {
+			continue
+		}
+		rc, err := layer.Compressed()
+		if err != nil {
+			return err
+		}
+		if err := a.writeBlob(d, size, rc); err != nil {
+			return err
+		}
+	}
+	cfgName, err := img.ConfigName()
+	if err != nil {
+		return err
+	}
+	cfg, err := img.RawConfigFile()
+	if err != nil {
+		return err
+	}
+	if err := a.writeBlob(cfgName, int64(len(cfg)), io.NopCloser(bytes.NewReader(cfg))); err != nil {
+		return err
+	}
+	d, err := img.Digest()
+	if err != nil {
+		return err
+	}
+	b, err := img.RawManifest()
+	if err != nil {
+		return err
+	}
+	return a.writeBlob(d, int64(len(b)), io.NopCloser(bytes.NewReader(b)))
+}
+
+func (a *archiveWriter) writeBlob(h v1.Hash, size int64, rc io.ReadCloser) error {
+	defer rc.Close()
+	if a.written[h] {
+		return nil
+	}
+	for _, dir := range []string{"blobs", path.Join("blobs", h.Algorithm)} {
+		if a.dirs[dir] {
+			continue
+		}
+		if err := writeArchiveDir(a.tw, dir); err != nil {
+			return err
+		}
+		a.dirs[dir] = true
+	}
+	name := path.Join("blobs", h.Algorithm, h.Hex)
+	if err := a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+	}); err != nil {
+		return fmt.Errorf("unable to write archive entry %s: %v", name, err)
+	}
+	if _, err := io.Copy(a.tw, rc); err != nil {
+		return fmt.Errorf("unable to write archive content %s: %v", name, err)
+	}
+	a.written[h] = true
+	return nil
+}
+
+// ExtractArchive extracts the oci-archive in r into dir, and returns the layout there. Only regular files
+// and directories are extracted, and any entry that would land outside of dir is rejected.
+func ExtractArchive(r io.Reader, dir string) (layout.Path, error) {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("archive read error: %v", err)
+		}
+		clean := path.Clean(hdr.Name)
+		if clean == "." {
+			continue
+		}
+		if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return "", fmt.Errorf("archive entry %s is outside of the layout", hdr.Name)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(clean))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return "", fmt.Errorf("unable to create directory %s: %v", target, err)
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return "", fmt.Errorf("unable to create directory for %s: %v", target, err)
+			}
+			f, err := os.Create(target)
+			if err != nil {
+				return "", fmt.Errorf("unable to create %s: %v", target, err)
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return "", fmt.Errorf("unable to extract %s: %v", hdr.Name, err)
+			}
+			if err := f.Close(); err != nil {
+				return "", fmt.Errorf("unable to close %s: %v", target, err)
+			}
+		}
+	}
+	return layout.FromPath(dir)
+}
+
+func writeArchiveBytes(tw *tar.Writer, name string, b []byte) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(b)),
+	}); err != nil {
+		return fmt.Errorf("unable to write archive entry %s: %v", name, err)
+	}
+	if _, err := tw.Write(b); err != nil {
+		return fmt.Errorf("unable to write archive content %s: %v", name, err)
+	}
+	return nil
+}
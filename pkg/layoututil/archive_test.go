@@ -0,0 +1,97 @@
+package layoututil_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+
+	"github.com/deitch/ocidist/pkg/layoututil"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestIndexArchiveRoundTrip(t *testing.T) {
+	child, err := random.Index(64, 2, 3)
+	if err != nil {
+		t.Fatalf("unable to create random index: %v", err)
+	}
+	// include the same index twice, to be sure blobs are written only once
+	ii := mutate.AppendManifests(empty.Index, mutate.IndexAddendum{Add: child}, mutate.IndexAddendum{Add: child})
+
+	var buf bytes.Buffer
+	if err := layoututil.WriteIndexArchive(&buf, ii); err != nil {
+		t.Fatalf("unable to write archive: %v", err)
+	}
+
+	// no duplicate entries
+	seen := map[string]bool{}
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		if seen[hdr.Name] {
+			t.Errorf("duplicate archive entry %s", hdr.Name)
+		}
+		seen[hdr.Name] = true
+	}
+
+	p, err := layoututil.ExtractArchive(&buf, t.TempDir())
+	if err != nil {
+		t.Fatalf("unable to extract archive: %v", err)
+	}
+	root, err := p.ImageIndex()
+	if err != nil {
+		t.Fatalf("unable to read extracted index: %v", err)
+	}
+	expected, _ := ii.Digest()
+	actual, err := root.Digest()
+	if err != nil {
+		t.Fatalf("unable to get digest of extracted index: %v", err)
+	}
+	if actual != expected {
+		t.Errorf("mismatched index digest, actual %s expected %s", actual, expected)
+	}
+	childDigest, _ := child.Digest()
+	extracted, err := root.ImageIndex(childDigest)
+	if err != nil {
+		t.Fatalf("unable to read child index: %v", err)
+	}
+	manifest, err := extracted.IndexManifest()
+	if err != nil {
+		t.Fatalf("unable to read child index manifest: %v", err)
+	}
+	for _, desc := range manifest.Manifests {
+		img, err := extracted.Image(desc.Digest)
+		if err != nil {
+			t.Fatalf("unable to read image %s: %v", desc.Digest, err)
+		}
+		layers, err := img.Layers()
+		if err != nil {
+			t.Fatalf("unable to read layers of %s: %v", desc.Digest, err)
+		}
+		for _, layer := range layers {
+			rc, err := layer.Compressed()
+			if err != nil {
+				t.Errorf("unable to read layer of %s: %v", desc.Digest, err)
+				continue
+			}
+			rc.Close()
+		}
+	}
+}
+
+func TestExtractArchiveTraversal(t *testing.T) {
+	for _, name := range []string{"../evil", "/etc/evil", "blobs/../../evil"} {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: 1, Mode: 0644})
+		tw.Write([]byte("x"))
+		tw.Close()
+		if _, err := layoututil.ExtractArchive(&buf, t.TempDir()); err == nil {
+			t.Errorf("%s: expected error for entry outside of layout", name)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package layoututil
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	ocispecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ReachableBlobs walks the tree rooted at desc in the layout at p, and returns the hashes of every blob
+// referenced by it, including desc itself, children manifests and indexes, configs and layers.
+// Each hash is returned only once.
+func ReachableBlobs(p layout.Path, desc v1.Descriptor) ([]v1.Hash, error) {
+	seen := map[v1.Hash]bool{}
+	var hashes []v1.Hash
+	if err := reachable(p, desc, seen, &hashes); err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+func reachable(p layout.Path, desc v1.Descriptor, seen map[v1.Hash]bool, hashes *[]v1.Hash) error {
+	if seen[desc.Digest] {
+		return nil
+	}
+	seen[desc.Digest] = true
+	*hashes = append(*hashes, desc.Digest)
+
+	switch desc.MediaType {
+	case types.OCIImageIndex, types.DockerManifestList:
+		b, err := p.Bytes(desc.Digest)
+		if err != nil {
+			return fmt.Errorf("unable to read index %s: %v", desc.Digest, err)
+		}
+		var index v1.IndexManifest
+		if err := json.Unmarshal(b, &index); err != nil {
+			return fmt.Errorf("unable to parse index %s: %v", desc.Digest, err)
+		}
+		for _, child := range index.Manifests {
+			if err := reachable(p, child, seen, hashes); err != nil {
+				return err
+			}
+		}
+	case types.OCIManifestSchema1, types.DockerManifestSchema2:
+		b, err := p.Bytes(desc.Digest)
+		if err != nil {
+			return fmt.Errorf("unable to read manifest %s: %v", desc.Digest, err)
+		}
+		var manifest v1.Manifest
+		if err := json.Unmarshal(b, &manifest); err != nil {
+			return fmt.Errorf("unable to parse manifest %s: %v", desc.Digest, err)
+		}
+		for _, child := range append([]v1.Descriptor{manifest.Config}, manifest.Layers...) {
+			if err := reachable(p, child, seen, hashes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CopyDescriptor copies every blob reachable from desc in src into dst, and adds desc to the index.json
+// of dst. Blobs that already exist in dst are not copied again. If dst already has a descriptor with the same
+// digest and the same ref name annotation, the index.json is left unchanged.
+func CopyDescriptor(dst, src layout.Path, desc v1.Descriptor) error {
+	hashes, err := ReachableBlobs(src, desc)
+	if err != nil {
+		return err
+	}
+	for _, h := range hashes {
+		if err := copyBlob(dst, src, h); err != nil {
+			return err
+		}
+	}
+	return UpdateIndex(dst, func(index *v1.IndexManifest) error {
+		for _, d := range index.Manifests {
+			if d.Digest == desc.Digest && d.Annotations[ocispecv1.AnnotationRefName] == desc.Annotations[ocispecv1.AnnotationRefName] {
+				return nil
+			}
+		}
+		index.Manifests = append(index.Manifests, desc)
+		return nil
+	})
+}
+
+// Merge copies every descriptor in the index.json of each of srcs, along with all of their blobs,
+// into dst. Blobs are deduplicated by digest.
+func Merge(dst layout.Path, srcs ...layout.Path) error {
+	for _, src := range srcs {
+		index, err := rootIndexManifest(src)
+		if err != nil {
+			return fmt.Errorf("unable to read index for %s: %v", src, err)
+		}
+		for _, desc := range index.Manifests {
+			if err := CopyDescriptor(dst, src, desc); err != nil {
+				return fmt.Errorf("unable to copy %s from %s: %v", desc.Digest, src, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Export copies only those descriptors in the index.json of src that match one of refs, along with the
+// blobs reachable from them, into dst. A ref matches a descriptor if it is equal to its ref name annotation
+// or its digest. It is an error if any ref matches nothing.
+func Export(dst, src layout.Path, refs []string) error {
+	index, err := rootIndexManifest(src)
+	if err != nil {
+		return fmt.Errorf("unable to read index for %s: %v", src, err)
+	}
+	for _, ref := range refs {
+		var found bool
+		for _, desc := range index.Manifests {
+			if desc.Annotations[ocispecv1.AnnotationRefName] != ref && desc.Digest.String() != ref {
+				continue
+			}
+			found = true
+			if err := CopyDescriptor(dst, src, desc); err != nil {
+				return fmt.Errorf("unable to copy %s from %s: %v", ref, src, err)
+			}
+		}
+		if !found {
+			return fmt.Errorf("no descriptor for %s in %s", ref, src)
+		}
+	}
+	return nil
+}
+
+func rootIndexManifest(p layout.Path) (*v1.IndexManifest, error) {
+	ii, err := p.ImageIndex()
+	if err != nil {
+		return nil, err
+	}
+	return ii.IndexManifest()
+}
+
+func copyBlob(dst, src layout.Path, h v1.Hash) error {
+	if _, err := os.Stat(filepath.Join(string(dst), "blobs", h.Algorithm, h.Hex)); err == nil {
+		return nil
+	}
+	rc, err := src.Blob(h)
+	if err != nil {
+		return fmt.Errorf("unable to read blob %s: %v", h, err)
+	}
+	if err := WriteBlob(dst, h, rc); err != nil {
+		return fmt.Errorf("unable to write blob %s: %v", h, err)
+	}
+	return nil
+}
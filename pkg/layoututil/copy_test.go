@@ -0,0 +1,167 @@
+package layoututil_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"sort"
+	"testing"
+
+	"github.com/deitch/ocidist/pkg/layoututil"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	ocispecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestMergeExport(t *testing.T) {
+	shared, err := random.Image(64, 2)
+	if err != nil {
+		t.Fatalf("unable to create random image: %v", err)
+	}
+	only, err := random.Index(64, 1, 2)
+	if err != nil {
+		t.Fatalf("unable to create random index: %v", err)
+	}
+
+	a, err := layout.Write(t.TempDir(), empty.Index)
+	if err != nil {
+		t.Fatalf("unable to create layout: %v", err)
+	}
+	b, err := layout.Write(t.TempDir(), empty.Index)
+	if err != nil {
+		t.Fatalf("unable to create layout: %v", err)
+	}
+	if err := a.AppendImage(shared, layout.WithAnnotations(map[string]string{ocispecv1.AnnotationRefName: "shared"})); err != nil {
+		t.Fatalf("unable to append image: %v", err)
+	}
+	if err := b.AppendImage(shared, layout.WithAnnotations(map[string]string{ocispecv1.AnnotationRefName: "shared"})); err != nil {
+		t.Fatalf("unable to append image: %v", err)
+	}
+	if err := b.AppendIndex(only, layout.WithAnnotations(map[string]string{ocispecv1.AnnotationRefName: "only"})); err != nil {
+		t.Fatalf("unable to append index: %v", err)
+	}
+
+	merged, err := layoututil.GetCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("unable to create layout: %v", err)
+	}
+	if err := layoututil.Merge(merged, a, b); err != nil {
+		t.Fatalf("unable to merge: %v", err)
+	}
+	if refs := refNames(t, merged); !stringSliceEqual(refs, []string{"only", "shared"}) {
+		t.Errorf("mismatched merged refs, actual %v expected %v", refs, []string{"only", "shared"})
+	}
+	if _, err := merged.Image(mustDigest(t, shared.Digest)); err != nil {
+		t.Errorf("merged layout missing shared image: %v", err)
+	}
+	if _, err := merged.ImageIndex(); err != nil {
+		t.Errorf("merged layout has unreadable index: %v", err)
+	}
+
+	exported, err := layoututil.GetCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("unable to create layout: %v", err)
+	}
+	if err := layoututil.Export(exported, merged, []string{"only"}); err != nil {
+		t.Fatalf("unable to export: %v", err)
+	}
+	if refs := refNames(t, exported); !stringSliceEqual(refs, []string{"only"}) {
+		t.Errorf("mismatched exported refs, actual %v expected %v", refs, []string{"only"})
+	}
+	// the shared image must not have been exported
+	if _, err := exported.Blob(mustDigest(t, shared.Digest)); err == nil {
+		t.Errorf("exported layout contains blob of unselected image")
+	}
+	if err := layoututil.Export(exported, merged, []string{"missing"}); err == nil {
+		t.Errorf("expected error exporting missing ref")
+	}
+
+	// the archive should hold the same content
+	var buf bytes.Buffer
+	if err := layoututil.WriteArchive(&buf, exported); err != nil {
+		t.Fatalf("unable to write archive: %v", err)
+	}
+	hashes, err := layoututil.ReachableBlobs(exported, indexDescriptor(t, exported))
+	if err != nil {
+		t.Fatalf("unable to get reachable blobs: %v", err)
+	}
+	entries := archiveEntries(t, &buf)
+	for _, name := range []string{"oci-layout", "index.json"} {
+		if !entries[name] {
+			t.Errorf("archive missing %s", name)
+		}
+	}
+	for _, h := range hashes {
+		if !entries["blobs/"+h.Algorithm+"/"+h.Hex] {
+			t.Errorf("archive missing blob %s", h)
+		}
+	}
+}
+
+func refNames(t *testing.T, p layout.Path) []string {
+	ii, err := p.ImageIndex()
+	if err != nil {
+		t.Fatalf("unable to read index: %v", err)
+	}
+	index, err := ii.IndexManifest()
+	if err != nil {
+		t.Fatalf("unable to read index manifest: %v", err)
+	}
+	var refs []string
+	for _, desc := range index.Manifests {
+		refs = append(refs, desc.Annotations[ocispecv1.AnnotationRefName])
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+func indexDescriptor(t *testing.T, p layout.Path) v1.Descriptor {
+	ii, err := p.ImageIndex()
+	if err != nil {
+		t.Fatalf("unable to read index: %v", err)
+	}
+	index, err := ii.IndexManifest()
+	if err != nil {
+		t.Fatalf("unable to read index manifest: %v", err)
+	}
+	return index.Manifests[0]
+}
+
+func archiveEntries(t *testing.T, r io.Reader) map[string]bool {
+	entries := map[string]bool{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unable to read archive: %v", err)
+		}
+		entries[hdr.Name] = true
+	}
+	return entries
+}
+
+func mustDigest(t *testing.T, f func() (v1.Hash, error)) v1.Hash {
+	h, err := f()
+	if err != nil {
+		t.Fatalf("unable to get digest: %v", err)
+	}
+	return h
+}
+
+// stringSliceEqual compares 2 string slices and returns if their contents are identical.
+func stringSliceEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, elm := range a {
+		if elm != b[i] {
+			return false
+		}
+	}
+	return true
+}
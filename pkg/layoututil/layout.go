@@ -4,7 +4,6 @@ import (
 	"fmt"
 
 	"github.com/google/go-containerregistry/pkg/v1"
-	"github.com/google/go-containerregistry/pkg/v1/empty"
 	"github.com/google/go-containerregistry/pkg/v1/layout"
 	"github.com/google/go-containerregistry/pkg/v1/match"
 	"github.com/google/go-containerregistry/pkg/v1/partial"
@@ -12,11 +11,12 @@ import (
 
 // GetCache get or initialize the cache
 func GetCache(cache string) (layout.Path, error) {
-	// initialize the cache path if needed
+	// initialize the cache path if needed; this happens under the layout lock, so that concurrent
+	// initializers cannot clobber each other
 	p, err := layout.FromPath(cache)
 	if err != nil {
-		p, err = layout.Write(cache, empty.Index)
-		if err != nil {
+		p = layout.Path(cache)
+		if err := UpdateIndex(p, func(*v1.IndexManifest) error { return nil }); err != nil {
 			return p, fmt.Errorf("could not initialize cache at path %s: %v", cache, err)
 		}
 	}
@@ -0,0 +1,33 @@
+package layoututil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+)
+
+// lockFile name of the file in the root of a layout on which we take the advisory lock
+const lockFile = "index.json.lock"
+
+// Lock take an exclusive advisory lock on the layout at p, blocking until it is available. The lock
+// guards every read-modify-write of index.json, and is honoured by other goroutines and other processes
+// alike. Call the returned func to release it.
+func Lock(p layout.Path) (func() error, error) {
+	if err := os.MkdirAll(string(p), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("unable to create layout directory %s: %v", p, err)
+	}
+	f, err := os.OpenFile(filepath.Join(string(p), lockFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open lock file in %s: %v", p, err)
+	}
+	if err := lockFd(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("unable to lock %s: %v", p, err)
+	}
+	return func() error {
+		defer f.Close()
+		return unlockFd(f)
+	}, nil
+}
@@ -0,0 +1,21 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package layoututil
+
+import (
+	"os"
+	"sync"
+)
+
+// without flock, we can only protect against other goroutines in this process
+var fallbackLock sync.Mutex
+
+func lockFd(f *os.File) error {
+	fallbackLock.Lock()
+	return nil
+}
+
+func unlockFd(f *os.File) error {
+	fallbackLock.Unlock()
+	return nil
+}
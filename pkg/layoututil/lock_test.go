@@ -0,0 +1,126 @@
+package layoututil_test
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"testing"
+
+	"github.com/deitch/ocidist/pkg/layoututil"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	ocispecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	helperLayoutEnv = "OCIDIST_TEST_APPEND_LAYOUT"
+	helperNameEnv   = "OCIDIST_TEST_APPEND_NAME"
+	appendsPerActor = 5
+)
+
+// appendImages append count random images to the layout at dir, each with a distinct ref name
+func appendImages(dir, prefix string, count int) error {
+	p, err := layoututil.GetCache(dir)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < count; i++ {
+		img, err := random.Image(32, 1)
+		if err != nil {
+			return err
+		}
+		ref := fmt.Sprintf("%s-%d", prefix, i)
+		if err := layoututil.AppendImage(p, img, layoututil.WithAnnotations(map[string]string{ocispecv1.AnnotationRefName: ref})); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TestAppendHelper is not a real test; it is run in a subprocess by TestConcurrentAppend
+func TestAppendHelper(t *testing.T) {
+	dir := os.Getenv(helperLayoutEnv)
+	if dir == "" {
+		t.Skip("only run as a subprocess")
+	}
+	if err := appendImages(dir, os.Getenv(helperNameEnv), appendsPerActor); err != nil {
+		t.Fatalf("unable to append: %v", err)
+	}
+}
+
+func TestConcurrentAppend(t *testing.T) {
+	const (
+		goroutines = 16
+		processes  = 4
+	)
+	dir := t.TempDir()
+
+	var (
+		wg   sync.WaitGroup
+		errs = make(chan error, goroutines+processes)
+	)
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- appendImages(dir, fmt.Sprintf("goroutine%d", i), appendsPerActor)
+		}(i)
+	}
+	for i := 0; i < processes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cmd := exec.Command(os.Args[0], "-test.run=^TestAppendHelper$")
+			cmd.Env = append(os.Environ(), helperLayoutEnv+"="+dir, fmt.Sprintf("%s=process%d", helperNameEnv, i))
+			if out, err := cmd.CombinedOutput(); err != nil {
+				errs <- fmt.Errorf("process %d failed: %v\n%s", i, err, out)
+				return
+			}
+			errs <- nil
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	p, err := layout.FromPath(dir)
+	if err != nil {
+		t.Fatalf("unable to read layout: %v", err)
+	}
+	refs := refNames(t, p)
+	expected := (goroutines + processes) * appendsPerActor
+	if len(refs) != expected {
+		t.Fatalf("mismatched descriptor count, actual %d expected %d", len(refs), expected)
+	}
+	seen := map[string]bool{}
+	for _, ref := range refs {
+		if seen[ref] {
+			t.Errorf("duplicate ref %s", ref)
+		}
+		seen[ref] = true
+	}
+	// every image must be fully readable
+	ii, err := p.ImageIndex()
+	if err != nil {
+		t.Fatalf("unable to read index: %v", err)
+	}
+	index, err := ii.IndexManifest()
+	if err != nil {
+		t.Fatalf("unable to read index manifest: %v", err)
+	}
+	for _, desc := range index.Manifests {
+		img, err := p.Image(desc.Digest)
+		if err != nil {
+			t.Errorf("unable to read image %s: %v", desc.Digest, err)
+			continue
+		}
+		if _, err := img.ConfigFile(); err != nil {
+			t.Errorf("unable to read config of image %s: %v", desc.Digest, err)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package layoututil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/stream"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"golang.org/x/sync/errgroup"
+)
+
+// layoutFile content of the oci-layout file at the root of every layout
+const layoutFile = `{
+    "imageLayoutVersion": "1.0.0"
+}`
+
+// Option modifies the descriptor that is added to index.json
+type Option func(*v1.Descriptor)
+
+// WithAnnotations set the annotations on the descriptor added to index.json
+func WithAnnotations(annotations map[string]string) Option {
+	return func(desc *v1.Descriptor) {
+		if desc.Annotations == nil {
+			desc.Annotations = map[string]string{}
+		}
+		for k, v := range annotations {
+			desc.Annotations[k] = v
+		}
+	}
+}
+
+// WithPlatform set the platform on the descriptor added to index.json
+func WithPlatform(platform v1.Platform) Option {
+	return func(desc *v1.Descriptor) {
+		desc.Platform = &platform
+	}
+}
+
+// WriteBlob write the content of rc to the blobs directory of p as h. The blob is written to a temporary
+// file, its digest verified, and then renamed into place, so no reader ever sees a partial blob. If the blob
+// already exists, it is not written again. If h is empty, the name is taken from the computed sha256 digest.
+func WriteBlob(p layout.Path, h v1.Hash, rc io.ReadCloser) error {
+	_, err := writeBlob(p, h, rc)
+	return err
+}
+
+func writeBlob(p layout.Path, h v1.Hash, rc io.ReadCloser) (v1.Hash, error) {
+	defer rc.Close()
+	if h.Algorithm == "" {
+		h.Algorithm = "sha256"
+	}
+	dir := filepath.Join(string(p), "blobs", h.Algorithm)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return h, fmt.Errorf("unable to create blobs directory %s: %v", dir, err)
+	}
+	if h.Hex != "" {
+		if fi, err := os.Stat(filepath.Join(dir, h.Hex)); err == nil && !fi.IsDir() {
+			return h, nil
+		}
+	}
+
+	tmp, err := os.CreateTemp(dir, ".tmp-")
+	if err != nil {
+		return h, fmt.Errorf("unable to create temporary blob file in %s: %v", dir, err)
+	}
+	// after a successful rename, this is a no-op
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hasher), rc); err != nil {
+		return h, fmt.Errorf("unable to write blob %s: %v", h, err)
+	}
+	// close the source first, as streaming layers only finish computing on close
+	if err := rc.Close(); err != nil {
+		return h, fmt.Errorf("unable to close blob source %s: %v", h, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return h, fmt.Errorf("unable to close temporary blob file %s: %v", tmp.Name(), err)
+	}
+	computed := v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(hasher.Sum(nil))}
+	switch {
+	case h.Hex == "":
+		h = computed
+		dir = filepath.Join(string(p), "blobs", h.Algorithm)
+	case h.Algorithm == computed.Algorithm && h != computed:
+		return h, fmt.Errorf("blob digest mismatch, expected %s actual %s", h, computed)
+	}
+	// temporary files are created only readable by the owner, but blobs are for anyone sharing the layout
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return h, fmt.Errorf("unable to set permissions on blob %s: %v", h, err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, h.Hex)); err != nil {
+		return h, fmt.Errorf("unable to rename blob into place %s: %v", h, err)
+	}
+	return h, nil
+}
+
+// WriteImage write the layers, config and manifest of img to the blobs directory of p, each atomically.
+// Does not modify index.json; for that, use AppendImage.
+func WriteImage(p layout.Path, img v1.Image) error {
+	layers, err := img.Layers()
+	if err != nil {
+		return err
+	}
+
+	var g errgroup.Group
+	for _, layer := range layers {
+		layer := layer
+		g.Go(func() error {
+			d, err := layer.Digest()
+			if errors.Is(err, stream.ErrNotComputed) {
+				// streaming layers do not know their digest until they have been read
+				d = v1.Hash{}
+			} else if err != nil {
+				return err
+			}
+			rc, err := layer.Compressed()
+			if err != nil {
+				return err
+			}
+			return WriteBlob(p, d, rc)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return fmt.Errorf("error writing layer: %v", err)
+	}
+
+	cfgName, err := img.ConfigName()
+	if err != nil {
+		return err
+	}
+	cfgBlob, err := img.RawConfigFile()
+	if err != nil {
+		return err
+	}
+	if err := WriteBlob(p, cfgName, io.NopCloser(bytes.NewReader(cfgBlob))); err != nil {
+		return err
+	}
+
+	d, err := img.Digest()
+	if err != nil {
+		return err
+	}
+	manifest, err := img.RawManifest()
+	if err != nil {
+		return err
+	}
+	return WriteBlob(p, d, io.NopCloser(bytes.NewReader(manifest)))
+}
+
+type withLayer interface {
+	Layer(v1.Hash) (v1.Layer, error)
+}
+
+type withBlob interface {
+	Blob(v1.Hash) (io.ReadCloser, error)
+}
+
+// WriteIndex write ii and everything reachable from it to the blobs directory of p, each atomically.
+// Does not modify index.json; for that, use AppendIndex.
+func WriteIndex(p layout.Path, ii v1.ImageIndex) error {
+	index, err := ii.IndexManifest()
+	if err != nil {
+		return err
+	}
+
+	for _, desc := range index.Manifests {
+		switch desc.MediaType {
+		case types.OCIImageIndex, types.DockerManifestList:
+			child, err := ii.ImageIndex(desc.Digest)
+			if err != nil {
+				return err
+			}
+			if err := WriteIndex(p, child); err != nil {
+				return err
+			}
+		case types.OCIManifestSchema1, types.DockerManifestSchema2:
+			img, err := ii.Image(desc.Digest)
+			if err != nil {
+				return err
+			}
+			if err := WriteImage(p, img); err != nil {
+				return err
+			}
+		default:
+			// anything else, we just pass through as a blob
+			var blob io.ReadCloser
+			switch b := ii.(type) {
+			case withLayer:
+				layer, err := b.Layer(desc.Digest)
+				if err != nil {
+					return err
+				}
+				if blob, err = layer.Compressed(); err != nil {
+					return err
+				}
+			case withBlob:
+				if blob, err = b.Blob(desc.Digest); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("unable to read descriptor %s of media type %s", desc.Digest, desc.MediaType)
+			}
+			if err := WriteBlob(p, desc.Digest, blob); err != nil {
+				return err
+			}
+		}
+	}
+
+	d, err := ii.Digest()
+	if err != nil {
+		return err
+	}
+	manifest, err := ii.RawManifest()
+	if err != nil {
+		return err
+	}
+	return WriteBlob(p, d, io.NopCloser(bytes.NewReader(manifest)))
+}
+
+// AppendImage write img to p, and add its descriptor to index.json under the layout lock
+func AppendImage(p layout.Path, img v1.Image, options ...Option) error {
+	if err := WriteImage(p, img); err != nil {
+		return err
+	}
+	desc, err := partial.Descriptor(img)
+	if err != nil {
+		return err
+	}
+	for _, opt := range options {
+		opt(desc)
+	}
+	return AppendDescriptor(p, *desc)
+}
+
+// AppendIndex write ii to p, and add its descriptor to index.json under the layout lock
+func AppendIndex(p layout.Path, ii v1.ImageIndex, options ...Option) error {
+	if err := WriteIndex(p, ii); err != nil {
+		return err
+	}
+	desc, err := partial.Descriptor(ii)
+	if err != nil {
+		return err
+	}
+	for _, opt := range options {
+		opt(desc)
+	}
+	return AppendDescriptor(p, *desc)
+}
+
+// AppendDescriptor add desc to index.json of p under the layout lock
+func AppendDescriptor(p layout.Path, desc v1.Descriptor) error {
+	return UpdateIndex(p, func(index *v1.IndexManifest) error {
+		index.Manifests = append(index.Manifests, desc)
+		return nil
+	})
+}
+
+// UpdateIndex perform a read-modify-write of index.json of p, holding the layout lock throughout.
+// If the layout does not yet exist, it is initialized first. The new index.json is written to
+// a temporary file and renamed into place.
+func UpdateIndex(p layout.Path, update func(*v1.IndexManifest) error) error {
+	unlock, err := Lock(p)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	indexFile := filepath.Join(string(p), "index.json")
+	index := v1.IndexManifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIImageIndex,
+		Manifests:     []v1.Descriptor{},
+	}
+	b, err := os.ReadFile(indexFile)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(b, &index); err != nil {
+			return fmt.Errorf("unable to parse %s: %v", indexFile, err)
+		}
+	case os.IsNotExist(err):
+		if err := writeFileAtomic(filepath.Join(string(p), "oci-layout"), []byte(layoutFile)); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unable to read %s: %v", indexFile, err)
+	}
+
+	if err := update(&index); err != nil {
+		return err
+	}
+
+	b, err = json.MarshalIndent(index, "", "   ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(indexFile, b)
+}
+
+func writeFileAtomic(target string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+"-")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary file for %s: %v", target, err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := tmp.Write(b); err != nil {
+		return fmt.Errorf("unable to write temporary file for %s: %v", target, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to close temporary file for %s: %v", target, err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("unable to set permissions on temporary file for %s: %v", target, err)
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return fmt.Errorf("unable to rename %s into place: %v", target, err)
+	}
+	return nil
+}
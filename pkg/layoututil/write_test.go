@@ -0,0 +1,47 @@
+package layoututil_test
+
+import (
+	"io/fs"
+	"path/filepath"
+	"testing"
+
+	"github.com/deitch/ocidist/pkg/layoututil"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestWriteBlobMode(t *testing.T) {
+	dir := t.TempDir()
+	p, err := layoututil.GetCache(dir)
+	if err != nil {
+		t.Fatalf("unable to create layout: %v", err)
+	}
+	img, err := random.Image(100, 2)
+	if err != nil {
+		t.Fatalf("unable to create image: %v", err)
+	}
+	if err := layoututil.AppendImage(p, img); err != nil {
+		t.Fatalf("unable to append image: %v", err)
+	}
+	var files int
+	err = filepath.WalkDir(filepath.Join(dir, "blobs"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files++
+		if fi.Mode().Perm() != 0644 {
+			t.Errorf("%s: mismatched mode, actual %v expected %v", path, fi.Mode().Perm(), fs.FileMode(0644))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the manifest, the config and two layers
+	if files != 4 {
+		t.Errorf("mismatched blob count, actual %d expected 4", files)
+	}
+}
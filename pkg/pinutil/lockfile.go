@@ -0,0 +1,120 @@
+package pinutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"golang.org/x/sync/errgroup"
+)
+
+// Lockfile the digests a set of references resolved to
+type Lockfile struct {
+	Images []LockedImage `json:"images"`
+}
+
+// LockedImage what a reference resolved to. For an index, Platforms has each of the manifests in it.
+type LockedImage struct {
+	Ref       string           `json:"ref"`
+	Digest    v1.Hash          `json:"digest"`
+	MediaType types.MediaType  `json:"mediaType"`
+	Size      int64            `json:"size"`
+	Platforms []LockedPlatform `json:"platforms,omitempty"`
+}
+
+// LockedPlatform a manifest in a locked index
+type LockedPlatform struct {
+	Platform  *v1.Platform    `json:"platform,omitempty"`
+	Digest    v1.Hash         `json:"digest"`
+	MediaType types.MediaType `json:"mediaType"`
+	Size      int64           `json:"size"`
+}
+
+// ReadLockfile read a lockfile as written by Write
+func ReadLockfile(r io.Reader) (*Lockfile, error) {
+	var l Lockfile
+	if err := json.NewDecoder(r).Decode(&l); err != nil {
+		return nil, fmt.Errorf("invalid lockfile: %v", err)
+	}
+	return &l, nil
+}
+
+// Write write l to w as indented json
+func (l *Lockfile) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(l)
+}
+
+// Find the image locked for ref, exactly as it was given to Resolve
+func (l *Lockfile) Find(ref string) (*LockedImage, bool) {
+	for i := range l.Images {
+		if l.Images[i].Ref == ref {
+			return &l.Images[i], true
+		}
+	}
+	return nil, false
+}
+
+// Resolve look up what each of refs points to in its registry, using HEAD, and for an index, getting it to list
+// the manifests in it. Up to jobs references are looked up at once. The images in the lockfile are in the same
+// order as refs.
+func Resolve(refs []string, jobs int, options ...remote.Option) (*Lockfile, error) {
+	images := make([]LockedImage, len(refs))
+	var g errgroup.Group
+	if jobs > 0 {
+		g.SetLimit(jobs)
+	}
+	for i, ref := range refs {
+		i, ref := i, ref
+		g.Go(func() error {
+			locked, err := resolveRef(ref, options...)
+			if err != nil {
+				return fmt.Errorf("unable to resolve %s: %v", ref, err)
+			}
+			images[i] = *locked
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return &Lockfile{Images: images}, nil
+}
+
+func resolveRef(ref string, options ...remote.Option) (*LockedImage, error) {
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := remote.Head(r, options...)
+	if err != nil {
+		return nil, err
+	}
+	locked := &LockedImage{Ref: ref, Digest: desc.Digest, MediaType: desc.MediaType, Size: desc.Size}
+	if !desc.MediaType.IsIndex() {
+		return locked, nil
+	}
+	// by digest, in case the tag moves between the two requests
+	ii, err := remote.Index(r.Context().Digest(desc.Digest.String()), options...)
+	if err != nil {
+		return nil, err
+	}
+	index, err := ii.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range index.Manifests {
+		locked.Platforms = append(locked.Platforms, LockedPlatform{
+			Platform:  child.Platform,
+			Digest:    child.Digest,
+			MediaType: child.MediaType,
+			Size:      child.Size,
+		})
+	}
+	return locked, nil
+}
@@ -0,0 +1,80 @@
+package pinutil_test
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/deitch/ocidist/pkg/pinutil"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestResolve(t *testing.T) {
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+	host := strings.TrimPrefix(s.URL, "http://")
+
+	img, err := random.Image(100, 1)
+	if err != nil {
+		t.Fatalf("unable to create image: %v", err)
+	}
+	platform := &v1.Platform{OS: "linux", Architecture: "arm64"}
+	ii := mutate.AppendManifests(empty.Index, mutate.IndexAddendum{Add: img, Descriptor: v1.Descriptor{Platform: platform}})
+	imgRef, indexRef := host+"/foo/img:1", host+"/foo/index:1"
+	imgTag, _ := name.NewTag(imgRef)
+	indexTag, _ := name.NewTag(indexRef)
+	if err := remote.Write(imgTag, img); err != nil {
+		t.Fatalf("unable to push image: %v", err)
+	}
+	if err := remote.WriteIndex(indexTag, ii); err != nil {
+		t.Fatalf("unable to push index: %v", err)
+	}
+
+	lock, err := pinutil.Resolve([]string{indexRef, imgRef}, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	imgDigest, _ := img.Digest()
+	imgSize, _ := img.Size()
+	imgMediaType, _ := img.MediaType()
+	indexDigest, _ := ii.Digest()
+	indexSize, _ := ii.Size()
+	indexMediaType, _ := ii.MediaType()
+	expected := &pinutil.Lockfile{Images: []pinutil.LockedImage{
+		{Ref: indexRef, Digest: indexDigest, MediaType: indexMediaType, Size: indexSize, Platforms: []pinutil.LockedPlatform{
+			{Platform: platform, Digest: imgDigest, MediaType: imgMediaType, Size: imgSize},
+		}},
+		{Ref: imgRef, Digest: imgDigest, MediaType: imgMediaType, Size: imgSize},
+	}}
+	if diff := cmp.Diff(expected, lock); diff != "" {
+		t.Errorf("mismatched lockfile (-expected +actual):\n%s", diff)
+	}
+
+	// and it reads back the same
+	var buf bytes.Buffer
+	if err := lock.Write(&buf); err != nil {
+		t.Fatalf("unable to write lockfile: %v", err)
+	}
+	read, err := pinutil.ReadLockfile(&buf)
+	if err != nil {
+		t.Fatalf("unable to read lockfile: %v", err)
+	}
+	if diff := cmp.Diff(lock, read); diff != "" {
+		t.Errorf("mismatched lockfile read back (-expected +actual):\n%s", diff)
+	}
+	if locked, ok := read.Find(imgRef); !ok || locked.Digest != imgDigest {
+		t.Errorf("unable to find %s: %v", imgRef, locked)
+	}
+
+	if _, err := pinutil.Resolve([]string{host + "/foo/missing:1"}, 0); err == nil {
+		t.Errorf("expected error resolving a missing ref")
+	}
+}
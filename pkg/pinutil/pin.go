@@ -0,0 +1,149 @@
+package pinutil
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// pinPattern an image reference with both a tag and a digest, as in name:tag@sha256:hex, the tagged name in the first
+// group and the digest in the second. It is loose, with anything it matches checked with name.NewTag.
+var pinPattern = regexp.MustCompile(`([\w][\w.-]*(?::[0-9]+)?(?:/[\w.-]+)*:[\w][\w.-]{0,127})@(sha256:[0-9a-f]{64})`)
+
+// Pin an image reference that is pinned to a digest, while keeping the tag it came from
+type Pin struct {
+	// Line the line the reference is on, starting at 1
+	Line int `json:"line"`
+	// Ref the reference as written
+	Ref string `json:"ref"`
+	// Tag the tag the reference came from, as written
+	Tag string `json:"tag"`
+	// Digest the digest the reference is pinned to
+	Digest v1.Hash `json:"digest"`
+}
+
+// FindPins find every reference in content, which may be a Dockerfile, Kubernetes YAML, a compose file or any other
+// text, that has both a tag and a sha256 digest, in the order they appear. References with just a digest have no
+// tag to check, and so are left out.
+func FindPins(content []byte) []Pin {
+	var pins []Pin
+	for _, m := range pinMatches(content) {
+		digest, err := v1.NewHash(m.digest)
+		if err != nil {
+			continue
+		}
+		pins = append(pins, Pin{
+			Line:   bytes.Count(content[:m.start], []byte("\n")) + 1,
+			Ref:    string(content[m.start:m.digestEnd]),
+			Tag:    m.tag,
+			Digest: digest,
+		})
+	}
+	return pins
+}
+
+// UpdatePins replace the digest of every pinned reference in content that is in updates, by the reference as
+// written, with the digest for it there, leaving everything else as it is. Returns the updated content, and how many
+// references were changed.
+func UpdatePins(content []byte, updates map[string]v1.Hash) ([]byte, int) {
+	var (
+		out     bytes.Buffer
+		changed int
+		last    int
+	)
+	for _, m := range pinMatches(content) {
+		digest, ok := updates[string(content[m.start:m.digestEnd])]
+		if !ok || digest.String() == m.digest {
+			continue
+		}
+		out.Write(content[last:m.digestStart])
+		out.WriteString(digest.String())
+		last = m.digestEnd
+		changed++
+	}
+	out.Write(content[last:])
+	return out.Bytes(), changed
+}
+
+// Latest what pin should be pinned to, given locked, what its tag points to now, per Resolve. A pin to the index the
+// tag points to, or to one of the images in it, is current. A stale pin to the image for one platform stays so: if
+// the tag now points to an index, it is to be updated to the image in it for the same platform, which is looked up
+// from the config of the image pinned, where no variant there matches any. If that cannot be done, an error says why, and the pin is to be left as it
+// is. Otherwise, a stale pin is to be updated to the digest the tag points to.
+func Latest(pin Pin, locked *LockedImage, options ...remote.Option) (latest v1.Hash, stale bool, err error) {
+	if pin.Digest == locked.Digest {
+		return pin.Digest, false, nil
+	}
+	for _, p := range locked.Platforms {
+		if p.Digest == pin.Digest {
+			return pin.Digest, false, nil
+		}
+	}
+	if !locked.MediaType.IsIndex() {
+		return locked.Digest, true, nil
+	}
+
+	tag, err := name.NewTag(pin.Tag)
+	if err != nil {
+		return v1.Hash{}, true, err
+	}
+	desc, err := remote.Get(tag.Context().Digest(pin.Digest.String()), options...)
+	if err != nil {
+		return v1.Hash{}, true, fmt.Errorf("unable to tell whether %s is for one platform: %v", pin.Ref, err)
+	}
+	if desc.MediaType.IsIndex() {
+		return locked.Digest, true, nil
+	}
+	img, err := desc.Image()
+	if err != nil {
+		return v1.Hash{}, true, fmt.Errorf("unable to get image %s: %v", pin.Ref, err)
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return v1.Hash{}, true, fmt.Errorf("unable to get config of %s: %v", pin.Ref, err)
+	}
+	platform := cfg.Platform()
+	if platform == nil {
+		return v1.Hash{}, true, fmt.Errorf("%s is an image with no platform, but %s now is an index", pin.Ref, pin.Tag)
+	}
+	for _, p := range locked.Platforms {
+		if p.Platform != nil && p.Platform.OS == platform.OS && p.Platform.Architecture == platform.Architecture &&
+			(platform.Variant == "" || p.Platform.Variant == platform.Variant) {
+			return p.Digest, true, nil
+		}
+	}
+	return v1.Hash{}, true, fmt.Errorf("%s is the image for %s, which the index %s now points to does not have", pin.Ref, platform, pin.Tag)
+}
+
+// pinMatch where a pinned reference is in some content
+type pinMatch struct {
+	tag, digest                   string
+	start, digestStart, digestEnd int
+}
+
+// pinMatches every pinned reference in content
+func pinMatches(content []byte) []pinMatch {
+	var matches []pinMatch
+	for _, m := range pinPattern.FindAllSubmatchIndex(content, -1) {
+		// part of a longer word, such as a URL, is not a reference
+		if m[0] > 0 && isRefByte(content[m[0]-1]) {
+			continue
+		}
+		tag := string(content[m[2]:m[3]])
+		if _, err := name.NewTag(tag); err != nil {
+			continue
+		}
+		matches = append(matches, pinMatch{tag: tag, digest: string(content[m[4]:m[5]]), start: m[0], digestStart: m[4], digestEnd: m[5]})
+	}
+	return matches
+}
+
+// isRefByte whether b can be part of an image reference
+func isRefByte(b byte) bool {
+	return b == '.' || b == '-' || b == '_' || b == '/' || b == ':' || b == '@' ||
+		('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9')
+}
@@ -0,0 +1,143 @@
+package pinutil_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/deitch/ocidist/pkg/pinutil"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+var (
+	digestA = "sha256:" + strings.Repeat("a", 64)
+	digestB = "sha256:" + strings.Repeat("b", 64)
+	digestC = "sha256:" + strings.Repeat("c", 64)
+)
+
+func TestFindPins(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		pins    []pinutil.Pin
+	}{
+		{"dockerfile", "FROM alpine:3.20@" + digestA + " AS build\nRUN true\nFROM docker.io/library/debian:12@" + digestB + "\n", []pinutil.Pin{
+			{Line: 1, Ref: "alpine:3.20@" + digestA, Tag: "alpine:3.20", Digest: v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("a", 64)}},
+			{Line: 3, Ref: "docker.io/library/debian:12@" + digestB, Tag: "docker.io/library/debian:12", Digest: v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("b", 64)}},
+		}},
+		{"yaml", "spec:\n  containers:\n  - image: \"localhost:5000/app/web:v1.2.3@" + digestC + "\"\n", []pinutil.Pin{
+			{Line: 3, Ref: "localhost:5000/app/web:v1.2.3@" + digestC, Tag: "localhost:5000/app/web:v1.2.3", Digest: v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("c", 64)}},
+		}},
+		{"digest only", "image: alpine@" + digestA + "\n", nil},
+		{"tag only", "image: alpine:3.20\n", nil},
+		{"in a url", "see https://example.com/alpine:3.20@" + digestA + "\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.pins, pinutil.FindPins([]byte(tt.content))); diff != "" {
+				t.Errorf("mismatched pins (-expected +actual):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUpdatePins(t *testing.T) {
+	content := "FROM alpine:3.20@" + digestA + "\nFROM debian:12@" + digestB + "\nFROM busybox:1@" + digestC + "\n"
+	updates := map[string]v1.Hash{
+		"alpine:3.20@" + digestA: {Algorithm: "sha256", Hex: strings.Repeat("c", 64)},
+		// already current
+		"debian:12@" + digestB: {Algorithm: "sha256", Hex: strings.Repeat("b", 64)},
+		// not in the content
+		"busybox:1@" + digestA: {Algorithm: "sha256", Hex: strings.Repeat("b", 64)},
+	}
+	out, changed := pinutil.UpdatePins([]byte(content), updates)
+	expected := "FROM alpine:3.20@" + digestC + "\nFROM debian:12@" + digestB + "\nFROM busybox:1@" + digestC + "\n"
+	if diff := cmp.Diff(expected, string(out)); diff != "" {
+		t.Errorf("mismatched content (-expected +actual):\n%s", diff)
+	}
+	if changed != 1 {
+		t.Errorf("mismatched changed count, actual %d expected 1", changed)
+	}
+}
+
+func TestLatest(t *testing.T) {
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+	repo, err := name.NewRepository(strings.TrimPrefix(s.URL, "http://") + "/foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an index of an image for each of platforms, each pushed by digest
+	pushIndex := func(platforms ...string) (v1.Hash, map[string]v1.Hash) {
+		children := map[string]v1.Hash{}
+		var adds []mutate.IndexAddendum
+		for _, p := range platforms {
+			platform, err := v1.ParsePlatform(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := random.Image(100, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg, _ := img.ConfigFile()
+			cfg = cfg.DeepCopy()
+			cfg.OS, cfg.Architecture = platform.OS, platform.Architecture
+			if img, err = mutate.ConfigFile(img, cfg); err != nil {
+				t.Fatal(err)
+			}
+			children[p], _ = img.Digest()
+			adds = append(adds, mutate.IndexAddendum{Add: img, Descriptor: v1.Descriptor{Platform: platform}})
+		}
+		ii := mutate.AppendManifests(empty.Index, adds...)
+		digest, _ := ii.Digest()
+		if err := remote.WriteIndex(repo.Digest(digest.String()), ii); err != nil {
+			t.Fatalf("unable to push index: %v", err)
+		}
+		return digest, children
+	}
+	oldIndex, oldChildren := pushIndex("linux/amd64", "linux/arm64", "linux/s390x")
+	newIndex, newChildren := pushIndex("linux/amd64", "linux/arm64")
+	tag := repo.Tag("1")
+	locked := &pinutil.LockedImage{Ref: tag.String(), Digest: newIndex, MediaType: types.OCIImageIndex}
+	for p, digest := range newChildren {
+		platform, _ := v1.ParsePlatform(p)
+		locked.Platforms = append(locked.Platforms, pinutil.LockedPlatform{Platform: platform, Digest: digest})
+	}
+
+	tests := []struct {
+		name   string
+		digest v1.Hash
+		latest v1.Hash
+		stale  bool
+		err    bool
+	}{
+		{"current index", newIndex, newIndex, false, false},
+		{"current platform", newChildren["linux/arm64"], newChildren["linux/arm64"], false, false},
+		{"stale index", oldIndex, newIndex, true, false},
+		{"stale platform", oldChildren["linux/arm64"], newChildren["linux/arm64"], true, false},
+		{"removed platform", oldChildren["linux/s390x"], v1.Hash{}, true, true},
+		{"missing", v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("a", 64)}, v1.Hash{}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pin := pinutil.Pin{Ref: tag.String() + "@" + tt.digest.String(), Tag: tag.String(), Digest: tt.digest}
+			latest, stale, err := pinutil.Latest(pin, locked)
+			if (err != nil) != tt.err {
+				t.Fatalf("mismatched error, expected %v, error %v", tt.err, err)
+			}
+			if latest != tt.latest || stale != tt.stale {
+				t.Errorf("mismatched result, actual %s %v expected %s %v", latest, stale, tt.latest, tt.stale)
+			}
+		})
+	}
+}
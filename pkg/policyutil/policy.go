@@ -0,0 +1,390 @@
+package policyutil
+
+import (
+	"crypto"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/deitch/ocidist/pkg/imageutil"
+	"github.com/deitch/ocidist/pkg/signutil"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"gopkg.in/yaml.v3"
+)
+
+// rule names, as the keys of the policy file, given in each violation of them
+const (
+	RuleAllowedRegistries   = "allowedRegistries"
+	RuleAllowedRepositories = "allowedRepositories"
+	RuleRequireDigest       = "requireDigest"
+	RuleMaxSize             = "maxSize"
+	RuleRequiredLabels      = "requiredLabels"
+	RuleForbidRootUser      = "forbidRootUser"
+	RuleRequiredPlatforms   = "requiredPlatforms"
+	RuleRequireSignature    = "requireSignature"
+)
+
+// Policy rules an image must meet. Each rule left empty is not checked.
+type Policy struct {
+	// AllowedRegistries registries images may come from, e.g. docker.io or ghcr.io
+	AllowedRegistries []string `yaml:"allowedRegistries"`
+	// AllowedRepositories repositories images may come from, as patterns per path.Match on the full repository
+	// name, e.g. docker.io/library/* or ghcr.io/myorg/*
+	AllowedRepositories []string `yaml:"allowedRepositories"`
+	// RequireDigest whether the reference must be pinned to a digest, rather than a tag
+	RequireDigest bool `yaml:"requireDigest"`
+	// MaxSize largest an image may be, as the sum of its manifest, config and compressed layers, in bytes or with
+	// a unit, e.g. 500MB or 1GiB. Each image in an index is checked on its own.
+	MaxSize Size `yaml:"maxSize"`
+	// RequiredLabels labels the config of each image must have, with the value given, or any value if it is empty
+	RequiredLabels map[string]string `yaml:"requiredLabels"`
+	// ForbidRootUser whether images may not run as root, including by not setting a user
+	ForbidRootUser bool `yaml:"forbidRootUser"`
+	// RequiredPlatforms platforms, as os/arch[/variant], that the image must have
+	RequiredPlatforms []string `yaml:"requiredPlatforms"`
+	// RequireSignature public keys, a signature by any of which must cover the image, per signutil.Verify
+	RequireSignature *SignatureRule `yaml:"requireSignature"`
+
+	keys []crypto.PublicKey
+}
+
+// SignatureRule the keys which may sign an image
+type SignatureRule struct {
+	// Keys paths to the public keys, relative to the policy file
+	Keys []string `yaml:"keys"`
+}
+
+// Size a size in bytes, which in yaml may also be given with a unit
+type Size int64
+
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000},
+	{"B", 1},
+}
+
+// UnmarshalYAML parse a size as a number of bytes, or a number with a unit of B, KB, MB, GB, KiB, MiB or GiB
+func (s *Size) UnmarshalYAML(value *yaml.Node) error {
+	size, err := ParseSize(value.Value)
+	if err != nil {
+		return err
+	}
+	*s = size
+	return nil
+}
+
+// ParseSize parse a size as a number of bytes, or a number with a unit of B, KB, MB, GB, KiB, MiB or GiB
+func ParseSize(s string) (Size, error) {
+	s = strings.TrimSpace(s)
+	factor := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, factor = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.factor
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return Size(n * float64(factor)), nil
+}
+
+// ReadPolicy read a policy in yaml from r, loading any signature keys relative to dir
+func ReadPolicy(r io.Reader, dir string) (*Policy, error) {
+	var p Policy
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&p); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("invalid policy: %v", err)
+	}
+	for _, s := range p.RequiredPlatforms {
+		platform, err := v1.ParsePlatform(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid required platform %s: %v", s, err)
+		}
+		if platform.OS == "" || platform.Architecture == "" {
+			return nil, fmt.Errorf("invalid required platform %s, must be os/arch[/variant]", s)
+		}
+	}
+	if p.RequireSignature != nil {
+		if len(p.RequireSignature.Keys) == 0 {
+			return nil, fmt.Errorf("requireSignature needs at least one key")
+		}
+		for _, k := range p.RequireSignature.Keys {
+			if !filepath.IsAbs(k) {
+				k = filepath.Join(dir, k)
+			}
+			b, err := os.ReadFile(k)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read key %s: %v", k, err)
+			}
+			pub, err := signutil.LoadPublicKey(b)
+			if err != nil {
+				return nil, fmt.Errorf("invalid key %s: %v", k, err)
+			}
+			p.keys = append(p.keys, pub)
+		}
+	}
+	return &p, nil
+}
+
+// LoadPolicy read the policy in the yaml file at p, per ReadPolicy
+func LoadPolicy(p string) (*Policy, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadPolicy(f, filepath.Dir(p))
+}
+
+// Violation a rule an image does not meet. For rules checked on each image in an index, Digest and Platform are
+// of the image.
+type Violation struct {
+	Rule     string       `json:"rule"`
+	Digest   *v1.Hash     `json:"digest,omitempty"`
+	Platform *v1.Platform `json:"platform,omitempty"`
+	Message  string       `json:"message"`
+}
+
+// Report the result of checking a reference against a policy
+type Report struct {
+	Ref        string      `json:"ref"`
+	Digest     v1.Hash     `json:"digest"`
+	Violations []Violation `json:"violations"`
+}
+
+// Passed whether the image meets every rule
+func (r *Report) Passed() bool {
+	return len(r.Violations) == 0
+}
+
+func (r *Report) add(rule string, desc *v1.Descriptor, format string, args ...interface{}) {
+	v := Violation{Rule: rule, Message: fmt.Sprintf(format, args...)}
+	if desc != nil {
+		v.Digest, v.Platform = &desc.Digest, desc.Platform
+	}
+	r.Violations = append(r.Violations, v)
+}
+
+// Evaluate check the image or index ref points to against the policy
+func (p *Policy) Evaluate(ref name.Reference, options ...remote.Option) (*Report, error) {
+	report := &Report{Ref: ref.String(), Violations: []Violation{}}
+	repo := ref.Context()
+
+	if len(p.AllowedRegistries) > 0 && !registryAllowed(repo.Registry, p.AllowedRegistries) {
+		report.add(RuleAllowedRegistries, nil, "registry %s is not one of %s", repo.RegistryStr(), strings.Join(p.AllowedRegistries, ", "))
+	}
+	if len(p.AllowedRepositories) > 0 && !repositoryAllowed(repo, p.AllowedRepositories) {
+		report.add(RuleAllowedRepositories, nil, "repository %s does not match any of %s", repo.Name(), strings.Join(p.AllowedRepositories, ", "))
+	}
+	if _, ok := ref.(name.Digest); p.RequireDigest && !ok {
+		report.add(RuleRequireDigest, nil, "reference is not pinned to a digest")
+	}
+
+	desc, err := remote.Get(ref, options...)
+	if err != nil {
+		return nil, err
+	}
+	report.Digest = desc.Digest
+
+	// the images to check, with the descriptor to report them by, which is nil for a lone image
+	type checked struct {
+		img  v1.Image
+		desc *v1.Descriptor
+	}
+	var (
+		images    []checked
+		platforms []v1.Platform
+	)
+	if desc.MediaType.IsIndex() {
+		ii, err := desc.ImageIndex()
+		if err != nil {
+			return nil, err
+		}
+		index, err := ii.IndexManifest()
+		if err != nil {
+			return nil, err
+		}
+		for i := range index.Manifests {
+			child := &index.Manifests[i]
+			// nested indexes and artifacts, such as attestations, are not images to run
+			if !child.MediaType.IsImage() || imageutil.IsAttestation(*child) {
+				continue
+			}
+			img, err := ii.Image(child.Digest)
+			if err != nil {
+				return nil, err
+			}
+			images = append(images, checked{img: img, desc: child})
+			if child.Platform != nil {
+				platforms = append(platforms, *child.Platform)
+			}
+		}
+	} else {
+		img, err := desc.Image()
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, checked{img: img})
+	}
+
+	for _, c := range images {
+		cfg, err := c.img.ConfigFile()
+		if err != nil {
+			return nil, err
+		}
+		if c.desc == nil {
+			platforms = append(platforms, v1.Platform{OS: cfg.OS, Architecture: cfg.Architecture, Variant: cfg.Variant})
+		}
+		if p.MaxSize > 0 {
+			size, err := imageSize(c.img)
+			if err != nil {
+				return nil, err
+			}
+			if size > int64(p.MaxSize) {
+				report.add(RuleMaxSize, c.desc, "size %d bytes is more than the maximum of %d", size, p.MaxSize)
+			}
+		}
+		for k, v := range p.RequiredLabels {
+			actual, ok := cfg.Config.Labels[k]
+			switch {
+			case !ok:
+				report.add(RuleRequiredLabels, c.desc, "missing label %s", k)
+			case v != "" && actual != v:
+				report.add(RuleRequiredLabels, c.desc, "label %s is %q, not %q", k, actual, v)
+			}
+		}
+		if p.ForbidRootUser && isRoot(cfg.Config.User) {
+			user := cfg.Config.User
+			if user == "" {
+				user = "not set, so root"
+			}
+			report.add(RuleForbidRootUser, c.desc, "user is %s", user)
+		}
+	}
+
+	for _, s := range p.RequiredPlatforms {
+		want, _ := v1.ParsePlatform(s)
+		found := false
+		for _, have := range platforms {
+			if have.Satisfies(*want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			report.add(RuleRequiredPlatforms, nil, "missing platform %s", s)
+		}
+	}
+
+	if p.RequireSignature != nil {
+		// the digest checked above, not ref again, as a tag can move in between
+		if err := p.checkSignature(repo.Digest(desc.Digest.String()), report, options...); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// checkSignature add a violation to report for each manifest of ref, which is pinned to a digest, not covered by a
+// signature by any of the keys
+func (p *Policy) checkSignature(ref name.Reference, report *Report, options ...remote.Option) error {
+	var results []*signutil.Result
+	for _, pub := range p.keys {
+		result, err := signutil.Verify(ref, pub, options...)
+		if err != nil {
+			return err
+		}
+		if result.Verified() {
+			return nil
+		}
+		results = append(results, result)
+	}
+	// with an index, each platform may be covered by a different key
+	if len(results[0].Platforms) > 0 {
+		covered := map[v1.Hash]bool{}
+		for _, r := range results {
+			for _, platform := range r.Platforms {
+				if platform.Covered {
+					covered[platform.Digest] = true
+				}
+			}
+		}
+		var uncovered []signutil.Result
+		for _, platform := range results[0].Platforms {
+			if !covered[platform.Digest] {
+				uncovered = append(uncovered, platform)
+			}
+		}
+		for _, u := range uncovered {
+			desc := &v1.Descriptor{Digest: u.Digest, Platform: u.Platform}
+			report.add(RuleRequireSignature, desc, "no signature by any of the %d keys", len(p.keys))
+		}
+		return nil
+	}
+	report.add(RuleRequireSignature, nil, "no signature by any of the %d keys", len(p.keys))
+	return nil
+}
+
+// registryAllowed whether reg is one of allowed, which are normalized the same way, so that docker.io matches
+// index.docker.io
+func registryAllowed(reg name.Registry, allowed []string) bool {
+	for _, a := range allowed {
+		r, err := name.NewRegistry(a)
+		if err == nil && r.RegistryStr() == reg.RegistryStr() {
+			return true
+		}
+	}
+	return false
+}
+
+// repositoryAllowed whether repo matches any of the patterns, with its registry either as normalized or, for
+// Docker Hub, as docker.io
+func repositoryAllowed(repo name.Repository, patterns []string) bool {
+	names := []string{repo.Name()}
+	if repo.RegistryStr() == name.DefaultRegistry {
+		names = append(names, "docker.io/"+repo.RepositoryStr())
+	}
+	for _, pattern := range patterns {
+		for _, n := range names {
+			if ok, _ := path.Match(pattern, n); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// imageSize the size of img as stored in a registry, its manifest, config and compressed layers
+func imageSize(img v1.Image) (int64, error) {
+	manifest, err := img.Manifest()
+	if err != nil {
+		return 0, err
+	}
+	size, err := img.Size()
+	if err != nil {
+		return 0, err
+	}
+	size += manifest.Config.Size
+	for _, l := range manifest.Layers {
+		size += l.Size
+	}
+	return size, nil
+}
+
+// isRoot whether an image with the config user runs as root, which it does if it is empty
+func isRoot(user string) bool {
+	u := strings.SplitN(user, ":", 2)[0]
+	return u == "" || u == "root" || u == "0"
+}
@@ -0,0 +1,197 @@
+package policyutil_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/deitch/ocidist/pkg/policyutil"
+	"github.com/deitch/ocidist/pkg/signutil"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected policyutil.Size
+		valid    bool
+	}{
+		{"1024", 1024, true},
+		{"10B", 10, true},
+		{"1.5KB", 1500, true},
+		{"500 MB", 500 * 1000 * 1000, true},
+		{"2MiB", 2 << 20, true},
+		{"1GiB", 1 << 30, true},
+		{"big", 0, false},
+		{"-1", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			size, err := policyutil.ParseSize(tt.in)
+			if (err == nil) != tt.valid {
+				t.Fatalf("mismatched error, valid %v, error %v", tt.valid, err)
+			}
+			if size != tt.expected {
+				t.Errorf("actual %d, expected %d", size, tt.expected)
+			}
+		})
+	}
+}
+
+func TestReadPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		valid  bool
+	}{
+		{"empty", "", true},
+		{"all", "allowedRegistries: [docker.io]\nrequireDigest: true\nmaxSize: 10MB\nrequiredLabels: {a: \"\"}\nforbidRootUser: true\nrequiredPlatforms: [linux/amd64]\n", true},
+		{"unknown rule", "maxSise: 10MB\n", false},
+		{"invalid size", "maxSize: lots\n", false},
+		{"invalid platform", "requiredPlatforms: [\"/\"]\n", false},
+		{"no keys", "requireSignature: {keys: []}\n", false},
+		{"missing key", "requireSignature: {keys: [missing.pub]}\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := policyutil.ReadPolicy(strings.NewReader(tt.policy), t.TempDir())
+			if (err == nil) != tt.valid {
+				t.Errorf("mismatched error, valid %v, error %v", tt.valid, err)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	s := httptest.NewServer(registry.New(registry.WithReferrersSupport(true)))
+	defer s.Close()
+	host := strings.TrimPrefix(s.URL, "http://")
+	repo, err := name.NewRepository(host + "/foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an index of two images, only one of them with the label and a user
+	var adds []mutate.IndexAddendum
+	for _, platform := range []v1.Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64"}} {
+		img, err := random.Image(1000, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, _ := img.ConfigFile()
+		cfg = cfg.DeepCopy()
+		cfg.OS, cfg.Architecture = platform.OS, platform.Architecture
+		if platform.Architecture == "amd64" {
+			cfg.Config.Labels = map[string]string{"org.opencontainers.image.source": "https://example.com"}
+			cfg.Config.User = "1000:1000"
+		}
+		if img, err = mutate.ConfigFile(img, cfg); err != nil {
+			t.Fatal(err)
+		}
+		p := platform
+		adds = append(adds, mutate.IndexAddendum{Add: img, Descriptor: v1.Descriptor{Platform: &p}})
+	}
+	ii := mutate.AppendManifests(empty.Index, adds...)
+	tag := repo.Tag("1")
+	if err := remote.WriteIndex(tag, ii); err != nil {
+		t.Fatalf("unable to push index: %v", err)
+	}
+	indexDigest, err := ii.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := ii.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	amd64, arm64 := index.Manifests[0], index.Manifests[1]
+
+	// a key, which only signs the amd64 image, and another, which only signs the arm64 one
+	dir := t.TempDir()
+	for file, desc := range map[string]v1.Descriptor{"key.pub": amd64, "other.pub": arm64} {
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := x509.MarshalPKIXPublicKey(priv.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, file), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := signutil.SignManifest(repo, desc, priv, signutil.SignOptions{}); err != nil {
+			t.Fatalf("unable to sign: %v", err)
+		}
+	}
+
+	type violation struct {
+		rule     string
+		platform string
+	}
+	tests := []struct {
+		name     string
+		ref      name.Reference
+		policy   string
+		expected []violation
+	}{
+		{"empty", tag, "", nil},
+		{"allowed", repo.Digest(indexDigest.String()), "allowedRegistries: [" + host + "]\nallowedRepositories: [\"" + host + "/foo/*\"]\nrequireDigest: true\nmaxSize: 1MB\nrequiredPlatforms: [linux/amd64, linux/arm64]\n", nil},
+		{"registry", tag, "allowedRegistries: [docker.io, ghcr.io]\n", []violation{{policyutil.RuleAllowedRegistries, ""}}},
+		{"repository", tag, "allowedRepositories: [\"" + host + "/foo/baz\", \"" + host + "/bar/*\"]\n", []violation{{policyutil.RuleAllowedRepositories, ""}}},
+		{"digest", tag, "requireDigest: true\n", []violation{{policyutil.RuleRequireDigest, ""}}},
+		{"size", tag, "maxSize: 100\n", []violation{{policyutil.RuleMaxSize, "linux/amd64"}, {policyutil.RuleMaxSize, "linux/arm64"}}},
+		{"labels", tag, "requiredLabels: {org.opencontainers.image.source: \"\"}\n", []violation{{policyutil.RuleRequiredLabels, "linux/arm64"}}},
+		{"label value", tag, "requiredLabels: {org.opencontainers.image.source: https://example.org}\n", []violation{{policyutil.RuleRequiredLabels, "linux/amd64"}, {policyutil.RuleRequiredLabels, "linux/arm64"}}},
+		{"root", tag, "forbidRootUser: true\n", []violation{{policyutil.RuleForbidRootUser, "linux/arm64"}}},
+		{"platforms", tag, "requiredPlatforms: [linux/amd64, linux/s390x]\n", []violation{{policyutil.RuleRequiredPlatforms, ""}}},
+		{"signature", tag, "requireSignature: {keys: [key.pub]}\n", []violation{{policyutil.RuleRequireSignature, "linux/arm64"}}},
+		{"signature by either key", tag, "requireSignature: {keys: [key.pub, other.pub]}\n", nil},
+		{"signed image", repo.Digest(amd64.Digest.String()), "requireSignature: {keys: [key.pub]}\nrequiredPlatforms: [linux/amd64]\nforbidRootUser: true\n", nil},
+		{"unsigned image", repo.Digest(arm64.Digest.String()), "requireSignature: {keys: [key.pub]}\n", []violation{{policyutil.RuleRequireSignature, ""}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := policyutil.ReadPolicy(strings.NewReader(tt.policy), dir)
+			if err != nil {
+				t.Fatalf("invalid policy: %v", err)
+			}
+			report, err := p.Evaluate(tt.ref)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var actual []violation
+			for _, v := range report.Violations {
+				var platform string
+				if v.Platform != nil {
+					platform = v.Platform.String()
+				}
+				actual = append(actual, violation{v.Rule, platform})
+			}
+			sort.Slice(actual, func(i, j int) bool {
+				return actual[i].rule+actual[i].platform < actual[j].rule+actual[j].platform
+			})
+			if diff := cmp.Diff(tt.expected, actual, cmp.AllowUnexported(violation{})); diff != "" {
+				t.Errorf("mismatched violations (-expected +actual):\n%s\n%v", diff, report.Violations)
+			}
+			if report.Passed() != (len(tt.expected) == 0) {
+				t.Errorf("mismatched passed %v", report.Passed())
+			}
+		})
+	}
+}
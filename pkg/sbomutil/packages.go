@@ -0,0 +1,130 @@
+package sbomutil
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+const (
+	PackageTypeDeb = "deb"
+	PackageTypeApk = "apk"
+
+	// where the package databases are in the filesystem
+	dpkgStatusPath   = "var/lib/dpkg/status"
+	dpkgStatusDir    = "var/lib/dpkg/status.d"
+	apkInstalledPath = "lib/apk/db/installed"
+	osReleasePath    = "etc/os-release"
+	osReleaseAltPath = "usr/lib/os-release"
+)
+
+// Package an OS package installed in the filesystem
+type Package struct {
+	// Type the package manager, one of the PackageType constants
+	Type         string `json:"type"`
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	Architecture string `json:"architecture,omitempty"`
+	License      string `json:"license,omitempty"`
+	// Source the file in the filesystem the package was listed in
+	Source string `json:"source"`
+}
+
+// PURL the package URL of p, for an OS with the os-release ID distro, which may be empty
+func (p Package) PURL(distro string) string {
+	namespace := ""
+	if distro != "" {
+		namespace = url.PathEscape(distro) + "/"
+	}
+	purl := fmt.Sprintf("pkg:%s/%s%s@%s", p.Type, namespace, url.PathEscape(p.Name), url.PathEscape(p.Version))
+	if p.Architecture != "" {
+		purl += "?arch=" + url.QueryEscape(p.Architecture)
+	}
+	return purl
+}
+
+// stanzas split r into blank-line separated stanzas of "Key: value" lines, as dpkg and apk both use, calling fn with
+// the fields of each. sep is the separator between key and value. Lines starting with a space continue the value
+// of the previous field, and are dropped.
+func stanzas(r io.Reader, sep string, fn func(fields map[string]string)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	fields := map[string]string{}
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.TrimSpace(line) == "":
+			if len(fields) > 0 {
+				fn(fields)
+				fields = map[string]string{}
+			}
+		case strings.HasPrefix(line, " "), strings.HasPrefix(line, "\t"):
+			continue
+		default:
+			parts := strings.SplitN(line, sep, 2)
+			if len(parts) == 2 {
+				fields[parts[0]] = strings.TrimSpace(parts[1])
+			}
+		}
+	}
+	if len(fields) > 0 {
+		fn(fields)
+	}
+	return scanner.Err()
+}
+
+// ParseDpkgStatus get the installed packages from a dpkg status file, such as /var/lib/dpkg/status, or one of the
+// files in /var/lib/dpkg/status.d of distroless images, which are listed as coming from source
+func ParseDpkgStatus(r io.Reader, source string) ([]Package, error) {
+	var pkgs []Package
+	err := stanzas(r, ":", func(fields map[string]string) {
+		// without a status, as in status.d, it is installed
+		if status, ok := fields["Status"]; ok && !strings.HasSuffix(status, " installed") {
+			return
+		}
+		if fields["Package"] == "" {
+			return
+		}
+		pkgs = append(pkgs, Package{
+			Type:         PackageTypeDeb,
+			Name:         fields["Package"],
+			Version:      fields["Version"],
+			Architecture: fields["Architecture"],
+			Source:       source,
+		})
+	})
+	return pkgs, err
+}
+
+// ParseApkInstalled get the installed packages from an apk database, such as /lib/apk/db/installed, which are
+// listed as coming from source
+func ParseApkInstalled(r io.Reader, source string) ([]Package, error) {
+	var pkgs []Package
+	err := stanzas(r, ":", func(fields map[string]string) {
+		if fields["P"] == "" {
+			return
+		}
+		pkgs = append(pkgs, Package{
+			Type:         PackageTypeApk,
+			Name:         fields["P"],
+			Version:      fields["V"],
+			Architecture: fields["A"],
+			License:      fields["L"],
+			Source:       source,
+		})
+	})
+	return pkgs, err
+}
+
+// parseOSRelease get the ID from an os-release file
+func parseOSRelease(r io.Reader) (string, error) {
+	var id string
+	err := stanzas(r, "=", func(fields map[string]string) {
+		if v, ok := fields["ID"]; ok {
+			id = strings.Trim(v, `"'`)
+		}
+	})
+	return id, err
+}
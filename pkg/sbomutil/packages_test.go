@@ -0,0 +1,68 @@
+package sbomutil_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/deitch/ocidist/pkg/sbomutil"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseDpkgStatus(t *testing.T) {
+	status := `Package: libc6
+Status: install ok installed
+Architecture: amd64
+Version: 2.36-9+deb12u4
+Description: GNU C Library
+ Contains the standard libraries.
+
+Package: removed
+Status: deinstall ok config-files
+Version: 1.0
+
+Package: tzdata
+Status: install ok installed
+Architecture: all
+Version: 1:2024a-0+deb12u1
+`
+	pkgs, err := sbomutil.ParseDpkgStatus(strings.NewReader(status), "/var/lib/dpkg/status")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []sbomutil.Package{
+		{Type: sbomutil.PackageTypeDeb, Name: "libc6", Version: "2.36-9+deb12u4", Architecture: "amd64", Source: "/var/lib/dpkg/status"},
+		{Type: sbomutil.PackageTypeDeb, Name: "tzdata", Version: "1:2024a-0+deb12u1", Architecture: "all", Source: "/var/lib/dpkg/status"},
+	}
+	if diff := cmp.Diff(expected, pkgs); diff != "" {
+		t.Errorf("mismatched packages (-expected +actual):\n%s", diff)
+	}
+	if purl := pkgs[1].PURL("debian"); purl != "pkg:deb/debian/tzdata@1:2024a-0+deb12u1?arch=all" {
+		t.Errorf("mismatched purl %s", purl)
+	}
+}
+
+func TestParseApkInstalled(t *testing.T) {
+	installed := `C:Q1abc=
+P:musl
+V:1.2.5-r0
+A:x86_64
+L:MIT
+T:the musl c library
+
+P:busybox
+V:1.36.1-r29
+A:x86_64
+L:GPL-2.0-only
+`
+	pkgs, err := sbomutil.ParseApkInstalled(strings.NewReader(installed), "/lib/apk/db/installed")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []sbomutil.Package{
+		{Type: sbomutil.PackageTypeApk, Name: "musl", Version: "1.2.5-r0", Architecture: "x86_64", License: "MIT", Source: "/lib/apk/db/installed"},
+		{Type: sbomutil.PackageTypeApk, Name: "busybox", Version: "1.36.1-r29", Architecture: "x86_64", License: "GPL-2.0-only", Source: "/lib/apk/db/installed"},
+	}
+	if diff := cmp.Diff(expected, pkgs); diff != "" {
+		t.Errorf("mismatched packages (-expected +actual):\n%s", diff)
+	}
+}
@@ -0,0 +1,305 @@
+package sbomutil
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/deitch/ocidist/pkg/imageutil"
+)
+
+const (
+	FormatSPDX      = "spdx"
+	FormatCycloneDX = "cyclonedx"
+
+	// media types of the documents, also used as the artifact type when pushing them
+	SPDXMediaType      = "application/spdx+json"
+	CycloneDXMediaType = "application/vnd.cyclonedx+json"
+
+	toolName = "ocidist"
+)
+
+// File a regular file in the filesystem
+type File struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA1   string `json:"sha1"`
+	SHA256 string `json:"sha256"`
+}
+
+// Inventory what is in a filesystem: its OS, the OS packages installed, and every regular file
+type Inventory struct {
+	// Distro the ID from os-release, if any
+	Distro   string    `json:"distro,omitempty"`
+	Packages []Package `json:"packages"`
+	Files    []File    `json:"files"`
+}
+
+// Collect take the inventory of the merged filesystem idx, reading all of it once. Packages are read from the dpkg
+// and apk databases; rpm databases are not read.
+func Collect(idx *imageutil.Index) (*Inventory, error) {
+	inv := &Inventory{Packages: []Package{}, Files: []File{}}
+	byPath := map[string]File{}
+	var links []*tar.Header
+	dbs := map[string][]byte{}
+	err := idx.Entries(func(hdr *tar.Header, r io.Reader) error {
+		switch hdr.Typeflag {
+		case tar.TypeLink:
+			links = append(links, hdr)
+			return nil
+		case tar.TypeReg, tar.TypeRegA:
+		default:
+			return nil
+		}
+		var db *bytes.Buffer
+		if isDatabase(hdr.Name) {
+			db = &bytes.Buffer{}
+		}
+		h1, h256 := sha1.New(), sha256.New()
+		w := io.MultiWriter(h1, h256)
+		if db != nil {
+			w = io.MultiWriter(h1, h256, db)
+		}
+		n, err := io.Copy(w, r)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %v", hdr.Name, err)
+		}
+		if db != nil {
+			dbs[hdr.Name] = db.Bytes()
+		}
+		byPath[hdr.Name] = File{Path: hdr.Name, Size: n, SHA1: hex.EncodeToString(h1.Sum(nil)), SHA256: hex.EncodeToString(h256.Sum(nil))}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// a hard link has the content of its target, which always comes before it
+	for _, hdr := range links {
+		if target, ok := byPath[imageutil.CleanPath(hdr.Linkname)]; ok {
+			target.Path = hdr.Name
+			byPath[hdr.Name] = target
+		}
+	}
+	for _, f := range byPath {
+		inv.Files = append(inv.Files, f)
+	}
+	sort.Slice(inv.Files, func(i, j int) bool { return inv.Files[i].Path < inv.Files[j].Path })
+
+	var names []string
+	for name := range dbs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r := bytes.NewReader(dbs[name])
+		var (
+			pkgs []Package
+			err  error
+		)
+		switch {
+		case name == osReleasePath || (name == osReleaseAltPath && inv.Distro == ""):
+			inv.Distro, err = parseOSRelease(r)
+		case name == apkInstalledPath:
+			pkgs, err = ParseApkInstalled(r, "/"+name)
+		default:
+			pkgs, err = ParseDpkgStatus(r, "/"+name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %v", name, err)
+		}
+		inv.Packages = append(inv.Packages, pkgs...)
+	}
+	sort.SliceStable(inv.Packages, func(i, j int) bool { return inv.Packages[i].Name < inv.Packages[j].Name })
+	return inv, nil
+}
+
+// isDatabase whether name is a package database or os-release file to parse
+func isDatabase(name string) bool {
+	switch name {
+	case dpkgStatusPath, apkInstalledPath, osReleasePath, osReleaseAltPath:
+		return true
+	}
+	return path.Dir(name) == dpkgStatusDir
+}
+
+// Subject what an SBOM describes: the image, by its reference and manifest digest
+type Subject struct {
+	Name   string
+	Digest string
+}
+
+// SPDX get inv as an SPDX 2.3 JSON document for subject, created at created
+func (inv *Inventory) SPDX(subject Subject, created time.Time) interface{} {
+	type checksum struct {
+		Algorithm     string `json:"algorithm"`
+		ChecksumValue string `json:"checksumValue"`
+	}
+	type externalRef struct {
+		ReferenceCategory string `json:"referenceCategory"`
+		ReferenceType     string `json:"referenceType"`
+		ReferenceLocator  string `json:"referenceLocator"`
+	}
+	type pkg struct {
+		Name             string        `json:"name"`
+		SPDXID           string        `json:"SPDXID"`
+		VersionInfo      string        `json:"versionInfo,omitempty"`
+		DownloadLocation string        `json:"downloadLocation"`
+		FilesAnalyzed    bool          `json:"filesAnalyzed"`
+		PrimaryPurpose   string        `json:"primaryPackagePurpose,omitempty"`
+		ExternalRefs     []externalRef `json:"externalRefs,omitempty"`
+	}
+	type file struct {
+		FileName  string     `json:"fileName"`
+		SPDXID    string     `json:"SPDXID"`
+		Checksums []checksum `json:"checksums"`
+	}
+	type relationship struct {
+		SPDXElementID      string `json:"spdxElementId"`
+		RelationshipType   string `json:"relationshipType"`
+		RelatedSPDXElement string `json:"relatedSpdxElement"`
+	}
+	type creationInfo struct {
+		Created  string   `json:"created"`
+		Creators []string `json:"creators"`
+	}
+	type document struct {
+		SPDXVersion       string         `json:"spdxVersion"`
+		DataLicense       string         `json:"dataLicense"`
+		SPDXID            string         `json:"SPDXID"`
+		Name              string         `json:"name"`
+		DocumentNamespace string         `json:"documentNamespace"`
+		CreationInfo      creationInfo   `json:"creationInfo"`
+		Packages          []pkg          `json:"packages"`
+		Files             []file         `json:"files"`
+		Relationships     []relationship `json:"relationships"`
+	}
+
+	const imageID = "SPDXRef-Image"
+	doc := document{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              subject.Name,
+		DocumentNamespace: fmt.Sprintf("https://spdx.org/spdxdocs/%s-%s-%s", toolName, strings.ReplaceAll(subject.Digest, ":", "-"), newUUID()),
+		CreationInfo:      creationInfo{Created: created.UTC().Format(time.RFC3339), Creators: []string{"Tool: " + toolName}},
+		Packages: []pkg{{
+			Name:             subject.Name,
+			SPDXID:           imageID,
+			VersionInfo:      subject.Digest,
+			DownloadLocation: "NOASSERTION",
+			PrimaryPurpose:   "CONTAINER",
+		}},
+		Files:         []file{},
+		Relationships: []relationship{{SPDXElementID: "SPDXRef-DOCUMENT", RelationshipType: "DESCRIBES", RelatedSPDXElement: imageID}},
+	}
+	for i, p := range inv.Packages {
+		id := fmt.Sprintf("SPDXRef-Package-%s-%d", p.Type, i)
+		doc.Packages = append(doc.Packages, pkg{
+			Name:             p.Name,
+			SPDXID:           id,
+			VersionInfo:      p.Version,
+			DownloadLocation: "NOASSERTION",
+			ExternalRefs:     []externalRef{{ReferenceCategory: "PACKAGE-MANAGER", ReferenceType: "purl", ReferenceLocator: p.PURL(inv.Distro)}},
+		})
+		doc.Relationships = append(doc.Relationships, relationship{SPDXElementID: imageID, RelationshipType: "CONTAINS", RelatedSPDXElement: id})
+	}
+	for i, f := range inv.Files {
+		id := fmt.Sprintf("SPDXRef-File-%d", i)
+		doc.Files = append(doc.Files, file{
+			FileName:  "/" + f.Path,
+			SPDXID:    id,
+			Checksums: []checksum{{Algorithm: "SHA1", ChecksumValue: f.SHA1}, {Algorithm: "SHA256", ChecksumValue: f.SHA256}},
+		})
+		doc.Relationships = append(doc.Relationships, relationship{SPDXElementID: imageID, RelationshipType: "CONTAINS", RelatedSPDXElement: id})
+	}
+	return doc
+}
+
+// CycloneDX get inv as a CycloneDX 1.5 JSON document for subject, created at created
+func (inv *Inventory) CycloneDX(subject Subject, created time.Time) interface{} {
+	type hash struct {
+		Alg     string `json:"alg"`
+		Content string `json:"content"`
+	}
+	type license struct {
+		Name string `json:"name"`
+	}
+	type licenseChoice struct {
+		License license `json:"license"`
+	}
+	type component struct {
+		Type     string          `json:"type"`
+		BOMRef   string          `json:"bom-ref,omitempty"`
+		Name     string          `json:"name"`
+		Version  string          `json:"version,omitempty"`
+		PURL     string          `json:"purl,omitempty"`
+		Licenses []licenseChoice `json:"licenses,omitempty"`
+		Hashes   []hash          `json:"hashes,omitempty"`
+	}
+	type tools struct {
+		Components []component `json:"components"`
+	}
+	type metadata struct {
+		Timestamp string    `json:"timestamp"`
+		Tools     tools     `json:"tools"`
+		Component component `json:"component"`
+	}
+	type document struct {
+		BOMFormat    string      `json:"bomFormat"`
+		SpecVersion  string      `json:"specVersion"`
+		SerialNumber string      `json:"serialNumber"`
+		Version      int         `json:"version"`
+		Metadata     metadata    `json:"metadata"`
+		Components   []component `json:"components"`
+	}
+
+	doc := document{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + newUUID(),
+		Version:      1,
+		Metadata: metadata{
+			Timestamp: created.UTC().Format(time.RFC3339),
+			Tools:     tools{Components: []component{{Type: "application", Name: toolName}}},
+			Component: component{Type: "container", BOMRef: subject.Digest, Name: subject.Name, Version: subject.Digest},
+		},
+		Components: []component{},
+	}
+	for _, p := range inv.Packages {
+		purl := p.PURL(inv.Distro)
+		c := component{Type: "library", BOMRef: purl, Name: p.Name, Version: p.Version, PURL: purl}
+		if p.License != "" {
+			c.Licenses = []licenseChoice{{License: license{Name: p.License}}}
+		}
+		doc.Components = append(doc.Components, c)
+	}
+	for _, f := range inv.Files {
+		doc.Components = append(doc.Components, component{
+			Type:   "file",
+			BOMRef: "file:/" + f.Path,
+			Name:   "/" + f.Path,
+			Hashes: []hash{{Alg: "SHA-1", Content: f.SHA1}, {Alg: "SHA-256", Content: f.SHA256}},
+		})
+	}
+	return doc
+}
+
+// newUUID a random version 4 UUID
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
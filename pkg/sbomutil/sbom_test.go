@@ -0,0 +1,114 @@
+package sbomutil_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deitch/ocidist/pkg/imageutil"
+	"github.com/deitch/ocidist/pkg/sbomutil"
+	"github.com/deitch/ocidist/pkg/util"
+	"github.com/google/go-cmp/cmp"
+)
+
+type testEntry struct {
+	name, content, link string
+	typeflag            byte
+}
+
+// layer a func to open a tar stream with entries
+func layer(t *testing.T, entries []testEntry) util.GetReadCloser {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.link, Mode: 0644, Size: int64(len(e.content))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(b)), nil
+	}
+}
+
+func TestCollect(t *testing.T) {
+	lower := layer(t, []testEntry{
+		{name: "etc/", typeflag: tar.TypeDir},
+		{name: "etc/os-release", content: "NAME=\"Alpine Linux\"\nID=alpine\n", typeflag: tar.TypeReg},
+		{name: "lib/apk/db/installed", content: "P:musl\nV:1.2.5-r0\nA:x86_64\n", typeflag: tar.TypeReg},
+		{name: "bin/busybox", content: "busybox", typeflag: tar.TypeReg},
+		{name: "bin/sh", link: "/bin/busybox", typeflag: tar.TypeSymlink},
+		{name: "tmp/gone", content: "x", typeflag: tar.TypeReg},
+	})
+	upper := layer(t, []testEntry{
+		{name: "tmp/.wh.gone", typeflag: tar.TypeReg},
+		{name: "bin/ls", link: "bin/busybox", typeflag: tar.TypeLink},
+	})
+	idx, err := imageutil.NewIndex([]util.GetReadCloser{lower, upper})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv, err := sbomutil.Collect(idx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if inv.Distro != "alpine" {
+		t.Errorf("mismatched distro %q", inv.Distro)
+	}
+	expectedPkgs := []sbomutil.Package{{Type: sbomutil.PackageTypeApk, Name: "musl", Version: "1.2.5-r0", Architecture: "x86_64", Source: "/lib/apk/db/installed"}}
+	if diff := cmp.Diff(expectedPkgs, inv.Packages); diff != "" {
+		t.Errorf("mismatched packages (-expected +actual):\n%s", diff)
+	}
+	var paths []string
+	for _, f := range inv.Files {
+		paths = append(paths, f.Path)
+	}
+	if diff := cmp.Diff([]string{"bin/busybox", "bin/ls", "etc/os-release", "lib/apk/db/installed"}, paths); diff != "" {
+		t.Errorf("mismatched files (-expected +actual):\n%s", diff)
+	}
+	if inv.Files[0].SHA256 != inv.Files[1].SHA256 || inv.Files[0].Size != int64(len("busybox")) {
+		t.Errorf("expected the hard link to have the content of its target: %+v", inv.Files[:2])
+	}
+
+	subject := sbomutil.Subject{Name: "example.com/foo:1", Digest: "sha256:" + strings.Repeat("a", 64)}
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, tt := range []struct {
+		format string
+		doc    interface{}
+		check  func(map[string]interface{}) bool
+	}{
+		{sbomutil.FormatSPDX, inv.SPDX(subject, created), func(doc map[string]interface{}) bool {
+			// the image, and the package in it
+			return doc["spdxVersion"] == "SPDX-2.3" && len(doc["packages"].([]interface{})) == 2 && len(doc["files"].([]interface{})) == 4 &&
+				doc["creationInfo"].(map[string]interface{})["created"] == "2024-01-02T03:04:05Z"
+		}},
+		{sbomutil.FormatCycloneDX, inv.CycloneDX(subject, created), func(doc map[string]interface{}) bool {
+			components := doc["components"].([]interface{})
+			return doc["bomFormat"] == "CycloneDX" && len(components) == 5 &&
+				components[0].(map[string]interface{})["purl"] == "pkg:apk/alpine/musl@1.2.5-r0?arch=x86_64"
+		}},
+	} {
+		b, err := json.Marshal(tt.doc)
+		if err != nil {
+			t.Fatalf("%s: unable to marshal: %v", tt.format, err)
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal(b, &doc); err != nil {
+			t.Fatalf("%s: unable to unmarshal: %v", tt.format, err)
+		}
+		if !tt.check(doc) {
+			t.Errorf("%s: mismatched document: %s", tt.format, b)
+		}
+	}
+}
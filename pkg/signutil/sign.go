@@ -0,0 +1,135 @@
+package signutil
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/deitch/ocidist/pkg/imageutil"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// SignOptions how to sign a manifest
+type SignOptions struct {
+	// Annotations to add to the optional section of the payload
+	Annotations map[string]string
+	// Referrer push the signature as a referrer of the manifest, with it as the subject, rather than under the tag
+	// from SignatureTag
+	Referrer bool
+}
+
+// LoadPrivateKey parse a PEM-encoded, unencrypted, ECDSA or ED25519 private key, in PKCS #8 or, for ECDSA, SEC 1
+// form, as written by 'openssl genpkey'. Encrypted cosign keys are not supported.
+func LoadPrivateKey(b []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	var (
+		key interface{}
+		err error
+	)
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q, must be an unencrypted PRIVATE KEY or EC PRIVATE KEY", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %v", err)
+	}
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	}
+	return nil, fmt.Errorf("unsupported private key type %T, must be ECDSA or ED25519", key)
+}
+
+// SignPayload sign payload with priv, as VerifySignature checks it
+func SignPayload(priv crypto.Signer, payload []byte) ([]byte, error) {
+	switch k := priv.(type) {
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256(payload)
+		return ecdsa.SignASN1(rand.Reader, k, digest[:])
+	case ed25519.PrivateKey:
+		return ed25519.Sign(k, payload), nil
+	}
+	return nil, fmt.Errorf("unsupported private key type %T", priv)
+}
+
+// NewPayload the simple signing payload for the manifest with digest in repo
+func NewPayload(repo name.Repository, digest v1.Hash, annotations map[string]string) ([]byte, error) {
+	p := Payload{Critical: Critical{
+		Identity: Identity{DockerReference: repo.String()},
+		Image:    Image{DockerManifestDigest: digest.String()},
+		Type:     PayloadType,
+	}}
+	if len(annotations) > 0 {
+		p.Optional = map[string]interface{}{}
+		for k, v := range annotations {
+			p.Optional[k] = v
+		}
+	}
+	return json.Marshal(p)
+}
+
+// SignManifest sign the manifest with descriptor desc in repo with priv, and push the signature, either under the
+// tag from SignatureTag, adding to any signatures already there, or as a new referrer. Returns where it was pushed.
+func SignManifest(repo name.Repository, desc v1.Descriptor, priv crypto.Signer, opts SignOptions, options ...remote.Option) (name.Reference, error) {
+	payload, err := NewPayload(repo, desc.Digest, opts.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := SignPayload(priv, payload)
+	if err != nil {
+		return nil, err
+	}
+	addendum := mutate.Addendum{
+		Layer:       static.NewLayer(payload, SimpleSigningMediaType),
+		Annotations: map[string]string{SignatureAnnotation: base64.StdEncoding.EncodeToString(sig)},
+	}
+
+	if opts.Referrer {
+		img, err := imageutil.NewReferrer(desc, SignatureArtifactType, addendum)
+		if err != nil {
+			return nil, err
+		}
+		digest, err := img.Digest()
+		if err != nil {
+			return nil, err
+		}
+		ref := repo.Digest(digest.String())
+		return ref, remote.Write(ref, img, options...)
+	}
+
+	tag := repo.Tag(SignatureTag(desc.Digest))
+	base := mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+	if existing, err := remote.Image(tag, options...); err == nil {
+		base = existing
+	} else if !isNotFound(err) {
+		return nil, fmt.Errorf("unable to get existing signatures %s: %v", tag, err)
+	}
+	img, err := mutate.Append(base, addendum)
+	if err != nil {
+		return nil, err
+	}
+	return tag, remote.Write(tag, img, options...)
+}
@@ -0,0 +1,110 @@
+package signutil_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/deitch/ocidist/pkg/signutil"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestLoadPrivateKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec1, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		block *pem.Block
+		valid bool
+	}{
+		{"sec1", &pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}, true},
+		{"pkcs8", &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}, true},
+		{"encrypted cosign", &pem.Block{Type: "ENCRYPTED SIGSTORE PRIVATE KEY", Bytes: []byte("{}")}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := signutil.LoadPrivateKey(pem.EncodeToMemory(tt.block))
+			if (err == nil) != tt.valid {
+				t.Errorf("mismatched error, valid %v, error %v", tt.valid, err)
+			}
+		})
+	}
+}
+
+func TestSignManifest(t *testing.T) {
+	s := httptest.NewServer(registry.New(registry.WithReferrersSupport(true)))
+	defer s.Close()
+	repo, err := name.NewRepository(strings.TrimPrefix(s.URL, "http://") + "/foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := random.Image(100, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(repo.Tag("1"), img); err != nil {
+		t.Fatalf("unable to push image: %v", err)
+	}
+	desc, err := remote.Head(repo.Tag("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// two signatures under the tag, which accumulate, and one as a referrer
+	for _, sign := range []struct {
+		key      crypto.Signer
+		referrer bool
+	}{{ecKey, false}, {ecKey, false}, {edKey, true}} {
+		opts := signutil.SignOptions{Annotations: map[string]string{"env": "prod"}, Referrer: sign.referrer}
+		if _, err := signutil.SignManifest(repo, *desc, sign.key, opts); err != nil {
+			t.Fatalf("unable to sign: %v", err)
+		}
+	}
+	for _, tt := range []struct {
+		key        crypto.Signer
+		signatures int
+	}{{ecKey, 2}, {edKey, 1}} {
+		result, err := signutil.Verify(repo.Tag("1"), publicKey(t, tt.key))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !result.Verified() || len(result.Signatures) != tt.signatures {
+			t.Fatalf("mismatched signatures, expected %d: %+v", tt.signatures, result)
+		}
+		if result.Signatures[0].Payload.Optional["env"] != "prod" {
+			t.Errorf("missing annotation: %v", result.Signatures[0].Payload.Optional)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package signutil
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/deitch/ocidist/pkg/imageutil"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+const (
+	// SimpleSigningMediaType the media type of a layer that holds a simple signing payload, as cosign writes them
+	SimpleSigningMediaType types.MediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	// SignatureArtifactType the artifact type of a signature manifest pushed as a referrer
+	SignatureArtifactType = "application/vnd.dev.cosign.artifact.sig.v1+json"
+	// SignatureAnnotation the annotation on a payload layer with the base64 signature of the payload
+	SignatureAnnotation = "dev.cosignproject.cosign/signature"
+	// PayloadType the type in the critical section of every cosign payload
+	PayloadType = "cosign container image signature"
+)
+
+// Payload the simple signing payload that is signed, binding the signature to a manifest digest
+type Payload struct {
+	Critical Critical               `json:"critical"`
+	Optional map[string]interface{} `json:"optional"`
+}
+
+// Critical the part of a payload that a verifier must check
+type Critical struct {
+	Identity Identity `json:"identity"`
+	Image    Image    `json:"image"`
+	Type     string   `json:"type"`
+}
+
+// Identity the repository that was signed
+type Identity struct {
+	DockerReference string `json:"docker-reference"`
+}
+
+// Image the manifest that was signed
+type Image struct {
+	DockerManifestDigest string `json:"docker-manifest-digest"`
+}
+
+// SignatureTag the tag under which cosign stores the signatures of the manifest with digest, in its repository
+func SignatureTag(digest v1.Hash) string {
+	return fmt.Sprintf("%s-%s.sig", digest.Algorithm, digest.Hex)
+}
+
+// LoadPublicKey parse a PEM-encoded public key, as written by 'cosign generate-key-pair'. Only ECDSA and ED25519
+// keys are supported.
+func LoadPublicKey(b []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %v", err)
+	}
+	switch pub.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey:
+		return pub, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T, must be ECDSA or ED25519", pub)
+}
+
+// VerifySignature check that sig is a signature by pub of payload: for ECDSA, ASN.1 over the sha256 of it, and for
+// ED25519, over the payload itself
+func VerifySignature(pub crypto.PublicKey, payload, sig []byte) error {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(payload)
+		if !ecdsa.VerifyASN1(k, digest[:], sig) {
+			return errors.New("invalid ECDSA signature")
+		}
+		return nil
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, payload, sig) {
+			return errors.New("invalid ED25519 signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported public key type %T", pub)
+}
+
+// Signature a payload in a signature manifest that was verified
+type Signature struct {
+	// Source the reference of the signature manifest, by tag or digest
+	Source  string  `json:"source"`
+	Payload Payload `json:"payload"`
+}
+
+// VerifyImage check each payload layer of sig, a signature manifest, against pub, and that it is bound to the
+// manifest with digest. Returns the payloads that pass, and the reasons any others do not.
+func VerifyImage(pub crypto.PublicKey, digest v1.Hash, sig v1.Image) ([]Payload, []error) {
+	manifest, err := sig.Manifest()
+	if err != nil {
+		return nil, []error{err}
+	}
+	layers, err := sig.Layers()
+	if err != nil {
+		return nil, []error{err}
+	}
+	var (
+		payloads []Payload
+		errs     []error
+	)
+	for i, desc := range manifest.Layers {
+		if desc.MediaType != SimpleSigningMediaType {
+			continue
+		}
+		payload, err := verifyLayer(pub, digest, desc, layers[i])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("layer %s: %v", desc.Digest, err))
+			continue
+		}
+		payloads = append(payloads, *payload)
+	}
+	return payloads, errs
+}
+
+func verifyLayer(pub crypto.PublicKey, digest v1.Hash, desc v1.Descriptor, layer v1.Layer) (*Payload, error) {
+	b64, ok := desc.Annotations[SignatureAnnotation]
+	if !ok {
+		return nil, errors.New("no signature annotation")
+	}
+	sig, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding: %v", err)
+	}
+	rc, err := layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	if err := VerifySignature(pub, b, sig); err != nil {
+		return nil, err
+	}
+	var payload Payload
+	if err := json.Unmarshal(b, &payload); err != nil {
+		return nil, fmt.Errorf("invalid payload: %v", err)
+	}
+	if payload.Critical.Type != PayloadType {
+		return nil, fmt.Errorf("unknown payload type %q", payload.Critical.Type)
+	}
+	if payload.Critical.Image.DockerManifestDigest != digest.String() {
+		return nil, fmt.Errorf("signed digest %s, not %s", payload.Critical.Image.DockerManifestDigest, digest)
+	}
+	return &payload, nil
+}
+
+// FindSignatures get the signature manifests for the manifest with digest in repo: the one under the tag from
+// SignatureTag, and any referrers of it with the cosign signature artifact type. Missing ones are not an error.
+func FindSignatures(repo name.Repository, digest v1.Hash, options ...remote.Option) (map[string]v1.Image, error) {
+	sigs := map[string]v1.Image{}
+	tag := repo.Tag(SignatureTag(digest))
+	img, err := remote.Image(tag, options...)
+	switch {
+	case err == nil:
+		sigs[tag.String()] = img
+	case !isNotFound(err):
+		return nil, fmt.Errorf("unable to get %s: %v", tag, err)
+	}
+
+	ii, err := remote.Referrers(repo.Digest(digest.String()), options...)
+	if err != nil {
+		if isNotFound(err) {
+			return sigs, nil
+		}
+		return nil, fmt.Errorf("unable to get referrers of %s: %v", digest, err)
+	}
+	index, err := ii.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, desc := range index.Manifests {
+		if desc.ArtifactType != SignatureArtifactType {
+			continue
+		}
+		ref := repo.Digest(desc.Digest.String())
+		img, err := remote.Image(ref, options...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get referrer %s: %v", ref, err)
+		}
+		sigs[ref.String()] = img
+	}
+	return sigs, nil
+}
+
+// isNotFound whether err is from the registry not having what was asked for
+func isNotFound(err error) bool {
+	var terr *transport.Error
+	if errors.As(err, &terr) {
+		return terr.StatusCode == 404 || strings.Contains(terr.Error(), string(transport.ManifestUnknownErrorCode))
+	}
+	return false
+}
+
+// Result what was found verifying the signatures of a manifest. For an index, Platforms has the results for each
+// of the manifests in it, other than attestations.
+type Result struct {
+	Digest     v1.Hash      `json:"digest"`
+	Platform   *v1.Platform `json:"platform,omitempty"`
+	Signatures []Signature  `json:"signatures"`
+	// Errors why any signatures found did not verify
+	Errors []string `json:"errors,omitempty"`
+	// Covered whether the manifest has a verified signature of its own, or is in an index that does
+	Covered   bool     `json:"covered"`
+	Platforms []Result `json:"platforms,omitempty"`
+}
+
+// Verified whether the manifest is signed, or for an index, it or every manifest in it is
+func (r *Result) Verified() bool {
+	if r.Covered {
+		return true
+	}
+	if len(r.Platforms) == 0 {
+		return false
+	}
+	for _, p := range r.Platforms {
+		if !p.Covered {
+			return false
+		}
+	}
+	return true
+}
+
+// Verify find and verify, against pub, the signatures of the manifest ref points to, and if it is an index, of
+// each of the manifests in it
+func Verify(ref name.Reference, pub crypto.PublicKey, options ...remote.Option) (*Result, error) {
+	desc, err := remote.Get(ref, options...)
+	if err != nil {
+		return nil, err
+	}
+	repo := ref.Context()
+	result, err := verifyDigest(repo, desc.Digest, pub, options...)
+	if err != nil {
+		return nil, err
+	}
+	if !desc.MediaType.IsIndex() {
+		return result, nil
+	}
+	ii, err := desc.ImageIndex()
+	if err != nil {
+		return nil, err
+	}
+	index, err := ii.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range index.Manifests {
+		// attestations are about the images, not images to run, so need no signature of their own
+		if imageutil.IsAttestation(child) {
+			continue
+		}
+		childResult, err := verifyDigest(repo, child.Digest, pub, options...)
+		if err != nil {
+			return nil, err
+		}
+		childResult.Platform = child.Platform
+		childResult.Covered = childResult.Covered || result.Covered
+		result.Platforms = append(result.Platforms, *childResult)
+	}
+	return result, nil
+}
+
+// verifyDigest find and verify the signatures of the manifest with digest in repo
+func verifyDigest(repo name.Repository, digest v1.Hash, pub crypto.PublicKey, options ...remote.Option) (*Result, error) {
+	sigs, err := FindSignatures(repo, digest, options...)
+	if err != nil {
+		return nil, err
+	}
+	var sources []string
+	for source := range sigs {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	result := &Result{Digest: digest, Signatures: []Signature{}}
+	for _, source := range sources {
+		payloads, errs := VerifyImage(pub, digest, sigs[source])
+		for _, p := range payloads {
+			result.Signatures = append(result.Signatures, Signature{Source: source, Payload: p})
+		}
+		for _, err := range errs {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", source, err))
+		}
+	}
+	result.Covered = len(result.Signatures) > 0
+	return result, nil
+}
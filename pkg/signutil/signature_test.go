@@ -0,0 +1,180 @@
+package signutil_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/deitch/ocidist/pkg/signutil"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// signatureImage a cosign-style signature manifest, signed by priv, for the manifest with digest in repo
+func signatureImage(t *testing.T, priv crypto.Signer, repo name.Repository, digest v1.Hash) v1.Image {
+	payload, err := signutil.NewPayload(repo, digest, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := signutil.SignPayload(priv, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := mutate.Append(mutate.MediaType(empty.Image, types.OCIManifestSchema1), mutate.Addendum{
+		Layer:       static.NewLayer(payload, signutil.SimpleSigningMediaType),
+		Annotations: map[string]string{signutil.SignatureAnnotation: base64.StdEncoding.EncodeToString(sig)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+// publicKey the PEM encoding of the public key of priv, as LoadPublicKey reads it back
+func publicKey(t *testing.T, priv crypto.Signer) crypto.PublicKey {
+	der, err := x509.MarshalPKIXPublicKey(priv.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := signutil.LoadPublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatalf("unable to load public key: %v", err)
+	}
+	return pub
+}
+
+func TestVerify(t *testing.T) {
+	s := httptest.NewServer(registry.New(registry.WithReferrersSupport(true)))
+	defer s.Close()
+	repo, err := name.NewRepository(strings.TrimPrefix(s.URL, "http://") + "/foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	amd64 := &v1.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := &v1.Platform{OS: "linux", Architecture: "arm64"}
+	var adds []mutate.IndexAddendum
+	var children []v1.Hash
+	for _, platform := range []*v1.Platform{amd64, arm64} {
+		img, err := random.Image(100, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest, _ := img.Digest()
+		children = append(children, digest)
+		adds = append(adds, mutate.IndexAddendum{Add: img, Descriptor: v1.Descriptor{Platform: platform}})
+	}
+	// an attestation, as buildkit adds, which is not a platform to sign
+	attestation, err := random.Image(100, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adds = append(adds, mutate.IndexAddendum{Add: attestation, Descriptor: v1.Descriptor{
+		Platform:    &v1.Platform{OS: "unknown", Architecture: "unknown"},
+		Annotations: map[string]string{"vnd.docker.reference.type": "attestation-manifest", "vnd.docker.reference.digest": children[0].String()},
+	}})
+	ii := mutate.AppendManifests(empty.Index, adds...)
+	indexDigest, _ := ii.Digest()
+	if err := remote.WriteIndex(repo.Tag("multi"), ii); err != nil {
+		t.Fatalf("unable to push index: %v", err)
+	}
+
+	// just one platform signed, with a cosign tag
+	if err := remote.Write(repo.Tag(signutil.SignatureTag(children[0])), signatureImage(t, ecKey, repo, children[0])); err != nil {
+		t.Fatalf("unable to push signature: %v", err)
+	}
+	result, err := signutil.Verify(repo.Tag("multi"), publicKey(t, ecKey))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Verified() || len(result.Platforms) != 2 || !result.Platforms[0].Covered || result.Platforms[1].Covered {
+		t.Errorf("expected just %s covered: %+v", amd64, result)
+	}
+
+	// every platform signed, the attestation not, is verified
+	if err := remote.Write(repo.Tag(signutil.SignatureTag(children[1])), signatureImage(t, ecKey, repo, children[1])); err != nil {
+		t.Fatalf("unable to push signature: %v", err)
+	}
+	result, err = signutil.Verify(repo.Tag("multi"), publicKey(t, ecKey))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Verified() || len(result.Platforms) != 2 {
+		t.Errorf("expected every platform covered: %+v", result)
+	}
+
+	// a signature with another key does not count
+	result, err = signutil.Verify(repo.Tag("multi"), publicKey(t, edKey))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Platforms[0].Covered || len(result.Platforms[0].Errors) != 1 {
+		t.Errorf("expected the signature to fail with the wrong key: %+v", result.Platforms[0])
+	}
+
+	// the whole index signed, as a referrer, covers every platform
+	sig := signatureImage(t, edKey, repo, indexDigest)
+	sig = mutate.ConfigMediaType(sig, types.MediaType(signutil.SignatureArtifactType))
+	desc, err := remote.Head(repo.Tag("multi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig = mutate.Subject(sig, *desc).(v1.Image)
+	sigDigest, _ := sig.Digest()
+	if err := remote.Write(repo.Digest(sigDigest.String()), sig); err != nil {
+		t.Fatalf("unable to push referrer: %v", err)
+	}
+	result, err = signutil.Verify(repo.Tag("multi"), publicKey(t, edKey))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Verified() || len(result.Signatures) != 1 || !result.Platforms[0].Covered || !result.Platforms[1].Covered {
+		t.Errorf("expected every platform covered: %+v", result)
+	}
+	if result.Signatures[0].Source != repo.Digest(sigDigest.String()).String() {
+		t.Errorf("mismatched source %s", result.Signatures[0].Source)
+	}
+
+	// a signature for one manifest copied to another is not valid for it
+	other, err := random.Image(100, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(repo.Tag("other"), other); err != nil {
+		t.Fatal(err)
+	}
+	otherDigest, _ := other.Digest()
+	if err := remote.Write(repo.Tag(signutil.SignatureTag(otherDigest)), signatureImage(t, ecKey, repo, children[0])); err != nil {
+		t.Fatal(err)
+	}
+	result, err = signutil.Verify(repo.Tag("other"), publicKey(t, ecKey))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Verified() || len(result.Errors) != 1 || !strings.Contains(result.Errors[0], "signed digest") {
+		t.Errorf("expected a digest mismatch: %+v", result)
+	}
+}
@@ -0,0 +1,225 @@
+package traceutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HARVersion the version of the HAR format written
+const HARVersion = "1.2"
+
+// HAR an HTTP Archive of the entries recorded by NewTransport, kept in a file, for sharing with anyone who can read the
+// format, such as browser developer tools or registry vendors
+type HAR struct {
+	mu   sync.Mutex
+	file *os.File
+	// offset where the end of the file, after the entries so far, starts, to be written over by the next entry
+	offset int64
+	// count of entries so far
+	count int
+	// end the end of the file, that closes the entries and the rest of the log
+	end string
+}
+
+// entries in the file are indented as json.MarshalIndent would for the whole file
+const harEntryIndent = "      "
+
+// NewHAR a HAR to write to the file at path, with creator and version as the tool that wrote it. The file is created
+// empty of entries immediately.
+func NewHAR(path, creator, version string) (*HAR, error) {
+	b, err := json.MarshalIndent(harFile{Log: harLog{Version: HARVersion, Creator: harNameVersion{Name: creator, Version: version}, Entries: []harEntry{}}}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	// everything up to the opening of the empty entries, and everything from their closing
+	i := bytes.LastIndex(b, []byte("[]")) + 1
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &HAR{file: f, offset: int64(i), end: string(b[i:])}, nil
+}
+
+// Add add e to the archive. Only the entry, and the end of the file after it, are written, over the previous end, so
+// that the file is complete after each entry, even if the process exits without any chance to close it.
+func (h *HAR) Add(e *Entry) {
+	b, err := json.MarshalIndent(newHAREntry(e), harEntryIndent, "  ")
+	if err != nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.file == nil {
+		return
+	}
+	sep := ",\n"
+	if h.count == 0 {
+		sep = "\n"
+	}
+	entry := sep + harEntryIndent + string(b)
+	// a failure to write the trace should not fail what is being traced; the offset is kept, so the next entry
+	// writes over whatever part of this one made it, and anything of it past the end is cut off
+	tail := entry + "\n    " + h.end
+	if _, err := h.file.WriteAt([]byte(tail), h.offset); err != nil {
+		return
+	}
+	_ = h.file.Truncate(h.offset + int64(len(tail)))
+	h.offset += int64(len(entry))
+	h.count++
+}
+
+// Close close the file, after which entries are no longer added
+func (h *HAR) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.file == nil {
+		return nil
+	}
+	err := h.file.Close()
+	h.file = nil
+	return err
+}
+
+// the parts of the HAR 1.2 format that are written, per http://www.softwareishard.com/blog/har-12-spec/
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string         `json:"version"`
+	Creator harNameVersion `json:"creator"`
+	Entries []harEntry     `json:"entries"`
+}
+
+type harNameVersion struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	// Error custom field, as HAR allows with a leading underscore, for why there was no response
+	Error string `json:"_error,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// newHAREntry e as a HAR entry. Sizes that are not known, such as of headers, are -1, per the format.
+func newHAREntry(e *Entry) harEntry {
+	entry := harEntry{
+		StartedDateTime: e.Start.Format(time.RFC3339Nano),
+		Time:            milliseconds(e.Wait + e.Receive),
+		Request: harRequest{
+			Method:      e.Method,
+			URL:         e.URL,
+			HTTPVersion: e.Proto,
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(e.RequestHeader),
+			QueryString: []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    e.Sent,
+		},
+		Response: harResponse{
+			Status:      e.Status,
+			StatusText:  http.StatusText(e.Status),
+			HTTPVersion: e.Proto,
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(e.ResponseHeader),
+			Content:     harContent{Size: e.Received, MimeType: e.ResponseHeader.Get("Content-Type")},
+			RedirectURL: e.ResponseHeader.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    e.Received,
+		},
+		Timings: harTimings{Send: 0, Wait: milliseconds(e.Wait), Receive: milliseconds(e.Receive)},
+	}
+	if e.Err != nil {
+		entry.Error = e.Err.Error()
+		entry.Response.BodySize = -1
+	}
+	if u, err := url.Parse(e.URL); err == nil {
+		q := u.Query()
+		var keys []string
+		for k := range q {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			for _, v := range q[k] {
+				entry.Request.QueryString = append(entry.Request.QueryString, harNameValue{Name: k, Value: v})
+			}
+		}
+	}
+	return entry
+}
+
+// harHeaders h as HAR name and value pairs, sorted by name
+func harHeaders(h http.Header) []harNameValue {
+	var keys []string
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := []harNameValue{}
+	for _, k := range keys {
+		for _, v := range h[k] {
+			out = append(out, harNameValue{Name: k, Value: v})
+		}
+	}
+	return out
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
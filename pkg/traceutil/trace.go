@@ -0,0 +1,233 @@
+package traceutil
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Redacted what the values of secret headers and query parameters are replaced with
+const Redacted = "REDACTED"
+
+// redactedHeaders headers whose values are always secret
+var redactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Registry-Auth":     true,
+}
+
+// redactedParams parts of the names of query parameters whose values are secret, such as the tokens and
+// signatures of the pre-signed URLs that registries redirect blob requests to
+var redactedParams = []string{"token", "signature", "sig", "credential", "password", "secret", "key"}
+
+// Entry a request, and the response to it, as it went through the RoundTripper from NewTransport, with secrets redacted
+type Entry struct {
+	Start          time.Time
+	Method, URL    string
+	RequestHeader  http.Header
+	Proto          string
+	Status         int
+	ResponseHeader http.Header
+	// Err why there was no response
+	Err error
+	// Wait until the response headers arrived, and Receive from then until the body was closed
+	Wait, Receive time.Duration
+	// Sent and Received the bytes of the request and response bodies
+	Sent, Received int64
+}
+
+// Option how the RoundTripper from NewTransport records each entry
+type Option func(t *transport)
+
+// WithLogger log each entry, with its headers, to l
+func WithLogger(l *log.Logger) Option {
+	return func(t *transport) {
+		t.recorders = append(t.recorders, func(e *Entry) {
+			logEntry(l, e)
+		})
+	}
+}
+
+// WithHAR add each entry to h
+func WithHAR(h *HAR) Option {
+	return func(t *transport) {
+		t.recorders = append(t.recorders, h.Add)
+	}
+}
+
+type transport struct {
+	base      http.RoundTripper
+	recorders []func(*Entry)
+}
+
+// NewTransport wrap base in an http.RoundTripper that records every request and response, once the body of the
+// response is closed or fails, per opts
+func NewTransport(base http.RoundTripper, opts ...Option) http.RoundTripper {
+	t := &transport{base: base}
+	for _, o := range opts {
+		o(t)
+	}
+	return t
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	e := &Entry{
+		Start:         time.Now(),
+		Method:        req.Method,
+		URL:           RedactURL(req.URL),
+		RequestHeader: RedactHeader(req.Header),
+	}
+	var sent *countingReader
+	if req.Body != nil && req.Body != http.NoBody {
+		sent = &countingReader{ReadCloser: req.Body}
+		req = req.Clone(req.Context())
+		req.Body = sent
+	}
+	resp, err := t.base.RoundTrip(req)
+	e.Wait = time.Since(e.Start)
+	if sent != nil {
+		e.Sent = sent.n.Load()
+	}
+	if err != nil {
+		e.Err = err
+		t.record(e)
+		return resp, err
+	}
+	e.Proto, e.Status, e.ResponseHeader = resp.Proto, resp.StatusCode, RedactHeader(resp.Header)
+	if resp.Body == nil || resp.Body == http.NoBody {
+		t.record(e)
+		return resp, nil
+	}
+	received := &countingReader{ReadCloser: resp.Body}
+	received.done = func(err error) {
+		e.Receive = time.Since(e.Start) - e.Wait
+		e.Received = received.n.Load()
+		if sent != nil {
+			e.Sent = sent.n.Load()
+		}
+		if err != nil && err != io.EOF {
+			e.Err = err
+		}
+		t.record(e)
+	}
+	resp.Body = received
+	return resp, nil
+}
+
+func (t *transport) record(e *Entry) {
+	for _, r := range t.recorders {
+		r(e)
+	}
+}
+
+// countingReader count the bytes read through it, calling done, if set, just once, when it hits an error other
+// than EOF or is closed
+type countingReader struct {
+	io.ReadCloser
+	// n atomic, as net/http may still be reading a request body when the response comes, such as a 401
+	n    atomic.Int64
+	done func(err error)
+	once sync.Once
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n.Add(int64(n))
+	if err != nil && err != io.EOF {
+		c.finish(err)
+	}
+	return n, err
+}
+
+func (c *countingReader) Close() error {
+	err := c.ReadCloser.Close()
+	c.finish(nil)
+	return err
+}
+
+func (c *countingReader) finish(err error) {
+	if c.done != nil {
+		c.once.Do(func() { c.done(err) })
+	}
+}
+
+// RedactHeader a copy of h with the values of secret headers replaced by Redacted, and any URLs in Location
+// redacted per RedactURL
+func RedactHeader(h http.Header) http.Header {
+	out := h.Clone()
+	for k := range out {
+		switch {
+		case redactedHeaders[http.CanonicalHeaderKey(k)]:
+			out[k] = []string{Redacted}
+		case http.CanonicalHeaderKey(k) == "Location":
+			for i, v := range out[k] {
+				if u, err := url.Parse(v); err == nil {
+					out[k][i] = RedactURL(u)
+				}
+			}
+		}
+	}
+	return out
+}
+
+// RedactURL u as a string, with the values of any query parameters that look secret replaced by Redacted
+func RedactURL(u *url.URL) string {
+	if u.RawQuery == "" && u.User == nil {
+		return u.String()
+	}
+	c := *u
+	if c.User != nil {
+		c.User = url.User(c.User.Username())
+	}
+	// the query is only re-encoded, which sorts it, if anything in it is redacted
+	q, redacted := c.Query(), false
+	for k, vs := range q {
+		lk := strings.ToLower(k)
+		for _, p := range redactedParams {
+			if strings.Contains(lk, p) {
+				for i := range vs {
+					vs[i] = Redacted
+				}
+				redacted = true
+				break
+			}
+		}
+	}
+	if redacted {
+		c.RawQuery = q.Encode()
+	}
+	return c.String()
+}
+
+// logEntry log e to l, with a line for the request and response, and one for each header
+func logEntry(l *log.Logger, e *Entry) {
+	if e.Err != nil {
+		l.Printf("trace: %s %s failed after %s, sent %d bytes, received %d bytes: %v", e.Method, e.URL, (e.Wait + e.Receive).Round(time.Millisecond), e.Sent, e.Received, e.Err)
+	} else {
+		l.Printf("trace: %s %s %d %s in %s, sent %d bytes, received %d bytes", e.Method, e.URL, e.Status, http.StatusText(e.Status), (e.Wait + e.Receive).Round(time.Millisecond), e.Sent, e.Received)
+	}
+	logHeader(l, ">", e.RequestHeader)
+	logHeader(l, "<", e.ResponseHeader)
+}
+
+func logHeader(l *log.Logger, dir string, h http.Header) {
+	var keys []string
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			l.Printf("trace:   %s %s: %s", dir, k, v)
+		}
+	}
+}